@@ -15,6 +15,7 @@ import {
 } from "./hooks/useWebSocket";
 import { useSmileDetection } from "./hooks/useSmileDetection";
 import { useUserAuthentication } from "./hooks/useUserAuthentication";
+import { isAdmin, loadSession } from "./session";
 
 import { Webcam } from "./components/Webcam";
 import { SmileStatus } from "./components/SmileStatus";
@@ -47,6 +48,7 @@ const Chat: React.FC = () => {
   const [status, setStatus] = useState(2); // 0: 接続待ち, 1: 接続完了, 2: 接続終了, 3: 接続エラー
   const [clientId, setClientId] = useState<string>("");
   const [nickname, setNickname] = useState<string>("");
+  const [isAdminUser, setIsAdminUser] = useState(false); // 会議の操作はサーバでも管理者のみ受け付ける
   const [smilePoint, setSmilePoint] = useState(0);
   const [totalSmilePoint, setTotalSmilePoint] = useState(0);
   const [totalIdeas, setTotalIdeas] = useState(0);
@@ -78,9 +80,11 @@ const Chat: React.FC = () => {
     setClientId(storedClientId);
   }, []);
 
-  // ローカルストレージからnicknameを取得
+  // セッション(なければローカルストレージ)からnicknameを取得
   useEffect(() => {
-    let storedNickname = localStorage.getItem("nickname") ?? "";
+    const session = loadSession();
+    setIsAdminUser(isAdmin(session));
+    let storedNickname = session?.nickname || localStorage.getItem("nickname") || "";
     if (!storedNickname) {
       storedNickname = "名無しさん";
       localStorage.setItem("nickname", storedNickname);
@@ -310,7 +314,7 @@ const Chat: React.FC = () => {
           {/* 管理用UI */}
           <div className="flex flex-row items-center gap-14 fixed bottom-4 right-4 z-50">
             {/* 動物画像の変更フォーム（Adminのみ表示） */}
-            {nickname === process.env.NEXT_PUBLIC_ADMIN_NICKNAME && (
+            {isAdminUser && (
               <AnimalTypeChanger
                 onChange={(newAnimalType: string) =>
                   sendImageAnimalType(
@@ -358,7 +362,7 @@ const Chat: React.FC = () => {
                 {/* 左側 */}
                 <div className="p-4 border rounded-lg space-y-4 h-full flex flex-col justify-center items-center">
                   <ConnectionStatusButton status={status} />
-                  {nickname === process.env.NEXT_PUBLIC_ADMIN_NICKNAME && (
+                  {isAdminUser && (
                     <OnOffButton
                       onClick={handleOnOffButtonClick}
                       currentStatus={status}
@@ -397,7 +401,7 @@ const Chat: React.FC = () => {
                 <div className="flex items-center gap-2">
                   <ConnectionStatusButton status={status} />
                   <TimerDisplay timer={timer} />
-                  {nickname === process.env.NEXT_PUBLIC_ADMIN_NICKNAME && (
+                  {isAdminUser && (
                     <OnOffButton
                       onClick={handleOnOffButtonClick}
                       currentStatus={status}
@@ -428,7 +432,7 @@ const Chat: React.FC = () => {
                   <UserExpressions userExpressions={userExpressions} />
                 </div>
                 <br />
-                {nickname === process.env.NEXT_PUBLIC_ADMIN_NICKNAME && (
+                {isAdminUser && (
                   <div className="rounded-lg border border-gray-400 p-2">
                     <p>笑顔ポイント: {smilePoint}</p>
                     <p>合計笑顔ポイント: {totalSmilePoint}</p>
@@ -0,0 +1,117 @@
+import React, { useState, useEffect } from "react";
+import { useRouter, useSearchParams } from "next/navigation";
+import LoadingScreen from "./components/LoadingScreen";
+import { saveSession } from "./session";
+
+// 参加コードか招待リンク(/join?invite=...)で会議に参加する
+const Join: React.FC = () => {
+  const searchParams = useSearchParams();
+  const invite = searchParams.get("invite") ?? "";
+  const [code, setCode] = useState<string>(searchParams.get("code") ?? "");
+  const [nickname, setNickname] = useState<string>("");
+  const [error, setError] = useState<string | null>(null);
+  const [isLoading, setIsLoading] = useState(false); // ローディング状態を管理
+  const router = useRouter();
+
+  // 以前アクセスしててlocalStorageにあるなら、そのnicknameをセットする
+  useEffect(() => {
+    let storedNickname = localStorage.getItem("nickname");
+    if (storedNickname) {
+      setNickname(storedNickname);
+    }
+  }, []);
+
+  const handleJoin = async () => {
+    try {
+      const response = await fetch(
+        `${process.env.NEXT_PUBLIC_SERVER_ADDRESS}/join`,
+        {
+          method: "POST",
+          headers: { "Content-Type": "application/json" },
+          body: JSON.stringify(
+            invite
+              ? { invite: invite, nickname: nickname }
+              : { code: code.trim(), nickname: nickname },
+          ),
+        },
+      );
+      if (response.ok) {
+        const data = await response.json();
+        saveSession({
+          token: data.token,
+          nickname: data.nickname,
+          role: data.role,
+          meetingId: data.meetingId,
+        });
+        setError(null);
+        setIsLoading(true); // ローディング開始
+        router.push("/chat");
+      } else if (response.status === 400) {
+        setError(await response.text());
+      } else if (response.status === 404) {
+        setError("Invalid join code");
+      } else if (response.status === 410) {
+        setError("This invite has expired or has already been used");
+      } else if (response.status === 429) {
+        setError("Too many failed attempts. Please try again later");
+      } else {
+        setError("Error joining meeting");
+      }
+    } catch (error) {
+      setError("Error joining meeting");
+    }
+  };
+
+  return (
+    <div>
+      {isLoading ? (
+        <LoadingScreen />
+      ) : (
+        <>
+          <div>
+            <h1>SmileSync</h1>
+            {error && <p style={{ color: "red" }}>{error}</p>}{" "}
+            {/* errorに値がある場合、エラーメッセージを赤色で表示する */}
+            <h2>Nickname</h2>
+            <input
+              type="text"
+              value={nickname}
+              onChange={(e) => setNickname(e.target.value)}
+              placeholder="Enter your nickname"
+              required
+              className="bg-gray-50 border border-gray-300 text-gray-900 text-sm rounded-lg focus:ring-blue-500 focus:border-blue-500 block w-half p-2.5 dark:bg-gray-700 dark:border-gray-600 dark:placeholder-gray-400 dark:text-white dark:focus:ring-blue-500 dark:focus:border-blue-500 mr-2"
+            />
+            {/* 招待リンクから開いた場合は参加コードの入力は不要 */}
+            {!invite && (
+              <>
+                <h2>Join Code</h2>
+                <input
+                  type="text"
+                  value={code}
+                  onChange={(e) => setCode(e.target.value)}
+                  placeholder="Enter join code"
+                  required
+                  className="bg-gray-50 border border-gray-300 text-gray-900 text-sm rounded-lg focus:ring-blue-500 focus:border-blue-500 block w-half p-2.5 dark:bg-gray-700 dark:border-gray-600 dark:placeholder-gray-400 dark:text-white dark:focus:ring-blue-500 dark:focus:border-blue-500 mr-2"
+                />
+              </>
+            )}
+            <button
+              type="button"
+              onClick={handleJoin}
+              className="relative inline-flex items-center justify-center p-0.5 mb-2 me-2 overflow-hidden text-sm font-medium text-gray-900 rounded-lg group bg-gradient-to-br from-purple-600 to-blue-500 group-hover:from-purple-600 group-hover:to-blue-500 hover:text-white dark:text-white focus:ring-4 focus:outline-none focus:ring-blue-300 dark:focus:ring-blue-800 min-w-max"
+              disabled={!nickname.trim() || (!invite && !code.trim())}
+            >
+              {" "}
+              {/* <- 標準のrequiredはボタン押したときにvalidateされるわけではないので、送信前にinputTextが空文字でないかチェックする */}
+              <span className="relative px-5 py-2.5 transition-all ease-in duration-75 bg-white dark:bg-gray-900 rounded-md group-hover:bg-opacity-0">
+                Join
+              </span>
+            </button>
+          </div>
+        </>
+      )}
+    </div>
+  );
+};
+
+export default Join;
@@ -1,6 +1,7 @@
 import React, { useState, useEffect } from "react";
 import { useRouter } from "next/navigation";
 import LoadingScreen from "./components/LoadingScreen";
+import { saveSession } from "./session";
 
 const Login: React.FC = () => {
   const [password, setPassword] = useState<string>("");
@@ -9,6 +10,22 @@ const Login: React.FC = () => {
   const [isLoading, setIsLoading] = useState(false); // ローディング状態を管理
   const router = useRouter();
 
+  // OIDCのログイン後は#token=...&nickname=...&role=...で戻ってくるので、セッションを保存してchatへ
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.hash.slice(1));
+    const token = params.get("token");
+    if (token) {
+      saveSession({
+        token: token,
+        nickname: params.get("nickname") ?? "",
+        role: params.get("role") ?? "",
+      });
+      window.history.replaceState(null, "", window.location.pathname); // tokenを履歴に残さない
+      setIsLoading(true);
+      router.push("/chat");
+    }
+  }, [router]);
+
   // 以前アクセスしててlocalStorageにあるなら、そのnicknameをセットする
   useEffect(() => {
     let storedNickname = localStorage.getItem("nickname");
@@ -31,11 +48,17 @@ const Login: React.FC = () => {
         },
       );
       if (response.ok) {
-        sessionStorage.setItem("login_password", password); // tokenをsession storageに格納
-        localStorage.setItem("nickname", nickname);
+        const data = await response.json();
+        saveSession({
+          token: data.token,
+          nickname: data.nickname,
+          role: data.role,
+        });
         setError(null);
         setIsLoading(true); // ローディング開始
         router.push("/chat");
+      } else if (response.status === 429) {
+        setError("Too many failed attempts. Please try again later");
       } else {
         setError("Invalid password");
       }
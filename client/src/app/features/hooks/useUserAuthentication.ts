@@ -1,10 +1,10 @@
 import { useEffect } from "react";
 import { AppRouterInstance } from "next/dist/shared/lib/app-router-context.shared-runtime";
+import { loadSession } from "../session";
 
 export const useUserAuthentication = (router: AppRouterInstance) => {
   useEffect(() => {
-    const token = sessionStorage.getItem("login_password");
-    if (!token) {
+    if (!loadSession()) {
       router.push("/login");
     }
   }, [router]);
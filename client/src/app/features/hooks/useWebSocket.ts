@@ -1,5 +1,6 @@
 import { Dispatch, SetStateAction } from "react";
 import ReconnectingWebSocket from "reconnecting-websocket";
+import { loadSession, websocketUrl } from "../session";
 
 export const startConnectWebSocket = (
   socketRef: React.MutableRefObject<ReconnectingWebSocket | null>,
@@ -14,14 +15,13 @@ export const startConnectWebSocket = (
   setClientsList: Dispatch<SetStateAction<string[]>>,
   setStatus: Dispatch<SetStateAction<number>> // 0: 接続待ち, 1: 接続完了, 2: 接続終了, 3: 接続エラー
 ) => {
-  // 0. すでに接続されている場合は何もしない
-  if (socketRef.current) {
+  // 0. すでに接続されている場合、セッションがない場合は何もしない
+  const session = loadSession();
+  if (socketRef.current || !session) {
     return;
   }
-  // 1. websocketオブジェクトを生成し、サーバとの接続を開始
-  const websocket = new ReconnectingWebSocket(
-    `${process.env.NEXT_PUBLIC_SERVER_WEBSOCKET}/ws`
-  );
+  // 1. websocketオブジェクトを生成し、セッションのtokenを付けてサーバとの接続を開始
+  const websocket = new ReconnectingWebSocket(websocketUrl(session));
   socketRef.current = websocket;
   // 2. websocketに自分のnicknameを教える
   websocket.onopen = () => {
@@ -0,0 +1,43 @@
+// /login・/join・OIDCのログインでサーバが発行したセッション
+export type Session = {
+  token: string;
+  nickname: string;
+  role: string;
+  meetingId?: string; // 参加コードで参加した会議。ログインのセッションではなし(既定の会議)
+};
+
+const sessionKey = "session";
+
+// タブを閉じたら消えるようsession storageに格納する
+export const saveSession = (session: Session) => {
+  sessionStorage.setItem(sessionKey, JSON.stringify(session));
+  localStorage.setItem("nickname", session.nickname);
+};
+
+export const loadSession = (): Session | null => {
+  const stored = sessionStorage.getItem(sessionKey);
+  if (!stored) {
+    return null;
+  }
+  try {
+    const session = JSON.parse(stored) as Session;
+    return session.token ? session : null;
+  } catch {
+    return null;
+  }
+};
+
+export const clearSession = () => {
+  sessionStorage.removeItem(sessionKey);
+};
+
+export const isAdmin = (session: Session | null) => session?.role === "admin";
+
+// tokenのない接続はサーバで拒否されるので、セッションのtokenと会議を指定して接続する
+export const websocketUrl = (session: Session) => {
+  const params = new URLSearchParams({ token: session.token });
+  if (session.meetingId) {
+    params.set("meeting", session.meetingId);
+  }
+  return `${process.env.NEXT_PUBLIC_SERVER_WEBSOCKET}/ws?${params.toString()}`;
+};
@@ -0,0 +1,15 @@
+"use client";
+
+import { Suspense } from "react";
+import Join from "../features/Join";
+
+// useSearchParamsを使うのでSuspenseで囲む
+const JoinPage = () => {
+  return (
+    <Suspense>
+      <Join />
+    </Suspense>
+  );
+};
+
+export default JoinPage;
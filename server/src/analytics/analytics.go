@@ -0,0 +1,230 @@
+package analytics
+
+import (
+	"math"
+	"smile-sync/src/event"
+	"sort"
+	"time"
+)
+
+type Options struct {
+	Window        time.Duration // SmilePointとIdeaを集計する時間窓の幅
+	MaxLag        int           // 相関を調べるずらし幅の最大(時間窓の数)
+	BurstLookback time.Duration // Ideaの直前の何秒間のSmilePointを見るか
+	BurstFactor   float64       // 平均の何倍以上のSmilePointをバーストとみなすか
+}
+
+func DefaultOptions() Options {
+	return Options{
+		Window:        60 * time.Second,
+		MaxLag:        5,
+		BurstLookback: 30 * time.Second,
+		BurstFactor:   2,
+	}
+}
+
+// SmileをIdeaよりLagWindows個分の時間窓だけ前にずらしたときの相関。正ならSmileが先行
+type LagCorrelation struct {
+	LagWindows  int     `json:"lagWindows"`
+	LagSeconds  int64   `json:"lagSeconds"`
+	Correlation float64 `json:"correlation"`
+}
+
+type Bursts struct {
+	LookbackSeconds      int64   `json:"lookbackSeconds"`
+	BaselineSmilePoint   float64 `json:"baselineSmilePoint"`   // 会議全体で平均した、LookbackSeconds間のSmilePoint
+	AvgSmilePointBefore  float64 `json:"avgSmilePointBefore"`  // Ideaの直前LookbackSeconds間のSmilePointの平均
+	Lift                 float64 `json:"lift"`                 // AvgSmilePointBefore / BaselineSmilePoint
+	IdeasPrecededByBurst int     `json:"ideasPrecededByBurst"` // 直前にバーストがあったIdeaの数
+	Ideas                int     `json:"ideas"`
+}
+
+type ParticipantContribution struct {
+	Nickname             string  `json:"nickname"`
+	SmilePoint           int     `json:"smilePoint"`
+	SmileShare           float64 `json:"smileShare"`
+	Ideas                int     `json:"ideas"`
+	IdeaShare            float64 `json:"ideaShare"`
+	IdeasPrecededByBurst int     `json:"ideasPrecededByBurst"`
+}
+
+type Analysis struct {
+	MeetingId       string                    `json:"meetingId"`
+	WindowSeconds   int64                     `json:"windowSeconds"`
+	SmileRate       []float64                 `json:"smileRate"` // 各時間窓の1分あたりのSmilePoint
+	IdeaRate        []float64                 `json:"ideaRate"`  // 各時間窓の1分あたりのIdea数
+	Correlation     float64                   `json:"correlation"`
+	LagCorrelations []LagCorrelation          `json:"lagCorrelations"`
+	BestLag         LagCorrelation            `json:"bestLag"`
+	Bursts          Bursts                    `json:"bursts"`
+	Participants    []ParticipantContribution `json:"participants"`
+}
+
+// 会議のイベントから、SmileとIdeaの関係を分析する
+func Analyze(meetingId string, events []event.Event, opts Options) Analysis {
+	smiles := make([]event.Event, 0)
+	ideas := make([]event.Event, 0)
+	var last int64
+	for _, ev := range event.Sorted(events) {
+		switch ev.Type {
+		case event.SmilePoint:
+			smiles = append(smiles, ev)
+		case event.Idea:
+			ideas = append(ideas, ev)
+		default:
+			continue
+		}
+		last = max(last, ev.SinceMeetingStart)
+	}
+
+	windowSeconds := int64(opts.Window.Seconds())
+	windows := int(last/windowSeconds) + 1
+	perMinute := 60 / float64(windowSeconds)
+	a := Analysis{
+		MeetingId:       meetingId,
+		WindowSeconds:   windowSeconds,
+		SmileRate:       make([]float64, windows),
+		IdeaRate:        make([]float64, windows),
+		LagCorrelations: make([]LagCorrelation, 0, 2*opts.MaxLag+1),
+		Participants:    make([]ParticipantContribution, 0),
+	}
+	for _, ev := range smiles {
+		a.SmileRate[ev.SinceMeetingStart/windowSeconds] += float64(ev.Point) * perMinute
+	}
+	for _, ev := range ideas {
+		a.IdeaRate[ev.SinceMeetingStart/windowSeconds] += perMinute
+	}
+
+	a.Correlation = pearson(a.SmileRate, a.IdeaRate)
+	for lag := -opts.MaxLag; lag <= opts.MaxLag; lag++ {
+		a.LagCorrelations = append(a.LagCorrelations, LagCorrelation{
+			LagWindows:  lag,
+			LagSeconds:  int64(lag) * windowSeconds,
+			Correlation: laggedPearson(a.SmileRate, a.IdeaRate, lag),
+		})
+	}
+	// 正の相関が最も強いずらし幅を、SmileとIdeaの時間差とみなす。同程度ならずらし幅が小さい方を優先
+	a.BestLag = a.LagCorrelations[opts.MaxLag]
+	for d := 1; d <= opts.MaxLag; d++ {
+		for _, lc := range []LagCorrelation{a.LagCorrelations[opts.MaxLag+d], a.LagCorrelations[opts.MaxLag-d]} {
+			if lc.Correlation > a.BestLag.Correlation+1e-9 {
+				a.BestLag = lc
+			}
+		}
+	}
+
+	burstBefore := analyzeBursts(&a, smiles, ideas, last, opts)
+	a.Participants = contributions(smiles, ideas, burstBefore)
+	return a
+}
+
+// 各Ideaの直前にバーストがあったかどうかを返す
+func analyzeBursts(a *Analysis, smiles, ideas []event.Event, last int64, opts Options) []bool {
+	lookback := int64(opts.BurstLookback.Seconds())
+	total := 0
+	for _, ev := range smiles {
+		total += ev.Point
+	}
+	a.Bursts = Bursts{LookbackSeconds: lookback, Ideas: len(ideas)}
+	if last > 0 {
+		a.Bursts.BaselineSmilePoint = float64(total) * float64(min(lookback, last)) / float64(last)
+	}
+
+	burstBefore := make([]bool, len(ideas))
+	sum := 0
+	for i, idea := range ideas {
+		points := 0
+		for _, smile := range smiles {
+			if smile.SinceMeetingStart >= idea.SinceMeetingStart-lookback && smile.SinceMeetingStart <= idea.SinceMeetingStart && !smile.Timestamp.After(idea.Timestamp) {
+				points += smile.Point
+			}
+		}
+		sum += points
+		if points > 0 && float64(points) >= opts.BurstFactor*a.Bursts.BaselineSmilePoint {
+			burstBefore[i] = true
+			a.Bursts.IdeasPrecededByBurst++
+		}
+	}
+	if len(ideas) > 0 {
+		a.Bursts.AvgSmilePointBefore = float64(sum) / float64(len(ideas))
+	}
+	if a.Bursts.BaselineSmilePoint > 0 {
+		a.Bursts.Lift = a.Bursts.AvgSmilePointBefore / a.Bursts.BaselineSmilePoint
+	}
+	return burstBefore
+}
+
+func contributions(smiles, ideas []event.Event, burstBefore []bool) []ParticipantContribution {
+	byNickname := make(map[string]*ParticipantContribution)
+	participant := func(nickname string) *ParticipantContribution {
+		p, ok := byNickname[nickname]
+		if !ok {
+			p = &ParticipantContribution{Nickname: nickname}
+			byNickname[nickname] = p
+		}
+		return p
+	}
+	totalSmilePoint := 0
+	for _, ev := range smiles {
+		participant(ev.Nickname).SmilePoint += ev.Point
+		totalSmilePoint += ev.Point
+	}
+	for i, ev := range ideas {
+		p := participant(ev.Nickname)
+		p.Ideas++
+		if burstBefore[i] {
+			p.IdeasPrecededByBurst++
+		}
+	}
+
+	participants := make([]ParticipantContribution, 0, len(byNickname))
+	for _, p := range byNickname {
+		if totalSmilePoint > 0 {
+			p.SmileShare = float64(p.SmilePoint) / float64(totalSmilePoint)
+		}
+		if len(ideas) > 0 {
+			p.IdeaShare = float64(p.Ideas) / float64(len(ideas))
+		}
+		participants = append(participants, *p)
+	}
+	sort.Slice(participants, func(i, j int) bool {
+		return participants[i].Nickname < participants[j].Nickname
+	})
+	return participants
+}
+
+// xをlagだけ後ろにずらしてyと比べたときの相関(x[t]とy[t+lag])
+func laggedPearson(x, y []float64, lag int) float64 {
+	if lag < 0 {
+		return laggedPearson(y, x, -lag)
+	}
+	if lag >= len(x) {
+		return 0
+	}
+	return pearson(x[:len(x)-lag], y[lag:])
+}
+
+// ピアソンの相関係数。分散が0の場合は0を返す
+func pearson(x, y []float64) float64 {
+	n := float64(len(x))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY float64
+	for i := range x {
+		sumX += x[i]
+		sumY += y[i]
+	}
+	meanX, meanY := sumX/n, sumY/n
+	var cov, varX, varY float64
+	for i := range x {
+		dx, dy := x[i]-meanX, y[i]-meanY
+		cov += dx * dy
+		varX += dx * dx
+		varY += dy * dy
+	}
+	if varX == 0 || varY == 0 {
+		return 0
+	}
+	return cov / math.Sqrt(varX*varY)
+}
@@ -0,0 +1,39 @@
+package analytics
+
+import (
+	"smile-sync/src/event"
+	"testing"
+	"time"
+)
+
+func TestAnalyze(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	var events []event.Event
+	add := func(ev event.Event) {
+		ev.Seq = int64(len(events) + 1)
+		ev.Timestamp = start.Add(time.Duration(ev.SinceMeetingStart) * time.Second)
+		events = append(events, ev)
+	}
+	// 1分目と3分目に笑いが集中し、その次の分にアイデアが出る
+	for _, minute := range []int64{1, 3} {
+		for i := int64(0); i < 5; i++ {
+			add(event.Event{Type: event.SmilePoint, SinceMeetingStart: minute*60 + 40 + i, Nickname: "a", Point: 2})
+		}
+		add(event.Event{Type: event.Idea, SinceMeetingStart: minute*60 + 65, Nickname: "b"})
+	}
+	add(event.Event{Type: event.SmilePoint, SinceMeetingStart: 5*60 + 30, Nickname: "b", Point: 1})
+
+	a := Analyze("m1", events, DefaultOptions())
+	if len(a.SmileRate) != 6 || a.SmileRate[1] != 10 || a.IdeaRate[2] != 1 {
+		t.Fatalf("SmileRate = %v, IdeaRate = %v", a.SmileRate, a.IdeaRate)
+	}
+	if a.BestLag.LagWindows != 1 || a.BestLag.Correlation < 0.9 {
+		t.Errorf("BestLag = %+v, want smiles leading ideas by 1 window", a.BestLag)
+	}
+	if a.Bursts.Ideas != 2 || a.Bursts.IdeasPrecededByBurst != 2 || a.Bursts.Lift <= 1 {
+		t.Errorf("Bursts = %+v", a.Bursts)
+	}
+	if len(a.Participants) != 2 || a.Participants[0].SmileShare <= 0.9 || a.Participants[1].IdeaShare != 1 {
+		t.Errorf("Participants = %+v", a.Participants)
+	}
+}
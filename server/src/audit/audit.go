@@ -0,0 +1,165 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// 記録する操作
+const (
+	MeetingCreate   = "meeting.create"
+	MeetingStart    = "meeting.start"
+	MeetingPause    = "meeting.pause"
+	MeetingStop     = "meeting.stop"
+	MeetingSettings = "meeting.settings"
+	MeetingSchedule = "meeting.schedule"
+	MeetingAgenda   = "meeting.agenda"
+	MeetingSection  = "meeting.section"
+	InviteCreate    = "invite.create"
+	UserSave        = "user.save"
+	UserDelete      = "user.delete"
+	// 参加者への操作は participant.kick, participant.mute のように記録する
+	ParticipantPrefix = "participant."
+)
+
+// 操作の経路
+const (
+	ViaWebsocket = "websocket"
+	ViaAPI       = "api"
+	ViaCLI       = "cli"
+	ViaScheduler = "scheduler" // 予定による自動の開始・終了
+)
+
+// 誰がいつ何をどう変えたか
+type Entry struct {
+	Id        string                 `firestore:"-" json:"id"`
+	Timestamp time.Time              `firestore:"timestamp" json:"timestamp"`
+	Actor     string                 `firestore:"actor" json:"actor"` // 操作した人のNickname
+	Via       string                 `firestore:"via" json:"via"`
+	Action    string                 `firestore:"action" json:"action"`
+	MeetingId string                 `firestore:"meeting_id,omitempty" json:"meetingId,omitempty"`
+	Target    string                 `firestore:"target,omitempty" json:"target,omitempty"` // 操作の対象(参加者、アカウントなど)
+	Before    map[string]interface{} `firestore:"before,omitempty" json:"before,omitempty"`
+	After     map[string]interface{} `firestore:"after,omitempty" json:"after,omitempty"`
+}
+
+// 記録の保存先
+type Store interface {
+	AppendAudit(ctx context.Context, entry Entry) error
+}
+
+type Query struct {
+	From      time.Time // 操作日時がFrom以上(ゼロ値なら制限なし)
+	To        time.Time // 操作日時がTo未満(ゼロ値なら制限なし)
+	MeetingId string
+	Actor     string
+	Action    string // 末尾が"."なら前方一致(例: participant.)
+	Limit     int
+	PageToken string
+}
+
+func (q Query) Matches(e Entry) bool {
+	if q.MeetingId != "" && e.MeetingId != q.MeetingId {
+		return false
+	}
+	if q.Actor != "" && e.Actor != q.Actor {
+		return false
+	}
+	if q.Action != "" {
+		if q.Action[len(q.Action)-1] == '.' {
+			return len(e.Action) > len(q.Action) && e.Action[:len(q.Action)] == q.Action
+		}
+		return e.Action == q.Action
+	}
+	return true
+}
+
+// 保存を待てる記録の数。超えた分はslogにのみ残す
+const bufferSize = 256
+
+// 操作を記録する。websocketの読み込みやタイマーを止めないよう、保存はバックグラウンドで行う。
+// nilのLogは記録しない
+type Log struct {
+	store   Store
+	now     func() time.Time
+	entries chan pendingEntry
+	done    chan struct{}
+	mu      sync.Mutex
+	closed  bool
+}
+
+type pendingEntry struct {
+	ctx   context.Context
+	entry Entry
+}
+
+func NewLog(store Store) *Log {
+	l := &Log{
+		store:   store,
+		now:     time.Now,
+		entries: make(chan pendingEntry, bufferSize),
+		done:    make(chan struct{}),
+	}
+	go l.run()
+	return l
+}
+
+// 保存に失敗しても操作自体は取り消さず、ログに残す
+func (l *Log) Record(ctx context.Context, entry Entry) {
+	if l == nil {
+		return
+	}
+	if entry.Timestamp.IsZero() {
+		entry.Timestamp = l.now()
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		slog.ErrorContext(ctx, "Audit log is closed", entryAttrs(entry)...)
+		return
+	}
+	// リクエストの終了後に保存するので、キャンセルは引き継がない
+	select {
+	case l.entries <- pendingEntry{ctx: context.WithoutCancel(ctx), entry: entry}:
+	default:
+		slog.ErrorContext(ctx, "Audit queue is full", entryAttrs(entry)...)
+	}
+}
+
+// 受け付けた記録を全て保存するまで待つ。Close後のRecordは保存しない
+func (l *Log) Close(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	if !l.closed {
+		l.closed = true
+		close(l.entries)
+	}
+	l.mu.Unlock()
+	select {
+	case <-l.done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("%d audit entries not saved: %w", len(l.entries), ctx.Err())
+	}
+}
+
+func (l *Log) run() {
+	defer close(l.done)
+	for p := range l.entries {
+		attrs := entryAttrs(p.entry)
+		if err := l.store.AppendAudit(p.ctx, p.entry); err != nil {
+			slog.ErrorContext(p.ctx, "Failed to save audit entry", append(attrs, "error", err)...)
+			continue
+		}
+		slog.InfoContext(p.ctx, "Audit", attrs...)
+	}
+}
+
+func entryAttrs(entry Entry) []any {
+	return []any{"action", entry.Action, "actor", entry.Actor, "via", entry.Via, "meeting_id", entry.MeetingId, "target", entry.Target, "before", entry.Before, "after", entry.After}
+}
@@ -0,0 +1,99 @@
+package audit
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type memoryStore []Entry
+
+func (m *memoryStore) AppendAudit(ctx context.Context, entry Entry) error {
+	if entry.Action == "fail" {
+		return errors.New("unavailable")
+	}
+	*m = append(*m, entry)
+	return nil
+}
+
+func TestRecord(t *testing.T) {
+	store := &memoryStore{}
+	l := NewLog(store)
+	now := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+
+	l.Record(context.Background(), Entry{Actor: "admin", Action: MeetingStart, After: map[string]interface{}{"status": "active"}})
+	l.Record(context.Background(), Entry{Actor: "admin", Action: "fail"})
+	// 保存はバックグラウンドで行うので、Closeで完了を待つ
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(*store) != 1 || !(*store)[0].Timestamp.Equal(now) {
+		t.Errorf("entries = %+v", *store)
+	}
+	// Close後の記録は保存しない
+	l.Record(context.Background(), Entry{Actor: "admin", Action: MeetingStop})
+	if len(*store) != 1 {
+		t.Errorf("entries after close = %+v", *store)
+	}
+
+	// nilのLogは何もしない
+	var disabled *Log
+	disabled.Record(context.Background(), Entry{Action: MeetingStart})
+	if err := disabled.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+// 保存が終わらなくても、Recordは待たずに戻る
+func TestRecordDoesNotBlock(t *testing.T) {
+	store := &blockingStore{release: make(chan struct{})}
+	l := NewLog(store)
+	done := make(chan struct{})
+	go func() {
+		for range bufferSize + 10 {
+			l.Record(context.Background(), Entry{Action: MeetingStart})
+		}
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Record blocked while the store was unavailable")
+	}
+	close(store.release)
+	if err := l.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type blockingStore struct {
+	release chan struct{}
+}
+
+func (b *blockingStore) AppendAudit(ctx context.Context, entry Entry) error {
+	<-b.release
+	return nil
+}
+
+func TestQueryMatches(t *testing.T) {
+	e := Entry{Actor: "admin", Action: "participant.mute", MeetingId: "m1"}
+	cases := []struct {
+		q    Query
+		want bool
+	}{
+		{Query{}, true},
+		{Query{MeetingId: "m1", Actor: "admin"}, true},
+		{Query{MeetingId: "m2"}, false},
+		{Query{Action: ParticipantPrefix}, true},
+		{Query{Action: "participant.mute"}, true},
+		{Query{Action: "participant"}, false},
+		{Query{Action: MeetingStart}, false},
+	}
+	for _, c := range cases {
+		if got := c.q.Matches(e); got != c.want {
+			t.Errorf("%+v.Matches = %v, want %v", c.q, got, c.want)
+		}
+	}
+}
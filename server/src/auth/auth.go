@@ -0,0 +1,194 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	RoleAdmin  = "admin"
+	RoleMember = "member"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid nickname or password")
+	ErrUserNotFound       = errors.New("user not found")
+	ErrInvalidNickname    = errors.New("invalid nickname")
+)
+
+// ログイン試行が多すぎるため、一定時間ログインを受け付けない
+type LockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("too many failed login attempts, retry after %s", e.RetryAfter.Round(time.Second))
+}
+
+// 個別のパスワードを持つアカウント
+type User struct {
+	Nickname     string    `firestore:"nickname"`
+	PasswordHash string    `firestore:"password_hash"`
+	Role         string    `firestore:"role"`
+	CreatedAt    time.Time `firestore:"created_at"`
+	UpdatedAt    time.Time `firestore:"updated_at"`
+}
+
+// アカウントの保存先。見つからない場合はErrUserNotFoundを返す
+type Store interface {
+	GetUser(ctx context.Context, nickname string) (*User, error)
+	SaveUser(ctx context.Context, user User) error
+	DeleteUser(ctx context.Context, nickname string) error
+	ListUsers(ctx context.Context) ([]User, error)
+}
+
+func NewUser(nickname, password, role string, now time.Time) (User, error) {
+	if err := ValidateNickname(nickname); err != nil {
+		return User{}, err
+	}
+	if role != RoleAdmin && role != RoleMember {
+		return User{}, fmt.Errorf("role must be %s or %s, got %q", RoleAdmin, RoleMember, role)
+	}
+	if len(password) < 8 {
+		return User{}, errors.New("password must be at least 8 characters")
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	return User{
+		Nickname:     nickname,
+		PasswordHash: string(hash),
+		Role:         role,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}, nil
+}
+
+// NicknameはドキュメントIDに使うので、/を含むものや長すぎるものは使えない
+func ValidateNickname(nickname string) error {
+	if strings.TrimSpace(nickname) == "" {
+		return fmt.Errorf("%w: nickname is required", ErrInvalidNickname)
+	}
+	if len(nickname) > 64 || strings.Contains(nickname, "/") || nickname == "." || nickname == ".." {
+		return fmt.Errorf("%w %q", ErrInvalidNickname, nickname)
+	}
+	return nil
+}
+
+type Options struct {
+	// 全員で共有するパスワード。アカウントのないNicknameはこのパスワードで参加者としてログインする
+	SharedPassword string
+	// アカウントを作成する前から使える管理者
+	AdminNickname string
+	AdminPassword string
+	Limiter       LimiterOptions
+	Signer        *TokenSigner
+	SessionTTL    time.Duration // ログインや参加で発行するセッションの有効期間
+}
+
+type Authenticator struct {
+	store   Store
+	invites InviteStore
+	opts    Options
+	limiter *Limiter
+	now     func() time.Time
+}
+
+func NewAuthenticator(store Store, invites InviteStore, opts Options) *Authenticator {
+	return &Authenticator{
+		store:   store,
+		invites: invites,
+		opts:    opts,
+		limiter: NewLimiter(opts.Limiter),
+		now:     time.Now,
+	}
+}
+
+// NicknameとパスワードからUserを返す。失敗が続いたIPとNicknameは一定時間ロックする
+func (a *Authenticator) Login(ctx context.Context, ip, nickname, password string) (*User, error) {
+	now := a.now()
+	keys := []string{"ip:" + ip, "nickname:" + strings.ToLower(nickname)}
+	if retryAfter := a.limiter.Locked(now, keys...); retryAfter > 0 {
+		return nil, &LockedError{RetryAfter: retryAfter}
+	}
+	user, err := a.authenticate(ctx, nickname, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		a.limiter.Fail(now, keys...)
+		return nil, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	// 同じIPの他の利用者の失敗は残しておく
+	a.limiter.Reset(keys[1])
+	return user, nil
+}
+
+// ログインしたUserのセッションを発行する。会議を限定しないので、既定の会議と管理者のAPIに使える
+func (a *Authenticator) NewSession(user *User) (string, error) {
+	return a.opts.Signer.Sign(Claims{Kind: KindSession, Nickname: user.Nickname, Role: user.Role}, a.opts.SessionTTL)
+}
+
+func (a *Authenticator) VerifySession(token string) (Claims, error) {
+	return a.opts.Signer.Verify(token, KindSession)
+}
+
+// 外部のIDプロバイダでのログイン中の状態を署名する。Cookieに保存し、コールバックで確認する
+func (a *Authenticator) SignLoginState(claims Claims, ttl time.Duration) (string, error) {
+	claims.Kind = KindLoginState
+	return a.opts.Signer.Sign(claims, ttl)
+}
+
+func (a *Authenticator) VerifyLoginState(token string) (Claims, error) {
+	return a.opts.Signer.Verify(token, KindLoginState)
+}
+
+// アカウントがない場合に比較するハッシュ。NewUserと同じコストにする
+var dummyPasswordHash = []byte("$2a$10$PvjdwyAyKkCOS3cE68OvIOC56Zkpt9MRoPk4tyv8rvVHY7I77kHW6")
+
+// テストで呼び出しを確認するため差し替えられるようにしておく
+var compareHashAndPassword = bcrypt.CompareHashAndPassword
+
+func (a *Authenticator) authenticate(ctx context.Context, nickname, password string) (*User, error) {
+	if nickname == "" || password == "" {
+		return nil, ErrInvalidCredentials
+	}
+	user, err := a.store.GetUser(ctx, nickname)
+	if err == nil {
+		if compareHashAndPassword([]byte(user.PasswordHash), []byte(password)) != nil {
+			return nil, ErrInvalidCredentials
+		}
+		return user, nil
+	}
+	if !errors.Is(err, ErrUserNotFound) {
+		return nil, err
+	}
+	// 応答時間からアカウントの有無を推測されないよう、アカウントがなくてもbcryptで比較する
+	compareHashAndPassword(dummyPasswordHash, []byte(password))
+	// アカウントのないNickname
+	if a.opts.AdminNickname != "" && nickname == a.opts.AdminNickname {
+		if !equal(password, a.opts.AdminPassword) {
+			return nil, ErrInvalidCredentials
+		}
+		return &User{Nickname: nickname, Role: RoleAdmin}, nil
+	}
+	if a.opts.SharedPassword == "" || !equal(password, a.opts.SharedPassword) {
+		return nil, ErrInvalidCredentials
+	}
+	return &User{Nickname: nickname, Role: RoleMember}, nil
+}
+
+// 長さも含めて比較時間から推測されないよう、ハッシュ同士を定数時間で比較する
+func equal(a, b string) bool {
+	ha := sha256.Sum256([]byte(a))
+	hb := sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(ha[:], hb[:]) == 1
+}
@@ -0,0 +1,181 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type memoryStore map[string]User
+
+func (m memoryStore) GetUser(ctx context.Context, nickname string) (*User, error) {
+	u, ok := m[nickname]
+	if !ok {
+		return nil, ErrUserNotFound
+	}
+	return &u, nil
+}
+
+func (m memoryStore) SaveUser(ctx context.Context, user User) error {
+	m[user.Nickname] = user
+	return nil
+}
+
+func (m memoryStore) DeleteUser(ctx context.Context, nickname string) error {
+	delete(m, nickname)
+	return nil
+}
+
+func (m memoryStore) ListUsers(ctx context.Context) ([]User, error) {
+	users := make([]User, 0, len(m))
+	for _, u := range m {
+		users = append(users, u)
+	}
+	return users, nil
+}
+
+func newTestAuthenticator(t *testing.T) (*Authenticator, *time.Time) {
+	t.Helper()
+	store := memoryStore{}
+	alice, err := NewUser("alice", "alice-password", RoleAdmin, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.SaveUser(context.Background(), alice)
+
+	a := NewAuthenticator(store, memoryInvites{}, Options{
+		SharedPassword: "shared-password",
+		AdminNickname:  "admin",
+		AdminPassword:  "admin-password",
+		Limiter:        LimiterOptions{MaxFailures: 3, MaxFailuresPerIP: 5, Lockout: time.Minute},
+		Signer:         NewTokenSigner([]byte("secret")),
+		SessionTTL:     time.Hour,
+	})
+	now := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+	a.opts.Signer.now = a.now
+	return a, &now
+}
+
+func TestLogin(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ctx := context.Background()
+	cases := []struct {
+		nickname, password string
+		role               string
+	}{
+		{"alice", "alice-password", RoleAdmin},
+		{"alice", "shared-password", ""}, // アカウントがあれば共有パスワードは使えない
+		{"admin", "admin-password", RoleAdmin},
+		{"admin", "shared-password", ""},
+		{"bob", "shared-password", RoleMember},
+		{"bob", "wrong", ""},
+		{"bob", "", ""},
+	}
+	for i, c := range cases {
+		// ロックされないようにIPを変える
+		user, err := a.Login(ctx, string(rune('a'+i)), c.nickname, c.password)
+		if c.role == "" {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Login(%s, %s) err = %v, want ErrInvalidCredentials", c.nickname, c.password, err)
+			}
+			continue
+		}
+		if err != nil || user.Role != c.role {
+			t.Errorf("Login(%s, %s) = %+v, %v, want role %s", c.nickname, c.password, user, err, c.role)
+		}
+	}
+}
+
+// アカウントの有無で応答時間が変わらないよう、アカウントがなくてもbcryptで比較する
+func TestLoginComparesHashWithoutAccount(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	var hashes []string
+	compare := compareHashAndPassword
+	compareHashAndPassword = func(hash, password []byte) error {
+		hashes = append(hashes, string(hash))
+		return compare(hash, password)
+	}
+	defer func() { compareHashAndPassword = compare }()
+
+	for _, nickname := range []string{"alice", "bob", "admin"} {
+		a.Login(context.Background(), nickname, nickname, "wrong-password")
+	}
+	if len(hashes) != 3 || hashes[1] != string(dummyPasswordHash) || hashes[2] != string(dummyPasswordHash) {
+		t.Errorf("compared hashes = %q", hashes)
+	}
+	if bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte("wrong-password")) != bcrypt.ErrMismatchedHashAndPassword {
+		t.Error("dummy hash is not a valid bcrypt hash")
+	}
+	if cost, err := bcrypt.Cost(dummyPasswordHash); err != nil || cost != bcrypt.DefaultCost {
+		t.Errorf("dummy hash cost = %d, %v, want %d", cost, err, bcrypt.DefaultCost)
+	}
+}
+
+func TestLoginLocksNickname(t *testing.T) {
+	a, now := newTestAuthenticator(t)
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		a.Login(ctx, "10.0.0.1", "alice", "wrong")
+	}
+	// 別のIPからでも、正しいパスワードでもロック中は拒否する
+	_, err := a.Login(ctx, "10.0.0.2", "ALICE", "alice-password")
+	var locked *LockedError
+	if !errors.As(err, &locked) || locked.RetryAfter != time.Minute {
+		t.Fatalf("err = %v, want LockedError", err)
+	}
+	// 他のNicknameは影響を受けない
+	if _, err := a.Login(ctx, "10.0.0.2", "bob", "shared-password"); err != nil {
+		t.Errorf("bob: %v", err)
+	}
+
+	*now = now.Add(time.Minute)
+	if _, err := a.Login(ctx, "10.0.0.2", "alice", "alice-password"); err != nil {
+		t.Errorf("after lockout: %v", err)
+	}
+}
+
+func TestLoginLocksIP(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ctx := context.Background()
+	for _, nickname := range []string{"a", "b", "c", "d", "e"} {
+		a.Login(ctx, "10.0.0.1", nickname, "wrong")
+	}
+	var locked *LockedError
+	if _, err := a.Login(ctx, "10.0.0.1", "bob", "shared-password"); !errors.As(err, &locked) {
+		t.Errorf("err = %v, want LockedError", err)
+	}
+	if _, err := a.Login(ctx, "10.0.0.2", "bob", "shared-password"); err != nil {
+		t.Errorf("other IP: %v", err)
+	}
+}
+
+func TestSuccessResetsNicknameFailures(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		a.Login(ctx, "10.0.0.1", "alice", "wrong")
+	}
+	if _, err := a.Login(ctx, "10.0.0.1", "alice", "alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	a.Login(ctx, "10.0.0.1", "alice", "wrong")
+	if _, err := a.Login(ctx, "10.0.0.1", "alice", "alice-password"); err != nil {
+		t.Errorf("err = %v, failures should have been reset", err)
+	}
+}
+
+func TestNewUserValidates(t *testing.T) {
+	if _, err := NewUser("a/b", "long-enough", RoleMember, time.Now()); err == nil {
+		t.Error("expected error for nickname with /")
+	}
+	if _, err := NewUser("carol", "short", RoleMember, time.Now()); err == nil {
+		t.Error("expected error for short password")
+	}
+	if _, err := NewUser("carol", "long-enough", "owner", time.Now()); err == nil {
+		t.Error("expected error for unknown role")
+	}
+}
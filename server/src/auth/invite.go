@@ -0,0 +1,145 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInviteNotFound = errors.New("invite not found")
+	ErrInviteExists   = errors.New("invite code already exists")
+	ErrInviteExpired  = errors.New("invite expired")
+	ErrInviteUsed     = errors.New("invite already used")
+)
+
+const (
+	// 読み間違えやすい文字(0とO、1とIとL)を除いた英数字
+	codeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+	codeLength   = 6
+	// 期限のない参加コードでも、招待リンクのトークンには期限が必要なので十分長くする
+	inviteLinkMaxTTL = 365 * 24 * time.Hour
+)
+
+// 会議への参加コード
+type Invite struct {
+	Code      string    `firestore:"code" json:"code"`
+	MeetingId string    `firestore:"meeting_id" json:"meetingId"`
+	CreatedBy string    `firestore:"created_by" json:"createdBy"`
+	CreatedAt time.Time `firestore:"created_at" json:"createdAt"`
+	ExpiresAt time.Time `firestore:"expires_at" json:"expiresAt,omitempty"` // ゼロ値なら期限なし
+	SingleUse bool      `firestore:"single_use" json:"singleUse"`
+	UsedBy    string    `firestore:"used_by" json:"usedBy,omitempty"`
+	UsedAt    time.Time `firestore:"used_at" json:"usedAt,omitempty"`
+}
+
+// 参加コードの保存先
+type InviteStore interface {
+	// 同じコードが既にあればErrInviteExistsを返す
+	CreateInvite(ctx context.Context, invite Invite) error
+	// コードを読み込んでredeemを呼び、変更を保存する。複数の参加者が同時に使っても1回ずつ処理されること
+	RedeemInvite(ctx context.Context, code string, redeem func(invite *Invite) error) (*Invite, error)
+}
+
+func newCode() (string, error) {
+	var b strings.Builder
+	for i := 0; i < codeLength; i++ {
+		n, err := rand.Int(rand.Reader, big.NewInt(int64(len(codeAlphabet))))
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(codeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// 会議の参加コードと、招待リンクに使うトークンを発行する。ttlが0なら期限なし
+func (a *Authenticator) CreateInvite(ctx context.Context, meetingId, createdBy string, ttl time.Duration, singleUse bool) (Invite, string, error) {
+	now := a.now()
+	invite := Invite{
+		MeetingId: meetingId,
+		CreatedBy: createdBy,
+		CreatedAt: now,
+		SingleUse: singleUse,
+	}
+	linkTTL := inviteLinkMaxTTL
+	if ttl > 0 {
+		invite.ExpiresAt = now.Add(ttl)
+		linkTTL = ttl
+	}
+	// コードが衝突した場合は作り直す
+	for attempt := 0; ; attempt++ {
+		code, err := newCode()
+		if err != nil {
+			return Invite{}, "", err
+		}
+		invite.Code = code
+		err = a.invites.CreateInvite(ctx, invite)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, ErrInviteExists) || attempt >= 4 {
+			return Invite{}, "", err
+		}
+	}
+	link, err := a.opts.Signer.Sign(Claims{Kind: KindInvite, MeetingId: meetingId, Code: invite.Code}, linkTTL)
+	if err != nil {
+		return Invite{}, "", err
+	}
+	return invite, link, nil
+}
+
+// 参加コードか招待リンクのトークンで会議に参加し、その会議にのみ使えるセッションを返す。
+// コードの総当たりを防ぐため、失敗が続いたIPはロックする
+func (a *Authenticator) Join(ctx context.Context, ip, codeOrLink, nickname string) (Claims, string, error) {
+	now := a.now()
+	key := "ip:" + ip
+	if retryAfter := a.limiter.Locked(now, key); retryAfter > 0 {
+		return Claims{}, "", &LockedError{RetryAfter: retryAfter}
+	}
+	if err := ValidateNickname(nickname); err != nil {
+		return Claims{}, "", err
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(codeOrLink))
+	// 招待リンクのトークンは署名を含むので"."で区切られている
+	if strings.Contains(codeOrLink, ".") {
+		claims, err := a.opts.Signer.Verify(codeOrLink, KindInvite)
+		if err != nil {
+			a.limiter.Fail(now, key)
+			return Claims{}, "", ErrInviteNotFound
+		}
+		code = claims.Code
+	}
+
+	invite, err := a.invites.RedeemInvite(ctx, code, func(invite *Invite) error {
+		if !invite.ExpiresAt.IsZero() && !now.Before(invite.ExpiresAt) {
+			return ErrInviteExpired
+		}
+		if invite.SingleUse {
+			if !invite.UsedAt.IsZero() {
+				return ErrInviteUsed
+			}
+			invite.UsedBy = nickname
+			invite.UsedAt = now
+		}
+		return nil
+	})
+	if errors.Is(err, ErrInviteNotFound) {
+		a.limiter.Fail(now, key)
+		return Claims{}, "", err
+	}
+	if err != nil {
+		return Claims{}, "", err
+	}
+
+	claims := Claims{Kind: KindSession, Nickname: nickname, Role: RoleMember, MeetingId: invite.MeetingId}
+	token, err := a.opts.Signer.Sign(claims, a.opts.SessionTTL)
+	if err != nil {
+		return Claims{}, "", err
+	}
+	return claims, token, nil
+}
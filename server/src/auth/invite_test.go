@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type memoryInvites map[string]Invite
+
+var memoryInvitesMu sync.Mutex
+
+func (m memoryInvites) CreateInvite(ctx context.Context, invite Invite) error {
+	memoryInvitesMu.Lock()
+	defer memoryInvitesMu.Unlock()
+	if _, ok := m[invite.Code]; ok {
+		return ErrInviteExists
+	}
+	m[invite.Code] = invite
+	return nil
+}
+
+func (m memoryInvites) RedeemInvite(ctx context.Context, code string, redeem func(invite *Invite) error) (*Invite, error) {
+	memoryInvitesMu.Lock()
+	defer memoryInvitesMu.Unlock()
+	invite, ok := m[code]
+	if !ok {
+		return nil, ErrInviteNotFound
+	}
+	if err := redeem(&invite); err != nil {
+		return nil, err
+	}
+	m[code] = invite
+	return &invite, nil
+}
+
+func TestJoinWithCode(t *testing.T) {
+	a, now := newTestAuthenticator(t)
+	ctx := context.Background()
+	invite, _, err := a.CreateInvite(ctx, "20240801100000", "admin", time.Hour, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invite.Code) != codeLength || strings.ContainsAny(invite.Code, "01ILO") {
+		t.Errorf("code = %q", invite.Code)
+	}
+
+	claims, token, err := a.Join(ctx, "10.0.0.1", strings.ToLower(invite.Code), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.MeetingId != "20240801100000" || claims.Nickname != "bob" || claims.Role != RoleMember {
+		t.Errorf("claims = %+v", claims)
+	}
+	verified, err := a.VerifySession(token)
+	if err != nil || verified.MeetingId != "20240801100000" {
+		t.Errorf("VerifySession = %+v, %v", verified, err)
+	}
+
+	// Nicknameが不正な場合は区別できるエラーを返す
+	if _, _, err := a.Join(ctx, "10.0.0.1", invite.Code, "a/b"); !errors.Is(err, ErrInvalidNickname) {
+		t.Errorf("err = %v, want ErrInvalidNickname", err)
+	}
+
+	*now = now.Add(time.Hour)
+	if _, _, err := a.Join(ctx, "10.0.0.1", invite.Code, "carol"); !errors.Is(err, ErrInviteExpired) {
+		t.Errorf("err = %v, want ErrInviteExpired", err)
+	}
+}
+
+func TestJoinWithSingleUseLink(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ctx := context.Background()
+	_, link, err := a.CreateInvite(ctx, "20240801100000", "admin", 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Join(ctx, "10.0.0.1", link, "bob"); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Join(ctx, "10.0.0.1", link, "carol"); !errors.Is(err, ErrInviteUsed) {
+		t.Errorf("err = %v, want ErrInviteUsed", err)
+	}
+	// 署名を改ざんしたリンクは使えない
+	if _, _, err := a.Join(ctx, "10.0.0.1", link+"x", "carol"); !errors.Is(err, ErrInviteNotFound) {
+		t.Errorf("err = %v, want ErrInviteNotFound", err)
+	}
+}
+
+func TestJoinLocksIPAfterWrongCodes(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		a.Join(ctx, "10.0.0.1", "WRONG1", "bob")
+	}
+	var locked *LockedError
+	if _, _, err := a.Join(ctx, "10.0.0.1", "WRONG1", "bob"); !errors.As(err, &locked) {
+		t.Errorf("err = %v, want LockedError", err)
+	}
+}
+
+func TestSessionIsNotAnInvite(t *testing.T) {
+	a, _ := newTestAuthenticator(t)
+	token, err := a.NewSession(&User{Nickname: "admin", Role: RoleAdmin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := a.opts.Signer.Verify(token, KindInvite); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("err = %v, want ErrInvalidToken", err)
+	}
+	claims, err := a.VerifySession(token)
+	if err != nil || !claims.IsAdmin() || claims.MeetingId != "" {
+		t.Errorf("claims = %+v, %v", claims, err)
+	}
+}
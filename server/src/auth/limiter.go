@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type LimiterOptions struct {
+	MaxFailures      int           // Nicknameごとに許す連続した失敗の回数
+	MaxFailuresPerIP int           // IPごとに許す失敗の回数。同じネットワークから複数人が参加するので多めにする
+	Lockout          time.Duration // 失敗を数える期間と、上限に達した場合にロックする時間
+}
+
+type attempts struct {
+	failures    int
+	lastFailure time.Time
+}
+
+// ログインの失敗をキー(IPやNickname)ごとに数える
+type Limiter struct {
+	opts LimiterOptions
+	keys map[string]*attempts
+	mu   sync.Mutex
+}
+
+func NewLimiter(opts LimiterOptions) *Limiter {
+	return &Limiter{opts: opts, keys: make(map[string]*attempts)}
+}
+
+func (l *Limiter) maxFailures(key string) int {
+	if strings.HasPrefix(key, "ip:") {
+		return l.opts.MaxFailuresPerIP
+	}
+	return l.opts.MaxFailures
+}
+
+// いずれかのキーがロックされていれば、解除されるまでの時間を返す
+func (l *Limiter) Locked(now time.Time, keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var retryAfter time.Duration
+	for _, key := range keys {
+		a, ok := l.keys[key]
+		if !ok {
+			continue
+		}
+		until := a.lastFailure.Add(l.opts.Lockout)
+		if !now.Before(until) {
+			delete(l.keys, key)
+			continue
+		}
+		if limit := l.maxFailures(key); limit > 0 && a.failures >= limit {
+			retryAfter = max(retryAfter, until.Sub(now))
+		}
+	}
+	return retryAfter
+}
+
+func (l *Limiter) Fail(now time.Time, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.prune(now)
+	for _, key := range keys {
+		a, ok := l.keys[key]
+		if !ok || !now.Before(a.lastFailure.Add(l.opts.Lockout)) {
+			a = &attempts{}
+			l.keys[key] = a
+		}
+		a.failures++
+		a.lastFailure = now
+	}
+}
+
+func (l *Limiter) Reset(keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.keys, key)
+	}
+}
+
+// 期限の切れた記録を削除して、mapが増え続けないようにする。呼び出し側でl.muをロックしておくこと
+func (l *Limiter) prune(now time.Time) {
+	for key, a := range l.keys {
+		if !now.Before(a.lastFailure.Add(l.opts.Lockout)) {
+			delete(l.keys, key)
+		}
+	}
+}
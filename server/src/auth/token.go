@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+const (
+	KindSession = "session" // ログイン後にAPIやwebsocketの認証に使う
+	KindInvite  = "invite"  // 招待リンクに埋め込む
+	// 外部のIDプロバイダでのログイン中に、stateとnonceをCookieに保存する
+	KindLoginState = "login_state"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+type Claims struct {
+	Kind      string `json:"kind"`
+	Nickname  string `json:"nickname,omitempty"`
+	Role      string `json:"role,omitempty"`
+	MeetingId string `json:"meetingId,omitempty"` // 空でなければこの会議にのみ参加できる
+	Code      string `json:"code,omitempty"`      // 招待リンクの参加コード、ログイン中のstate
+	Nonce     string `json:"nonce,omitempty"`     // ログイン中のIDトークンのnonce
+	Verifier  string `json:"verifier,omitempty"`  // ログイン中のPKCEのcode_verifier
+	ExpiresAt int64  `json:"exp"`
+}
+
+func (c Claims) IsAdmin() bool {
+	return c.Role == RoleAdmin
+}
+
+// Claimsに署名したトークンを発行・検証する。形式は base64url(JSON).base64url(HMAC-SHA256)
+type TokenSigner struct {
+	secret []byte
+	now    func() time.Time
+}
+
+func NewTokenSigner(secret []byte) *TokenSigner {
+	return &TokenSigner{secret: secret, now: time.Now}
+}
+
+func (s *TokenSigner) Sign(claims Claims, ttl time.Duration) (string, error) {
+	claims.ExpiresAt = s.now().Add(ttl).Unix()
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(s.mac(encoded)), nil
+}
+
+// 署名と有効期限、種類を確認してClaimsを返す
+func (s *TokenSigner) Verify(token, kind string) (Claims, error) {
+	encoded, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return Claims{}, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, s.mac(encoded)) {
+		return Claims{}, ErrInvalidToken
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil || claims.Kind != kind {
+		return Claims{}, ErrInvalidToken
+	}
+	if s.now().Unix() >= claims.ExpiresAt {
+		return Claims{}, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func (s *TokenSigner) mac(encoded string) []byte {
+	h := hmac.New(sha256.New, s.secret)
+	h.Write([]byte(encoded))
+	return h.Sum(nil)
+}
+
+type claimsKey struct{}
+
+func WithClaims(ctx context.Context, claims Claims) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+// 認証済みのリクエストのClaims
+func ClaimsFrom(ctx context.Context) (Claims, bool) {
+	claims, ok := ctx.Value(claimsKey{}).(Claims)
+	return claims, ok
+}
@@ -0,0 +1,20 @@
+package cli
+
+import (
+	"fmt"
+)
+
+// サーバを起動せずに実行するサブコマンド
+var commands = map[string]func(args []string) error{
+	"migrate": Migrate,
+	"export":  Export,
+	"user":    User,
+}
+
+func Run(args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command: %s", args[0])
+	}
+	return command(args[1:])
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"smile-sync/src/export"
+	"smile-sync/src/utils"
+)
+
+// 会議のログをCSVファイルに書き出す
+//
+//	export -meeting "2024-08-01 10:00:00" -out ./data
+//	export -from 2024-08-01 -to 2024-08-31 -kind smile_points
+func Export(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	meetingId := fs.String("meeting", "", "書き出す会議のDocId")
+	fromDate := fs.String("from", "", "開始日(2006-01-02)。-meetingを指定しない場合に使用")
+	toDate := fs.String("to", "", "終了日(2006-01-02、この日を含む)")
+	kind := fs.String("kind", "", "書き出すログの種類(省略時は全て)")
+	format := fs.String("format", "csv", "出力形式(csvのみ対応)")
+	out := fs.String("out", ".", "出力先のディレクトリ")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *format != "csv" {
+		return fmt.Errorf("unsupported format: %s", *format)
+	}
+	kinds := export.Kinds
+	if *kind != "" {
+		if !export.IsKind(*kind) {
+			return fmt.Errorf("unknown kind: %s", *kind)
+		}
+		kinds = []string{*kind}
+	}
+
+	ctx := context.Background()
+	var meetings []export.Meeting
+	switch {
+	case *meetingId != "":
+		m, err := export.LoadMeeting(ctx, *meetingId)
+		if err != nil {
+			return err
+		}
+		meetings = []export.Meeting{m}
+	case *fromDate != "" && *toDate != "":
+		from, to, err := utils.ParseDateRange(*fromDate, *toDate)
+		if err != nil {
+			return err
+		}
+		if meetings, err = export.LoadMeetings(ctx, from, to); err != nil {
+			return err
+		}
+	default:
+		return errors.New("either -meeting or both -from and -to are required")
+	}
+
+	if err := os.MkdirAll(*out, 0o755); err != nil {
+		return err
+	}
+	for _, k := range kinds {
+		path := filepath.Join(*out, k+".csv")
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		err = export.WriteCSV(f, k, meetings)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		slog.Info("Wrote export", "path", path)
+	}
+	slog.Info("Exported meetings", "meetings", len(meetings))
+	return nil
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"log/slog"
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"time"
+)
+
+// smilepoint_historyの旧形式のドキュメントを、meetings/{id}/events のサブコレクションに変換する
+func Migrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "変換結果を表示するだけで書き込まない")
+	meetingId := fs.String("meeting", "", "変換する会議のDocId(省略時は全て)")
+	force := fs.Bool("force", false, "変換先にイベントが存在する場合も上書きする")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	meetingIds := []string{*meetingId}
+	if *meetingId == "" {
+		ids, err := firebase.ListLegacyMeetingIds(ctx)
+		if err != nil {
+			return err
+		}
+		meetingIds = ids
+	}
+
+	migrated := 0
+	for _, id := range meetingIds {
+		existing, err := firebase.LoadEvents(ctx, id, 0)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 && !*force {
+			slog.Info("Skipped meeting that already has events", "meeting_id", id, "events", len(existing))
+			continue
+		}
+
+		logs, err := firebase.LoadMeetingLogs(ctx, id)
+		if err != nil {
+			return err
+		}
+		events := logs.ToEvents()
+		if len(logs.Events) == 0 && !logs.LevelsMatch(events) {
+			slog.Warn("Levels derived from events do not match smile_level_log", "meeting_id", id)
+		}
+		if *dryRun {
+			slog.Info("Dry run", "meeting_id", id, "events", len(events))
+			continue
+		}
+		if err := firebase.AppendEvents(ctx, id, events); err != nil {
+			return err
+		}
+		// 会議一覧に載るように概要も保存する
+		summary := firebase.NewMeetingSummary(id, event.Replay(events), time.Now())
+		if err := firebase.SaveMeetingSummary(ctx, summary); err != nil {
+			return err
+		}
+		slog.Info("Migrated meeting", "meeting_id", id, "events", len(events))
+		migrated++
+	}
+	slog.Info("Migration finished", "migrated", migrated, "meetings", len(meetingIds))
+	return nil
+}
@@ -0,0 +1,129 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/user"
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/firebase"
+	"strings"
+	"time"
+)
+
+// ログイン用のアカウントを管理する
+//
+//	user add -nickname alice -role admin   (パスワードは標準入力から読み込む)
+//	user remove -nickname alice
+//	user list
+func User(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: user add|remove|list [flags]")
+	}
+	store := firebase.UserStore{}
+	ctx := context.Background()
+	auditLog := audit.NewLog(firebase.AuditStore{})
+	// 終了する前に監査ログの保存を待つ
+	defer func() {
+		if err := auditLog.Close(ctx); err != nil {
+			slog.Error("Failed to save audit entries", "error", err)
+		}
+	}()
+	switch args[0] {
+	case "add":
+		return addUser(ctx, store, auditLog, args[1:], os.Stdin)
+	case "remove":
+		return removeUser(ctx, store, auditLog, args[1:])
+	case "list":
+		return listUsers(ctx, store, os.Stdout)
+	default:
+		return fmt.Errorf("unknown user command: %s", args[0])
+	}
+}
+
+func addUser(ctx context.Context, store auth.Store, auditLog *audit.Log, args []string, stdin io.Reader) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	nickname := fs.String("nickname", "", "ログインに使うNickname")
+	role := fs.String("role", auth.RoleMember, "admin または member")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	// コマンド履歴に残らないよう、パスワードは引数ではなく標準入力から受け取る
+	fmt.Fprint(os.Stderr, "Password: ")
+	password, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && password != "") {
+		return fmt.Errorf("failed to read password: %w", err)
+	}
+	user, err := auth.NewUser(*nickname, strings.TrimRight(password, "\r\n"), *role, time.Now())
+	if err != nil {
+		return err
+	}
+	// 既存のアカウントはパスワードと権限を更新する
+	var before map[string]interface{}
+	if existing, err := store.GetUser(ctx, *nickname); err == nil {
+		user.CreatedAt = existing.CreatedAt
+		before = map[string]interface{}{"role": existing.Role}
+	} else if !errors.Is(err, auth.ErrUserNotFound) {
+		return err
+	}
+	if err := store.SaveUser(ctx, user); err != nil {
+		return err
+	}
+	slog.Info("Saved user", "nickname", user.Nickname, "role", user.Role)
+	auditLog.Record(ctx, audit.Entry{
+		Actor:  cliActor(),
+		Via:    audit.ViaCLI,
+		Action: audit.UserSave,
+		Target: user.Nickname,
+		Before: before,
+		After:  map[string]interface{}{"role": user.Role},
+	})
+	return nil
+}
+
+func removeUser(ctx context.Context, store auth.Store, auditLog *audit.Log, args []string) error {
+	fs := flag.NewFlagSet("user remove", flag.ContinueOnError)
+	nickname := fs.String("nickname", "", "削除するアカウントのNickname")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *nickname == "" {
+		return errors.New("-nickname is required")
+	}
+	if err := store.DeleteUser(ctx, *nickname); err != nil {
+		return err
+	}
+	slog.Info("Removed user", "nickname", *nickname)
+	auditLog.Record(ctx, audit.Entry{
+		Actor:  cliActor(),
+		Via:    audit.ViaCLI,
+		Action: audit.UserDelete,
+		Target: *nickname,
+	})
+	return nil
+}
+
+// CLIを実行したOSのユーザ
+func cliActor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return audit.ViaCLI
+}
+
+func listUsers(ctx context.Context, store auth.Store, w io.Writer) error {
+	users, err := store.ListUsers(ctx)
+	if err != nil {
+		return err
+	}
+	for _, u := range users {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", u.Nickname, u.Role, u.UpdatedAt.Format(time.DateTime))
+	}
+	return nil
+}
@@ -0,0 +1,440 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"smile-sync/src/origin"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+// サーバの設定。値は 既定値 < 設定ファイル(CONFIG_FILE) < .env < 環境変数 の順に上書きされる
+type Config struct {
+	Port          string
+	MetricsPort   string // /metricsを配信するポート。認証がないので、外部に公開しない内部のポートにする
+	ClientAddress string
+	// CLIENT_ADDRESS以外に接続を許可するOrigin(Vercelのプレビュー環境など)。*を使ったパターンも指定できる
+	AllowedOrigins []string
+	Auth           Auth
+	OIDC           OIDC
+	Firestore      Firestore
+	ImageProvider  ImageProvider
+	Websocket      Websocket
+	Persistence    Persistence
+	Log            Log
+	// 読み込みは続けられるが確認したほうがよい点(知らない設定名など)
+	Warnings []string
+}
+
+type Auth struct {
+	LoginPassword         string
+	AdminNickname         string
+	AdminPassword         string
+	MaxLoginFailures      int           // Nicknameごとに、ロックするまでに許すログインの失敗回数
+	MaxLoginFailuresPerIP int           // IPごとに、ロックするまでに許すログインの失敗回数
+	LoginLockout          time.Duration // ロックする時間
+	// セッションと招待リンクの署名に使う鍵。空なら起動ごとに生成するので、再起動すると全て無効になる
+	Secret     string
+	SessionTTL time.Duration // ログインや参加コードで発行するセッションの有効期間
+	InviteTTL  time.Duration // 参加コードの既定の有効期間
+	// X-Forwarded-Forの末尾をClientのIPとして使うか。プロキシ(Cloud Runなど)の後ろで動かす場合のみ有効にする。
+	// 無効なら接続元のアドレスを使う
+	TrustProxy bool
+}
+
+// OIDCのIDプロバイダでのログイン(SSO)。OIDC_ISSUERが空なら使わない
+type OIDC struct {
+	Issuer        string
+	ClientId      string
+	ClientSecret  string
+	RedirectURL   string   // このサーバの/auth/oidc/callbackのURL
+	NicknameClaim string   // Nicknameに使うClaim
+	RoleClaim     string   // 管理者かどうかの判定に使うClaim(文字列か文字列の配列)
+	AdminGroups   []string // RoleClaimにこのいずれかを含めば管理者
+}
+
+func (o OIDC) Enabled() bool {
+	return o.Issuer != ""
+}
+
+type Firestore struct {
+	ProjectId string
+}
+
+// 画像生成API(DALL·E)
+type ImageProvider struct {
+	Endpoint string
+	ApiKey   string
+}
+
+type Websocket struct {
+	PingInterval     time.Duration   // pingの送信間隔
+	PongWait         time.Duration   // pongが返ってこない場合に切断するまでの時間
+	SnapshotInterval time.Duration   // 会議中にスナップショットを保存する間隔
+	EndWarnings      []time.Duration // 予定の会議時間がある場合に、終了の何分前に予告するか(会議ごとに変更できる)
+}
+
+// イベントをFirestoreへ書き込むキュー
+type Persistence struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	MaxDepth      int
+	MaxRetries    int
+	RetryBackoff  time.Duration
+}
+
+type Log struct {
+	Level  string // debug, info, warn, error
+	Format string // text, json
+}
+
+func Default() Config {
+	return Config{
+		Port:        "8080",
+		MetricsPort: "9090",
+		Websocket: Websocket{
+			PingInterval:     10 * time.Second,
+			PongWait:         30 * time.Second,
+			SnapshotInterval: 30 * time.Second,
+			EndWarnings:      []time.Duration{5 * time.Minute, 1 * time.Minute},
+		},
+		Auth: Auth{
+			MaxLoginFailures:      5,
+			MaxLoginFailuresPerIP: 20,
+			LoginLockout:          15 * time.Minute,
+			SessionTTL:            12 * time.Hour,
+			InviteTTL:             24 * time.Hour,
+		},
+		OIDC: OIDC{
+			NicknameClaim: "preferred_username",
+			RoleClaim:     "groups",
+		},
+		Persistence: Persistence{
+			BatchSize:     50,
+			FlushInterval: 1 * time.Second,
+			MaxDepth:      1000,
+			MaxRetries:    3,
+			RetryBackoff:  200 * time.Millisecond,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "text",
+		},
+	}
+}
+
+type setting struct {
+	key      string
+	required bool
+	set      func(c *Config, value string) error
+}
+
+func stringSetting(key string, required bool, field func(c *Config) *string) setting {
+	return setting{key, required, func(c *Config, value string) error {
+		*field(c) = value
+		return nil
+	}}
+}
+
+func durationSetting(key string, field func(c *Config) *time.Duration) setting {
+	return setting{key, false, func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("must be a positive duration such as 10s, got %q", value)
+		}
+		*field(c) = d
+		return nil
+	}}
+}
+
+// カンマ区切りの一覧
+func listSetting(key string, field func(c *Config) *[]string) setting {
+	return setting{key, false, func(c *Config, value string) error {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*field(c) = append(*field(c), item)
+			}
+		}
+		return nil
+	}}
+}
+
+// カンマ区切りの期間の一覧。既定値を置き換える
+func durationListSetting(key string, field func(c *Config) *[]time.Duration) setting {
+	return setting{key, false, func(c *Config, value string) error {
+		var durations []time.Duration
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item == "" {
+				continue
+			}
+			d, err := time.ParseDuration(item)
+			if err != nil || d <= 0 {
+				return fmt.Errorf("must be a comma separated list of positive durations such as 5m,1m, got %q", value)
+			}
+			durations = append(durations, d)
+		}
+		*field(c) = durations
+		return nil
+	}}
+}
+
+func intSetting(key string, field func(c *Config) *int) setting {
+	return setting{key, false, func(c *Config, value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("must be a positive integer, got %q", value)
+		}
+		*field(c) = n
+		return nil
+	}}
+}
+
+func boolSetting(key string, field func(c *Config) *bool) setting {
+	return setting{key, false, func(c *Config, value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false, got %q", value)
+		}
+		*field(c) = b
+		return nil
+	}}
+}
+
+var settings = []setting{
+	stringSetting("PORT", false, func(c *Config) *string { return &c.Port }),
+	stringSetting("METRICS_PORT", false, func(c *Config) *string { return &c.MetricsPort }),
+	stringSetting("CLIENT_ADDRESS", true, func(c *Config) *string { return &c.ClientAddress }),
+	listSetting("ALLOWED_ORIGINS", func(c *Config) *[]string { return &c.AllowedOrigins }),
+	stringSetting("LOGIN_PASSWORD", true, func(c *Config) *string { return &c.Auth.LoginPassword }),
+	stringSetting("ADMIN_NICKNAME", true, func(c *Config) *string { return &c.Auth.AdminNickname }),
+	stringSetting("ADMIN_PASSWORD", true, func(c *Config) *string { return &c.Auth.AdminPassword }),
+	intSetting("LOGIN_MAX_FAILURES", func(c *Config) *int { return &c.Auth.MaxLoginFailures }),
+	intSetting("LOGIN_MAX_FAILURES_PER_IP", func(c *Config) *int { return &c.Auth.MaxLoginFailuresPerIP }),
+	durationSetting("LOGIN_LOCKOUT", func(c *Config) *time.Duration { return &c.Auth.LoginLockout }),
+	stringSetting("AUTH_SECRET", false, func(c *Config) *string { return &c.Auth.Secret }),
+	durationSetting("SESSION_TTL", func(c *Config) *time.Duration { return &c.Auth.SessionTTL }),
+	durationSetting("INVITE_TTL", func(c *Config) *time.Duration { return &c.Auth.InviteTTL }),
+	boolSetting("TRUST_PROXY", func(c *Config) *bool { return &c.Auth.TrustProxy }),
+	stringSetting("OIDC_ISSUER", false, func(c *Config) *string { return &c.OIDC.Issuer }),
+	stringSetting("OIDC_CLIENT_ID", false, func(c *Config) *string { return &c.OIDC.ClientId }),
+	stringSetting("OIDC_CLIENT_SECRET", false, func(c *Config) *string { return &c.OIDC.ClientSecret }),
+	stringSetting("OIDC_REDIRECT_URL", false, func(c *Config) *string { return &c.OIDC.RedirectURL }),
+	stringSetting("OIDC_NICKNAME_CLAIM", false, func(c *Config) *string { return &c.OIDC.NicknameClaim }),
+	stringSetting("OIDC_ROLE_CLAIM", false, func(c *Config) *string { return &c.OIDC.RoleClaim }),
+	listSetting("OIDC_ADMIN_GROUPS", func(c *Config) *[]string { return &c.OIDC.AdminGroups }),
+	stringSetting("FIRESTORE_PROJECT_ID", true, func(c *Config) *string { return &c.Firestore.ProjectId }),
+	stringSetting("DALLE_API_ENDPOINT", false, func(c *Config) *string { return &c.ImageProvider.Endpoint }),
+	stringSetting("DALLE_API_KEY", false, func(c *Config) *string { return &c.ImageProvider.ApiKey }),
+	durationSetting("PING_INTERVAL", func(c *Config) *time.Duration { return &c.Websocket.PingInterval }),
+	durationSetting("PONG_WAIT", func(c *Config) *time.Duration { return &c.Websocket.PongWait }),
+	durationSetting("SNAPSHOT_INTERVAL", func(c *Config) *time.Duration { return &c.Websocket.SnapshotInterval }),
+	durationListSetting("MEETING_END_WARNINGS", func(c *Config) *[]time.Duration { return &c.Websocket.EndWarnings }),
+	intSetting("PERSIST_BATCH_SIZE", func(c *Config) *int { return &c.Persistence.BatchSize }),
+	durationSetting("PERSIST_FLUSH_INTERVAL", func(c *Config) *time.Duration { return &c.Persistence.FlushInterval }),
+	intSetting("PERSIST_MAX_DEPTH", func(c *Config) *int { return &c.Persistence.MaxDepth }),
+	intSetting("PERSIST_MAX_RETRIES", func(c *Config) *int { return &c.Persistence.MaxRetries }),
+	durationSetting("PERSIST_RETRY_BACKOFF", func(c *Config) *time.Duration { return &c.Persistence.RetryBackoff }),
+	stringSetting("LOG_LEVEL", false, func(c *Config) *string { return &c.Log.Level }),
+	stringSetting("LOG_FORMAT", false, func(c *Config) *string { return &c.Log.Format }),
+}
+
+// 以前の.env.exampleやworkflowで使われていた、サーバが読まない設定名
+var aliases = map[string]string{
+	"ADMIN_USERNAME": "ADMIN_NICKNAME",
+	"ADMIN_NICKANME": "ADMIN_NICKNAME",
+}
+
+// .envの場所。debug時は../.env
+var dotEnvPaths = []string{".env", "../.env"}
+
+// 環境変数、.env(あれば)、CONFIG_FILEで指定された設定ファイル(あれば)から設定を読み込み、検証する。
+// 問題があれば全てまとめてエラーとして返す
+func Load() (*Config, error) {
+	return load(os.LookupEnv, dotEnvPaths)
+}
+
+func load(lookupEnv func(string) (string, bool), dotEnvPaths []string) (*Config, error) {
+	cfg := Default()
+	values := make(map[string]string)
+	var errs []error
+
+	if path, ok := lookupEnv("CONFIG_FILE"); ok && path != "" {
+		fileValues, err := readFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+		}
+		errs = append(errs, cfg.checkKeys(path, fileValues)...)
+		maps.Copy(values, fileValues)
+	}
+	for _, path := range dotEnvPaths {
+		if _, err := os.Stat(path); err != nil {
+			continue
+		}
+		dotEnv, err := godotenv.Read(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		errs = append(errs, cfg.checkKeys(path, dotEnv)...)
+		maps.Copy(values, dotEnv)
+		break
+	}
+	for _, s := range settings {
+		if value, ok := lookupEnv(s.key); ok {
+			values[s.key] = value
+		}
+	}
+	for alias, key := range aliases {
+		if _, ok := lookupEnv(alias); ok && values[key] == "" {
+			errs = append(errs, fmt.Errorf("environment variable %s is not used, set %s instead", alias, key))
+		}
+	}
+
+	for _, s := range settings {
+		value := strings.TrimSpace(values[s.key])
+		if value == "" {
+			if s.required {
+				errs = append(errs, fmt.Errorf("%s is required", s.key))
+			}
+			continue
+		}
+		if err := s.set(&cfg, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s %w", s.key, err))
+		}
+	}
+	errs = append(errs, cfg.validate()...)
+	if cfg.Auth.Secret == "" {
+		cfg.Warnings = append(cfg.Warnings, "AUTH_SECRET is not set, sessions and invite links are invalidated on restart")
+	}
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// 設定ファイルや.envに書かれた設定名を確認する。読み替えられる名前はエラー、知らない名前は警告にする
+func (c *Config) checkKeys(source string, values map[string]string) []error {
+	var errs []error
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if slices.ContainsFunc(settings, func(s setting) bool { return s.key == key }) {
+			continue
+		}
+		if canonical, ok := aliases[key]; ok {
+			errs = append(errs, fmt.Errorf("%s: %s is not used, rename it to %s", source, key, canonical))
+			continue
+		}
+		c.Warnings = append(c.Warnings, fmt.Sprintf("%s: unknown setting %s is ignored", source, key))
+	}
+	return errs
+}
+
+func (c *Config) validate() []error {
+	var errs []error
+	if port, err := strconv.Atoi(c.Port); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("PORT must be a port number, got %q", c.Port))
+	}
+	if port, err := strconv.Atoi(c.MetricsPort); err != nil || port < 1 || port > 65535 {
+		errs = append(errs, fmt.Errorf("METRICS_PORT must be a port number, got %q", c.MetricsPort))
+	} else if c.MetricsPort == c.Port {
+		errs = append(errs, fmt.Errorf("METRICS_PORT must differ from PORT (%s)", c.Port))
+	}
+	if c.ClientAddress != "" && !isHTTPURL(c.ClientAddress) {
+		errs = append(errs, fmt.Errorf("CLIENT_ADDRESS must be an http(s) URL, got %q", c.ClientAddress))
+	} else if _, err := origin.Parse(c.Origins()); err != nil {
+		errs = append(errs, fmt.Errorf("ALLOWED_ORIGINS %w", err))
+	}
+	if c.Auth.Secret != "" && len(c.Auth.Secret) < 32 {
+		errs = append(errs, errors.New("AUTH_SECRET must be at least 32 characters"))
+	}
+	if c.OIDC.Enabled() || c.OIDC.ClientId != "" || c.OIDC.RedirectURL != "" {
+		if c.OIDC.Issuer == "" || c.OIDC.ClientId == "" || c.OIDC.RedirectURL == "" {
+			errs = append(errs, errors.New("OIDC_ISSUER, OIDC_CLIENT_ID and OIDC_REDIRECT_URL must be set together"))
+		}
+		if c.OIDC.Issuer != "" && !isHTTPURL(c.OIDC.Issuer) {
+			errs = append(errs, fmt.Errorf("OIDC_ISSUER must be an http(s) URL, got %q", c.OIDC.Issuer))
+		}
+		if c.OIDC.RedirectURL != "" && !isHTTPURL(c.OIDC.RedirectURL) {
+			errs = append(errs, fmt.Errorf("OIDC_REDIRECT_URL must be an http(s) URL, got %q", c.OIDC.RedirectURL))
+		}
+	}
+	if (c.ImageProvider.Endpoint == "") != (c.ImageProvider.ApiKey == "") {
+		errs = append(errs, errors.New("DALLE_API_ENDPOINT and DALLE_API_KEY must be set together"))
+	}
+	if c.ImageProvider.Endpoint != "" && !isHTTPURL(c.ImageProvider.Endpoint) {
+		errs = append(errs, fmt.Errorf("DALLE_API_ENDPOINT must be an http(s) URL, got %q", c.ImageProvider.Endpoint))
+	}
+	if c.Websocket.PongWait <= c.Websocket.PingInterval {
+		errs = append(errs, fmt.Errorf("PONG_WAIT (%s) must be longer than PING_INTERVAL (%s)", c.Websocket.PongWait, c.Websocket.PingInterval))
+	}
+	if !slices.Contains([]string{"debug", "info", "warn", "error"}, strings.ToLower(c.Log.Level)) {
+		errs = append(errs, fmt.Errorf("LOG_LEVEL must be one of debug, info, warn, error, got %q", c.Log.Level))
+	}
+	if !slices.Contains([]string{"text", "json"}, strings.ToLower(c.Log.Format)) {
+		errs = append(errs, fmt.Errorf("LOG_FORMAT must be text or json, got %q", c.Log.Format))
+	}
+	return errs
+}
+
+// ブラウザからの接続を許可するOriginのパターン
+func (c *Config) Origins() []string {
+	origins := make([]string, 0, len(c.AllowedOrigins)+1)
+	if c.ClientAddress != "" {
+		origins = append(origins, c.ClientAddress)
+	}
+	return append(origins, c.AllowedOrigins...)
+}
+
+// 画像生成APIが設定されているかどうか
+func (p ImageProvider) Check() error {
+	if p.Endpoint == "" || p.ApiKey == "" {
+		return errors.New("DALLE_API_ENDPOINT and DALLE_API_KEY are not set")
+	}
+	return nil
+}
+
+func isHTTPURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// YAMLの設定ファイルを読み込む。キーは環境変数名を小文字にしたもの(例: client_address)
+func readFile(path string) (map[string]string, error) {
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("unsupported config file format %q, use .yaml or .yml", ext)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]interface{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	values := make(map[string]string, len(raw))
+	for key, value := range raw {
+		switch value.(type) {
+		case map[string]interface{}, []interface{}:
+			return nil, fmt.Errorf("%s must be a single value", key)
+		case nil:
+			continue
+		}
+		values[strings.ToUpper(key)] = fmt.Sprint(value)
+	}
+	return values, nil
+}
@@ -0,0 +1,256 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func envFrom(values map[string]string) func(string) (string, bool) {
+	return func(key string) (string, bool) {
+		v, ok := values[key]
+		return v, ok
+	}
+}
+
+func requiredEnv() map[string]string {
+	return map[string]string{
+		"CLIENT_ADDRESS":       "http://localhost:3000",
+		"LOGIN_PASSWORD":       "password",
+		"ADMIN_NICKNAME":       "admin",
+		"ADMIN_PASSWORD":       "admin-password",
+		"FIRESTORE_PROJECT_ID": "test-project",
+		"AUTH_SECRET":          "0123456789abcdef0123456789abcdef",
+	}
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadDefaults(t *testing.T) {
+	cfg, err := load(envFrom(requiredEnv()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "8080" || cfg.MetricsPort != "9090" || cfg.Websocket.PingInterval != 10*time.Second || cfg.Persistence.BatchSize != 50 {
+		t.Errorf("defaults not applied: %+v", cfg)
+	}
+	if cfg.Auth.AdminNickname != "admin" || cfg.Firestore.ProjectId != "test-project" {
+		t.Errorf("env not applied: %+v", cfg)
+	}
+}
+
+func TestLoadReportsAllProblems(t *testing.T) {
+	env := map[string]string{
+		"PORT":          "http",
+		"METRICS_PORT":  "http",
+		"PING_INTERVAL": "10",
+		"LOG_FORMAT":    "xml",
+	}
+	_, err := load(envFrom(env), nil)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	for _, want := range []string{
+		"CLIENT_ADDRESS is required",
+		"ADMIN_NICKNAME is required",
+		"PORT must be a port number",
+		"METRICS_PORT must be a port number",
+		"PING_INTERVAL must be a positive duration",
+		"LOG_FORMAT must be text or json",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	configFile := writeFile(t, "config.yaml", "port: 9000\nping_interval: 5s\npong_wait: 20s\npersist_batch_size: 10\n")
+	dotEnv := writeFile(t, ".env", "PORT=9001\nPING_INTERVAL=6s\n")
+	env := requiredEnv()
+	env["CONFIG_FILE"] = configFile
+	env["PORT"] = "9002"
+
+	cfg, err := load(envFrom(env), []string{dotEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Port != "9002" {
+		t.Errorf("Port = %s, want environment value", cfg.Port)
+	}
+	if cfg.Websocket.PingInterval != 6*time.Second {
+		t.Errorf("PingInterval = %s, want .env value", cfg.Websocket.PingInterval)
+	}
+	if cfg.Websocket.PongWait != 20*time.Second || cfg.Persistence.BatchSize != 10 {
+		t.Errorf("config file values not applied: %+v", cfg)
+	}
+}
+
+func TestLoadRejectsMisnamedSettings(t *testing.T) {
+	dotEnv := writeFile(t, ".env", "ADMIN_USERNAME=admin\nTZ=Asia/Tokyo\n")
+	env := requiredEnv()
+	delete(env, "ADMIN_NICKNAME")
+
+	_, err := load(envFrom(env), []string{dotEnv})
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_USERNAME is not used, rename it to ADMIN_NICKNAME") {
+		t.Fatalf("err = %v", err)
+	}
+
+	env = requiredEnv()
+	env["ADMIN_NICKANME"] = "admin"
+	delete(env, "ADMIN_NICKNAME")
+	_, err = load(envFrom(env), nil)
+	if err == nil || !strings.Contains(err.Error(), "ADMIN_NICKANME is not used, set ADMIN_NICKNAME instead") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLoadWarnsUnknownSettings(t *testing.T) {
+	dotEnv := writeFile(t, ".env", "TZ=Asia/Tokyo\n")
+	cfg, err := load(envFrom(requiredEnv()), []string{dotEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "TZ") {
+		t.Errorf("Warnings = %v", cfg.Warnings)
+	}
+}
+
+func TestLoadImageProviderMustBeComplete(t *testing.T) {
+	env := requiredEnv()
+	env["DALLE_API_ENDPOINT"] = "https://api.openai.com/v1/images/generations"
+	_, err := load(envFrom(env), nil)
+	if err == nil || !strings.Contains(err.Error(), "must be set together") {
+		t.Fatalf("err = %v", err)
+	}
+}
+
+func TestLoadAllowedOrigins(t *testing.T) {
+	env := requiredEnv()
+	env["ALLOWED_ORIGINS"] = "https://smilesync-*.vercel.app, https://smilesync.example.com"
+	cfg, err := load(envFrom(env), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"http://localhost:3000", "https://smilesync-*.vercel.app", "https://smilesync.example.com"}
+	if got := cfg.Origins(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("Origins() = %v, want %v", got, want)
+	}
+
+	env["ALLOWED_ORIGINS"] = "https://*.com"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "ALLOWED_ORIGINS invalid origin") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoadAuthSecret(t *testing.T) {
+	env := requiredEnv()
+	env["AUTH_SECRET"] = "short"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "AUTH_SECRET must be at least 32 characters") {
+		t.Errorf("err = %v", err)
+	}
+
+	delete(env, "AUTH_SECRET")
+	cfg, err := load(envFrom(env), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Warnings) != 1 || !strings.Contains(cfg.Warnings[0], "AUTH_SECRET") {
+		t.Errorf("Warnings = %v", cfg.Warnings)
+	}
+}
+
+func TestLoadOIDC(t *testing.T) {
+	env := requiredEnv()
+	env["OIDC_ISSUER"] = "https://accounts.example.com"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "must be set together") {
+		t.Errorf("err = %v", err)
+	}
+
+	env["OIDC_CLIENT_ID"] = "smilesync"
+	env["OIDC_REDIRECT_URL"] = "https://api.example.com/auth/oidc/callback"
+	env["OIDC_ADMIN_GROUPS"] = "smilesync-admins, facilitators"
+	cfg, err := load(envFrom(env), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cfg.OIDC.Enabled() || cfg.OIDC.NicknameClaim != "preferred_username" || len(cfg.OIDC.AdminGroups) != 2 {
+		t.Errorf("OIDC = %+v", cfg.OIDC)
+	}
+}
+
+func TestLoadMeetingEndWarnings(t *testing.T) {
+	env := requiredEnv()
+	cfg, err := load(envFrom(env), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Websocket.EndWarnings, []time.Duration{5 * time.Minute, time.Minute}) {
+		t.Errorf("default EndWarnings = %v", cfg.Websocket.EndWarnings)
+	}
+
+	env["MEETING_END_WARNINGS"] = "10m, 30s"
+	if cfg, err = load(envFrom(env), nil); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(cfg.Websocket.EndWarnings, []time.Duration{10 * time.Minute, 30 * time.Second}) {
+		t.Errorf("EndWarnings = %v", cfg.Websocket.EndWarnings)
+	}
+
+	env["MEETING_END_WARNINGS"] = "5m,soon"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "MEETING_END_WARNINGS") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestLoadTrustProxy(t *testing.T) {
+	env := requiredEnv()
+	cfg, err := load(envFrom(env), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Auth.TrustProxy {
+		t.Error("TrustProxy is enabled by default")
+	}
+
+	env["TRUST_PROXY"] = "true"
+	if cfg, err = load(envFrom(env), nil); err != nil || !cfg.Auth.TrustProxy {
+		t.Errorf("TrustProxy = %v, %v", cfg.Auth.TrustProxy, err)
+	}
+
+	env["TRUST_PROXY"] = "yes"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "TRUST_PROXY") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// メトリクスは公開するポートとは別のポートで配信する
+func TestLoadMetricsPort(t *testing.T) {
+	env := requiredEnv()
+	env["METRICS_PORT"] = "9100"
+	if cfg, err := load(envFrom(env), nil); err != nil || cfg.MetricsPort != "9100" {
+		t.Errorf("MetricsPort = %v, %v", cfg, err)
+	}
+
+	env["METRICS_PORT"] = "8080"
+	if _, err := load(envFrom(env), nil); err == nil || !strings.Contains(err.Error(), "METRICS_PORT must differ from PORT") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// 配布している.env.exampleをそのまま.envにしても起動できること
+func TestLoadEnvExample(t *testing.T) {
+	if _, err := load(envFrom(map[string]string{}), []string{"../../.env.example"}); err != nil {
+		t.Errorf(".env.example: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package event
+
+import "time"
+
+type Type string
+
+const (
+	MeetingStarted     Type = "meetingStarted"
+	MeetingEnded       Type = "meetingEnded"
+	MeetingPaused      Type = "meetingPaused"    // 再開(MeetingStarted)するまで会議時間に数えない
+	MeetingScheduled   Type = "meetingScheduled" // 開始予定日時と予定の会議時間
+	AgendaSet          Type = "agendaSet"
+	SectionStarted     Type = "sectionStarted" // Sectionのセクションに進む
+	SettingsUpdated    Type = "settingsUpdated"
+	ImageAnimalTypeSet Type = "imageAnimalTypeSet" // SettingsUpdatedより前のイベント
+	LevelThresholdsSet Type = "levelThresholdsSet"
+	Message            Type = "message"
+	SmilePoint         Type = "smilePoint"
+	Idea               Type = "idea"
+	Image              Type = "image"
+	// 管理者による参加者への操作。TargetはNicknameかClientId
+	ParticipantKicked   Type = "participantKicked"
+	ParticipantMuted    Type = "participantMuted"
+	ParticipantUnmuted  Type = "participantUnmuted"
+	ParticipantExcluded Type = "participantExcluded" // SmilePointを集計に含めない
+	ParticipantIncluded Type = "participantIncluded"
+	ParticipantBanned   Type = "participantBanned" // 会議が終了するまで接続できない
+	ParticipantUnbanned Type = "participantUnbanned"
+)
+
+// アジェンダの1区切り(ウォームアップ、発散、収束など)
+type Section struct {
+	Title   string `firestore:"title" json:"title"`
+	Seconds int64  `firestore:"seconds" json:"seconds"` // 予定の時間
+}
+
+// 会議中に発生した出来事。会議ごとにSeqの昇順で追記のみ行う
+type Event struct {
+	Seq               int64            `firestore:"seq" json:"seq"`
+	Type              Type             `firestore:"type" json:"type"`
+	Timestamp         time.Time        `firestore:"timestamp" json:"timestamp"`
+	SinceMeetingStart int64            `firestore:"since_meeting_start" json:"sinceMeetingStart"`
+	ClientId          string           `firestore:"client_id,omitempty" json:"clientId,omitempty"`
+	Nickname          string           `firestore:"nickname,omitempty" json:"nickname,omitempty"`
+	Text              string           `firestore:"text,omitempty" json:"text,omitempty"`
+	Point             int              `firestore:"smile_point,omitempty" json:"point,omitempty"`
+	LevelThresholds   []int            `firestore:"level_thresholds,omitempty" json:"levelThresholds,omitempty"`
+	Prompt            string           `firestore:"prompt,omitempty" json:"prompt,omitempty"`
+	ImageUrl          string           `firestore:"image_url,omitempty" json:"imageUrl,omitempty"`
+	ImageAnimalType   string           `firestore:"image_animal_type,omitempty" json:"imageAnimalType,omitempty"`
+	Target            string           `firestore:"target,omitempty" json:"target,omitempty"`
+	Reason            string           `firestore:"reason,omitempty" json:"reason,omitempty"`
+	ScheduledStartAt  time.Time        `firestore:"scheduled_start_at,omitempty" json:"scheduledStartAt,omitempty"`
+	PlannedSeconds    int64            `firestore:"planned_seconds,omitempty" json:"plannedSeconds,omitempty"`
+	WarningSeconds    []int64          `firestore:"warning_seconds,omitempty" json:"warningSeconds,omitempty"`
+	Agenda            []Section        `firestore:"agenda,omitempty" json:"agenda,omitempty"`
+	Settings          *MeetingSettings `firestore:"settings,omitempty" json:"settings,omitempty"`
+	// 会議中のイベントは発生したセクション(1始まり)。0ならアジェンダなし
+	Section int `firestore:"section,omitempty" json:"section,omitempty"`
+}
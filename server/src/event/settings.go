@@ -0,0 +1,60 @@
+package event
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// 画像生成APIが受け付ける画像の大きさ
+var ImageSizes = []string{"1024x1024", "1792x1024", "1024x1792"}
+
+// 会議ごとの設定。開始前に管理者が変更できる
+type MeetingSettings struct {
+	LevelCount         int    `firestore:"level_count" json:"levelCount"`                 // レベルの段階数(2〜MaxLevel)
+	CalibrationSeconds int64  `firestore:"calibration_seconds" json:"calibrationSeconds"` // 開始から何秒間のSmilePointでレベルの閾値を決めるか
+	ImageAnimalType    string `firestore:"image_animal_type" json:"imageAnimalType"`
+	ImageSize          string `firestore:"image_size" json:"imageSize"`
+}
+
+func DefaultSettings() MeetingSettings {
+	return MeetingSettings{
+		LevelCount:         MaxLevel,
+		CalibrationSeconds: 10, // 会議開始後2分後に閾値を設定 -> demo用に10秒後に設定
+		ImageAnimalType:    DefaultAnimalType,
+		ImageSize:          ImageSizes[0],
+	}
+}
+
+// ゼロ値の項目をbaseの値で埋める。一部の項目だけ変更する場合や、設定のない古いスナップショットに使う
+func (ms MeetingSettings) Or(base MeetingSettings) MeetingSettings {
+	if ms.LevelCount == 0 {
+		ms.LevelCount = base.LevelCount
+	}
+	if ms.CalibrationSeconds == 0 {
+		ms.CalibrationSeconds = base.CalibrationSeconds
+	}
+	if strings.TrimSpace(ms.ImageAnimalType) == "" {
+		ms.ImageAnimalType = base.ImageAnimalType
+	}
+	if ms.ImageSize == "" {
+		ms.ImageSize = base.ImageSize
+	}
+	return ms
+}
+
+func (ms MeetingSettings) Validate() error {
+	if ms.LevelCount < 2 || ms.LevelCount > MaxLevel {
+		return fmt.Errorf("levelCount must be between 2 and %d, got %d", MaxLevel, ms.LevelCount)
+	}
+	if ms.CalibrationSeconds < 1 || ms.CalibrationSeconds > 3600 {
+		return fmt.Errorf("calibrationSeconds must be between 1 and 3600, got %d", ms.CalibrationSeconds)
+	}
+	if animalType := strings.TrimSpace(ms.ImageAnimalType); animalType == "" || len(animalType) > 64 {
+		return fmt.Errorf("invalid imageAnimalType %q", ms.ImageAnimalType)
+	}
+	if !slices.Contains(ImageSizes, ms.ImageSize) {
+		return fmt.Errorf("imageSize must be one of %s, got %q", strings.Join(ImageSizes, ", "), ms.ImageSize)
+	}
+	return nil
+}
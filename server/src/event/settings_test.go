@@ -0,0 +1,51 @@
+package event
+
+import "testing"
+
+func TestMeetingSettings(t *testing.T) {
+	if err := DefaultSettings().Validate(); err != nil {
+		t.Fatalf("default settings: %v", err)
+	}
+
+	// 指定した項目だけ変わる
+	ms := MeetingSettings{LevelCount: 5, ImageAnimalType: "cat"}.Or(DefaultSettings())
+	if ms.LevelCount != 5 || ms.ImageAnimalType != "cat" || ms.CalibrationSeconds != 10 || ms.ImageSize != "1024x1024" {
+		t.Errorf("merged = %+v", ms)
+	}
+
+	cases := []MeetingSettings{
+		{LevelCount: 1},
+		{LevelCount: MaxLevel + 1},
+		{CalibrationSeconds: -1},
+		{CalibrationSeconds: 3601},
+		{ImageSize: "512x512"},
+	}
+	for _, c := range cases {
+		if err := c.Or(DefaultSettings()).Validate(); err == nil {
+			t.Errorf("%+v should be invalid", c)
+		}
+	}
+}
+
+func TestSettingsUpdated(t *testing.T) {
+	settings := MeetingSettings{LevelCount: 4, CalibrationSeconds: 30, ImageAnimalType: "cat", ImageSize: "1792x1024"}
+	st := Replay([]Event{
+		{Seq: 1, Type: SettingsUpdated, Settings: &settings},
+		{Seq: 2, Type: MeetingStarted},
+		{Seq: 3, Type: LevelThresholdsSet, LevelThresholds: NewLevelThresholds(5, settings.LevelCount)},
+		{Seq: 4, Type: SmilePoint, Point: 100},
+	})
+	if st.Settings != settings || st.ImageAnimalType != "cat" {
+		t.Errorf("Settings = %+v, ImageAnimalType = %q", st.Settings, st.ImageAnimalType)
+	}
+	// 閾値は3つなので、レベルは4まで
+	if len(st.LevelThresholds) != 3 || st.Level != 4 {
+		t.Errorf("thresholds = %v, level = %d", st.LevelThresholds, st.Level)
+	}
+
+	// 以前のクライアントからの変更も設定に反映する
+	st.Apply(Event{Seq: 5, Type: ImageAnimalTypeSet, ImageAnimalType: "dog"})
+	if st.Settings.ImageAnimalType != "dog" {
+		t.Errorf("Settings.ImageAnimalType = %q", st.Settings.ImageAnimalType)
+	}
+}
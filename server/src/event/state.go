@@ -0,0 +1,294 @@
+package event
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+const (
+	MaxLevel          = 10 // レベルの段階数の上限
+	DefaultAnimalType = "golden retriever"
+)
+
+// イベントを順に適用して得られる会議の状態
+type State struct {
+	Seq                 int64           `firestore:"seq"` // 最後に適用したイベントのSeq
+	IsMeetingActive     bool            `firestore:"is_meeting_active"`
+	IsMeetingPaused     bool            `firestore:"is_meeting_paused"`
+	MeetingStartTime    time.Time       `firestore:"meeting_start_time"`
+	TotalSmilePoint     int             `firestore:"total_smile_point"`
+	TotalIdeas          int             `firestore:"total_ideas"`
+	Level               int             `firestore:"level"`
+	LevelThresholds     []int           `firestore:"level_thresholds"`
+	IsLevelThresholdSet bool            `firestore:"is_level_threshold_set"`
+	ImageUrls           []string        `firestore:"image_urls"`
+	ImageAnimalType     string          `firestore:"image_animal_type"` // Settings.ImageAnimalTypeと同じ
+	Settings            MeetingSettings `firestore:"settings"`
+	Messages            []Event         `firestore:"-"` // スナップショットが大きくならないよう保存せず、イベントから作り直す
+	// 会議一覧で使う集計値
+	FirstStartTime time.Time `firestore:"first_start_time"` // 最初に会議が開始された時刻
+	LastEndTime    time.Time `firestore:"last_end_time"`    // 最後に会議が終了した時刻
+	ActiveSeconds  int64     `firestore:"active_seconds"`   // 終了済みの会議時間の合計
+	MaxLevel       int       `firestore:"max_level"`
+	Participants   []string  `firestore:"participants"` // イベントを送ったClientのNickname(重複なし)
+	// 管理者が制限している参加者のNicknameかClientId。会議が終了すると解除する
+	Muted    []string `firestore:"muted"`    // メッセージを送れない
+	Excluded []string `firestore:"excluded"` // SmilePointを集計に含めない
+	Banned   []string `firestore:"banned"`   // 接続できない
+	// 予定。開始予定日時になると自動で開始し、会議時間がPlannedSecondsに達すると自動で終了する
+	ScheduledStartAt time.Time `firestore:"scheduled_start_at"`
+	IsScheduled      bool      `firestore:"is_scheduled"`    // 開始予定日時を待っているかどうか
+	PlannedSeconds   int64     `firestore:"planned_seconds"` // 0なら自動で終了しない
+	WarningSeconds   []int64   `firestore:"warning_seconds"` // 終了の何秒前に予告するか
+	// アジェンダ。会議を開始すると最初のセクションから進める
+	Agenda              []Section `firestore:"agenda"`
+	Section             int       `firestore:"section"`               // 進行中のセクション(1始まり)。0ならアジェンダなしか開始前
+	SectionStartSeconds int64     `firestore:"section_start_seconds"` // 進行中のセクションが始まった時点の会議時間[s]
+}
+
+func NewState() State {
+	settings := DefaultSettings()
+	return State{
+		Level:           1,
+		LevelThresholds: make([]int, settings.LevelCount-1),
+		ImageUrls:       make([]string, 0),
+		ImageAnimalType: settings.ImageAnimalType,
+		Settings:        settings,
+		Messages:        make([]Event, 0),
+		MaxLevel:        1,
+		Participants:    make([]string, 0),
+		Muted:           make([]string, 0),
+		Excluded:        make([]string, 0),
+		Banned:          make([]string, 0),
+		WarningSeconds:  make([]int64, 0),
+		Agenda:          make([]Section, 0),
+	}
+}
+
+// イベントを1つ適用する。適用済みのSeqのイベントは無視する
+func (st *State) Apply(ev Event) {
+	if ev.Seq != 0 && ev.Seq <= st.Seq {
+		return
+	}
+	switch ev.Type {
+	case MeetingStarted:
+		st.IsMeetingActive = true
+		st.IsMeetingPaused = false
+		st.IsScheduled = false
+		st.MeetingStartTime = ev.Timestamp
+		if st.FirstStartTime.IsZero() {
+			st.FirstStartTime = ev.Timestamp
+		}
+		if st.Section == 0 && len(st.Agenda) > 0 {
+			st.Section = 1
+			st.SectionStartSeconds = st.ActiveSeconds
+		}
+	case MeetingEnded:
+		if st.IsMeetingActive {
+			st.ActiveSeconds += int64(ev.Timestamp.Sub(st.MeetingStartTime).Seconds())
+		}
+		st.IsMeetingActive = false
+		st.IsMeetingPaused = false
+		st.IsScheduled = false
+		st.MeetingStartTime = time.Time{}
+		st.LastEndTime = ev.Timestamp
+		st.Muted = make([]string, 0)
+		st.Excluded = make([]string, 0)
+		st.Banned = make([]string, 0)
+		// 再び開始した場合は最初のセクションから進める
+		st.Section = 0
+		st.SectionStartSeconds = 0
+	case MeetingPaused:
+		if st.IsMeetingActive {
+			st.ActiveSeconds += int64(ev.Timestamp.Sub(st.MeetingStartTime).Seconds())
+		}
+		st.IsMeetingActive = false
+		st.IsMeetingPaused = true
+		st.MeetingStartTime = time.Time{}
+	case MeetingScheduled:
+		st.ScheduledStartAt = ev.ScheduledStartAt
+		// 開始済みの会議は予定の会議時間のみ変更する
+		st.IsScheduled = !ev.ScheduledStartAt.IsZero() && st.FirstStartTime.IsZero()
+		st.PlannedSeconds = ev.PlannedSeconds
+		st.WarningSeconds = append(make([]int64, 0, len(ev.WarningSeconds)), ev.WarningSeconds...)
+	case AgendaSet:
+		st.Agenda = append(make([]Section, 0, len(ev.Agenda)), ev.Agenda...)
+		st.Section = 0
+		st.SectionStartSeconds = 0
+	case SectionStarted:
+		if ev.Section >= 1 && ev.Section <= len(st.Agenda) {
+			st.Section = ev.Section
+			st.SectionStartSeconds = int64(st.Duration(ev.Timestamp).Seconds())
+		}
+	case SettingsUpdated:
+		if ev.Settings != nil {
+			st.Settings = *ev.Settings
+			st.ImageAnimalType = ev.Settings.ImageAnimalType
+			// 閾値を決める前なら、段階数に合わせる
+			if !st.IsLevelThresholdSet {
+				st.LevelThresholds = make([]int, st.Settings.LevelCount-1)
+			}
+		}
+	case ImageAnimalTypeSet:
+		st.ImageAnimalType = ev.ImageAnimalType
+		st.Settings.ImageAnimalType = ev.ImageAnimalType
+	case LevelThresholdsSet:
+		st.LevelThresholds = append([]int(nil), ev.LevelThresholds...)
+		st.IsLevelThresholdSet = true
+	case Message:
+		st.Messages = append(st.Messages, ev)
+	case SmilePoint:
+		st.TotalSmilePoint += ev.Point
+		st.Level = LevelFor(st.TotalSmilePoint, st.LevelThresholds, st.IsLevelThresholdSet)
+		st.MaxLevel = max(st.MaxLevel, st.Level)
+	case Idea:
+		st.TotalIdeas++
+	case Image:
+		st.ImageUrls = append(st.ImageUrls, ev.ImageUrl)
+	case ParticipantMuted:
+		st.Muted = addTarget(st.Muted, ev.Target)
+	case ParticipantUnmuted:
+		st.Muted = removeTarget(st.Muted, ev.Target)
+	case ParticipantExcluded:
+		st.Excluded = addTarget(st.Excluded, ev.Target)
+	case ParticipantIncluded:
+		st.Excluded = removeTarget(st.Excluded, ev.Target)
+	case ParticipantBanned:
+		st.Banned = addTarget(st.Banned, ev.Target)
+	case ParticipantUnbanned:
+		st.Banned = removeTarget(st.Banned, ev.Target)
+	}
+	if ev.Nickname != "" && !slices.Contains(st.Participants, ev.Nickname) {
+		st.Participants = append(st.Participants, ev.Nickname)
+	}
+	if ev.Seq > st.Seq {
+		st.Seq = ev.Seq
+	}
+}
+
+func addTarget(targets []string, target string) []string {
+	if target == "" || slices.Contains(targets, target) {
+		return targets
+	}
+	return append(targets, target)
+}
+
+func removeTarget(targets []string, target string) []string {
+	return slices.DeleteFunc(slices.Clone(targets), func(t string) bool { return t == target })
+}
+
+// NicknameかClientIdが一覧に含まれるかどうか
+func matchesTarget(targets []string, nickname, clientId string) bool {
+	return slices.ContainsFunc(targets, func(t string) bool {
+		return (nickname != "" && t == nickname) || (clientId != "" && t == clientId)
+	})
+}
+
+func (st *State) IsMuted(nickname, clientId string) bool {
+	return matchesTarget(st.Muted, nickname, clientId)
+}
+
+func (st *State) IsExcluded(nickname, clientId string) bool {
+	return matchesTarget(st.Excluded, nickname, clientId)
+}
+
+func (st *State) IsBanned(nickname, clientId string) bool {
+	return matchesTarget(st.Banned, nickname, clientId)
+}
+
+// 開始から現在(now)までの会議時間の合計
+func (st *State) Duration(now time.Time) time.Duration {
+	d := time.Duration(st.ActiveSeconds) * time.Second
+	if st.IsMeetingActive {
+		d += now.Sub(st.MeetingStartTime)
+	}
+	return d
+}
+
+// 予定の会議時間までの残り時間。予定の会議時間がなければfalse
+func (st *State) Remaining(now time.Time) (time.Duration, bool) {
+	if st.PlannedSeconds <= 0 {
+		return 0, false
+	}
+	return max(time.Duration(st.PlannedSeconds)*time.Second-st.Duration(now), 0), true
+}
+
+// 進行中のセクションの予定の時間までの残り時間。超過している場合はマイナス。セクションがなければfalse
+func (st *State) SectionRemaining(now time.Time) (time.Duration, bool) {
+	if st.Section == 0 {
+		return 0, false
+	}
+	elapsed := st.Duration(now) - time.Duration(st.SectionStartSeconds)*time.Second
+	return time.Duration(st.Agenda[st.Section-1].Seconds)*time.Second - elapsed, true
+}
+
+// 合計SmilePointと閾値からレベルを求める
+func LevelFor(totalSmilePoint int, thresholds []int, isThresholdSet bool) int {
+	if !isThresholdSet {
+		return 1
+	}
+	level := 1
+	for i, threshold := range thresholds {
+		if totalSmilePoint >= threshold {
+			level = i + 2
+		}
+	}
+	return level
+}
+
+// 会議開始時点の合計SmilePointを基準に、1, 2, 4, 8...倍を閾値とする
+func NewLevelThresholds(totalSmilePoint, levelCount int) []int {
+	// レベルがlevelCount段階なので、levelCount-1個の閾値を設定
+	thresholds := make([]int, levelCount-1)
+	for i := range thresholds {
+		thresholds[i] = totalSmilePoint * (1 << i)
+	}
+	return thresholds
+}
+
+// イベント列をSeq順に並べ替えて、初期状態から再生する
+func Replay(events []Event) State {
+	st := NewState()
+	for _, ev := range Sorted(events) {
+		st.Apply(ev)
+	}
+	return st
+}
+
+func Sorted(events []Event) []Event {
+	sorted := append([]Event(nil), events...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Seq < sorted[j].Seq
+	})
+	return sorted
+}
+
+// レベルが変化した時点
+type LevelChange struct {
+	Seq               int64     `json:"seq"`
+	Timestamp         time.Time `json:"timestamp"`
+	SinceMeetingStart int64     `json:"sinceMeetingStart"`
+	Level             int       `json:"level"`
+	Section           int       `json:"section,omitempty"` // レベルが変化したセクション
+}
+
+// イベント列を再生し、レベルが変化した時点の一覧を求める
+func LevelChanges(events []Event) []LevelChange {
+	st := NewState()
+	changes := make([]LevelChange, 0)
+	for _, ev := range Sorted(events) {
+		previousLevel := st.Level
+		st.Apply(ev)
+		if st.Level != previousLevel {
+			changes = append(changes, LevelChange{
+				Seq:               ev.Seq,
+				Timestamp:         ev.Timestamp,
+				SinceMeetingStart: ev.SinceMeetingStart,
+				Level:             st.Level,
+				Section:           ev.Section,
+			})
+		}
+	}
+	return changes
+}
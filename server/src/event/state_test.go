@@ -0,0 +1,199 @@
+package event
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplay(t *testing.T) {
+	events := []Event{
+		{Seq: 4, Type: SmilePoint, Point: 10},
+		{Seq: 1, Type: MeetingStarted},
+		{Seq: 2, Type: SmilePoint, Point: 5, Nickname: "a"},
+		{Seq: 3, Type: LevelThresholdsSet, LevelThresholds: NewLevelThresholds(5, MaxLevel)},
+		{Seq: 5, Type: Idea, Nickname: "b"},
+		{Seq: 6, Type: Image, ImageUrl: "https://example.com/1.png"},
+		{Seq: 6, Type: Idea}, // 適用済みのSeqは無視される
+	}
+
+	st := Replay(events)
+	if st.Seq != 6 {
+		t.Errorf("Seq = %d, want 6", st.Seq)
+	}
+	if st.TotalSmilePoint != 15 {
+		t.Errorf("TotalSmilePoint = %d, want 15", st.TotalSmilePoint)
+	}
+	if st.TotalIdeas != 1 {
+		t.Errorf("TotalIdeas = %d, want 1", st.TotalIdeas)
+	}
+	// 閾値は5, 10, 20...なので、15ptはレベル3
+	if st.Level != 3 {
+		t.Errorf("Level = %d, want 3", st.Level)
+	}
+	if st.MaxLevel != 3 || len(st.Participants) != 2 {
+		t.Errorf("MaxLevel = %d, Participants = %v, want 3, [a b]", st.MaxLevel, st.Participants)
+	}
+	if len(st.ImageUrls) != 1 {
+		t.Errorf("ImageUrls = %v, want 1 url", st.ImageUrls)
+	}
+
+	changes := LevelChanges(events)
+	if len(changes) != 1 || changes[0].Seq != 4 || changes[0].Level != 3 {
+		t.Errorf("LevelChanges = %+v, want a single change to level 3 at seq 4", changes)
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	st := Replay([]Event{
+		{Seq: 1, Type: MeetingStarted, Timestamp: start},
+		{Seq: 2, Type: MeetingPaused, Timestamp: start.Add(10 * time.Minute)},
+	})
+	if st.IsMeetingActive || !st.IsMeetingPaused || st.ActiveSeconds != 600 {
+		t.Errorf("after pause: active=%v paused=%v activeSeconds=%d", st.IsMeetingActive, st.IsMeetingPaused, st.ActiveSeconds)
+	}
+
+	// 中断中の時間は会議時間に数えない
+	st.Apply(Event{Seq: 3, Type: MeetingStarted, Timestamp: start.Add(30 * time.Minute)})
+	if !st.IsMeetingActive || st.IsMeetingPaused || !st.FirstStartTime.Equal(start) {
+		t.Errorf("after resume: active=%v paused=%v firstStart=%s", st.IsMeetingActive, st.IsMeetingPaused, st.FirstStartTime)
+	}
+	if d := st.Duration(start.Add(35 * time.Minute)); d != 15*time.Minute {
+		t.Errorf("Duration = %s, want 15m", d)
+	}
+	st.Apply(Event{Seq: 4, Type: MeetingPaused, Timestamp: start.Add(40 * time.Minute)})
+	st.Apply(Event{Seq: 5, Type: MeetingEnded, Timestamp: start.Add(50 * time.Minute)})
+	if st.IsMeetingPaused || st.ActiveSeconds != 1200 {
+		t.Errorf("after end: paused=%v activeSeconds=%d, want false 1200", st.IsMeetingPaused, st.ActiveSeconds)
+	}
+}
+
+func TestSchedule(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	st := Replay([]Event{
+		{Seq: 1, Type: MeetingScheduled, ScheduledStartAt: start, PlannedSeconds: 1800, WarningSeconds: []int64{300, 60}},
+	})
+	if !st.IsScheduled || st.PlannedSeconds != 1800 {
+		t.Errorf("after schedule: scheduled=%v planned=%d", st.IsScheduled, st.PlannedSeconds)
+	}
+
+	st.Apply(Event{Seq: 2, Type: MeetingStarted, Timestamp: start})
+	st.Apply(Event{Seq: 3, Type: MeetingPaused, Timestamp: start.Add(10 * time.Minute)})
+	st.Apply(Event{Seq: 4, Type: MeetingStarted, Timestamp: start.Add(20 * time.Minute)})
+	if st.IsScheduled {
+		t.Error("IsScheduled should be cleared after start")
+	}
+	// 中断中の時間は残り時間から引かない
+	if d, ok := st.Remaining(start.Add(25 * time.Minute)); !ok || d != 15*time.Minute {
+		t.Errorf("Remaining = %s %v, want 15m true", d, ok)
+	}
+	if d, _ := st.Remaining(start.Add(time.Hour)); d != 0 {
+		t.Errorf("Remaining after the limit = %s, want 0", d)
+	}
+
+	// 開始済みの会議は予定の会議時間のみ変わる
+	st.Apply(Event{Seq: 5, Type: MeetingScheduled, ScheduledStartAt: start.Add(time.Hour), PlannedSeconds: 3600})
+	if st.IsScheduled || st.PlannedSeconds != 3600 {
+		t.Errorf("after reschedule: scheduled=%v planned=%d", st.IsScheduled, st.PlannedSeconds)
+	}
+	empty := NewState()
+	if _, ok := empty.Remaining(start); ok {
+		t.Error("Remaining without a planned duration should be false")
+	}
+}
+
+func TestAgenda(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	agenda := []Section{{Title: "warm-up", Seconds: 300}, {Title: "diverge", Seconds: 900}, {Title: "converge", Seconds: 600}}
+	st := Replay([]Event{
+		{Seq: 1, Type: AgendaSet, Agenda: agenda},
+		{Seq: 2, Type: MeetingStarted, Timestamp: start},
+	})
+	if st.Section != 1 {
+		t.Fatalf("Section = %d, want 1", st.Section)
+	}
+	if d, ok := st.SectionRemaining(start.Add(time.Minute)); !ok || d != 4*time.Minute {
+		t.Errorf("SectionRemaining = %s %v, want 4m true", d, ok)
+	}
+
+	// 中断中の時間はセクションの時間に数えない
+	st.Apply(Event{Seq: 3, Type: SectionStarted, Section: 2, Timestamp: start.Add(6 * time.Minute)})
+	st.Apply(Event{Seq: 4, Type: MeetingPaused, Timestamp: start.Add(10 * time.Minute)})
+	st.Apply(Event{Seq: 5, Type: MeetingStarted, Timestamp: start.Add(20 * time.Minute)})
+	if st.Section != 2 || st.SectionStartSeconds != 360 {
+		t.Errorf("Section = %d start=%d, want 2 360", st.Section, st.SectionStartSeconds)
+	}
+	if d, _ := st.SectionRemaining(start.Add(35 * time.Minute)); d != -4*time.Minute {
+		t.Errorf("SectionRemaining after overrun = %s, want -4m", d)
+	}
+
+	// 範囲外のセクションには進まない
+	st.Apply(Event{Seq: 6, Type: SectionStarted, Section: 4, Timestamp: start.Add(36 * time.Minute)})
+	if st.Section != 2 {
+		t.Errorf("Section = %d, want 2", st.Section)
+	}
+
+	// 終了後に再び開始すると最初のセクションから
+	st.Apply(Event{Seq: 7, Type: MeetingEnded, Timestamp: start.Add(40 * time.Minute)})
+	if st.Section != 0 || st.SectionStartSeconds != 0 {
+		t.Errorf("after end: Section = %d start=%d, want 0 0", st.Section, st.SectionStartSeconds)
+	}
+	st.Apply(Event{Seq: 8, Type: MeetingStarted, Timestamp: start.Add(60 * time.Minute)})
+	if st.Section != 1 || st.SectionStartSeconds != st.ActiveSeconds {
+		t.Errorf("after restart: Section = %d start=%d, want 1 %d", st.Section, st.SectionStartSeconds, st.ActiveSeconds)
+	}
+	if d, _ := st.SectionRemaining(start.Add(61 * time.Minute)); d != 4*time.Minute {
+		t.Errorf("SectionRemaining after restart = %s, want 4m", d)
+	}
+
+	empty := NewState()
+	if _, ok := empty.SectionRemaining(start); ok {
+		t.Error("SectionRemaining without an agenda should be false")
+	}
+}
+
+func TestModeration(t *testing.T) {
+	st := Replay([]Event{
+		{Seq: 1, Type: MeetingStarted},
+		{Seq: 2, Type: ParticipantMuted, Target: "alice"},
+		{Seq: 3, Type: ParticipantBanned, Target: "client-1"},
+		{Seq: 4, Type: ParticipantExcluded, Target: "bob"},
+		{Seq: 5, Type: ParticipantIncluded, Target: "bob"},
+	})
+	if !st.IsMuted("alice", "") || st.IsMuted("bob", "") {
+		t.Errorf("Muted = %v", st.Muted)
+	}
+	if !st.IsBanned("carol", "client-1") || st.IsBanned("carol", "client-2") {
+		t.Errorf("Banned = %v", st.Banned)
+	}
+	if st.IsExcluded("bob", "") {
+		t.Errorf("Excluded = %v", st.Excluded)
+	}
+	// 会議が終了すると解除する
+	st.Apply(Event{Seq: 6, Type: MeetingEnded})
+	if st.IsMuted("alice", "") || st.IsBanned("", "client-1") {
+		t.Errorf("moderation not cleared: muted=%v banned=%v", st.Muted, st.Banned)
+	}
+}
+
+func TestLevelFor(t *testing.T) {
+	thresholds := NewLevelThresholds(1, MaxLevel)
+	cases := []struct {
+		total int
+		want  int
+	}{
+		{0, 1},
+		{1, 2},
+		{3, 3},
+		{256, 10},
+		{1000, 10},
+	}
+	for _, c := range cases {
+		if got := LevelFor(c.total, thresholds, true); got != c.want {
+			t.Errorf("LevelFor(%d) = %d, want %d", c.total, got, c.want)
+		}
+	}
+	if got := LevelFor(1000, thresholds, false); got != 1 {
+		t.Errorf("LevelFor before thresholds are set = %d, want 1", got)
+	}
+}
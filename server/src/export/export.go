@@ -0,0 +1,149 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"smile-sync/src/utils"
+	"strconv"
+	"time"
+)
+
+// 出力できるログの種類。ファイル名は"{kind}.csv"
+var Kinds = []string{"smile_points", "ideas", "levels", "images", "messages"}
+
+// 全ての種類で共通の列。種類ごとの列はこの後ろに続く
+// sectionはアジェンダのセクション(1始まり)。アジェンダがなければ空
+var commonColumns = []string{"meeting_id", "seq", "timestamp", "since_meeting_start", "client_id", "nickname", "section"}
+
+var kindColumns = map[string][]string{
+	"smile_points": {"smile_point"},
+	"ideas":        {},
+	"levels":       {"level"},
+	"images":       {"prompt", "image_url"},
+	"messages":     {"text"},
+}
+
+// Firestoreからの読み込み。テストではメモリ上の実装に差し替える
+var (
+	loadEvents     = firebase.LoadEvents
+	listMeetingIds = firebase.ListMeetingIds
+)
+
+type Meeting struct {
+	Id     string
+	Events []event.Event
+}
+
+func IsKind(kind string) bool {
+	_, ok := kindColumns[kind]
+	return ok
+}
+
+// 指定した会議のイベントを読み込む
+func LoadMeeting(ctx context.Context, meetingId string) (Meeting, error) {
+	events, err := loadEvents(ctx, meetingId, 0)
+	if err != nil {
+		return Meeting{}, err
+	}
+	return Meeting{Id: meetingId, Events: events}, nil
+}
+
+// from以上to未満の日時に開始した会議のイベントを読み込む
+func LoadMeetings(ctx context.Context, from, to time.Time) ([]Meeting, error) {
+	// toちょうどに開始した会議を含めないように1秒前までを対象にする
+	ids, err := listMeetingIds(ctx, utils.ConvertYYYYMMDDHHMMSS(from), utils.ConvertYYYYMMDDHHMMSS(to.Add(-time.Second)))
+	if err != nil {
+		return nil, err
+	}
+	meetings := make([]Meeting, 0, len(ids))
+	for _, id := range ids {
+		m, err := LoadMeeting(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		meetings = append(meetings, m)
+	}
+	return meetings, nil
+}
+
+// 1種類のログをCSVで書き込む
+func WriteCSV(w io.Writer, kind string, meetings []Meeting) error {
+	columns, ok := kindColumns[kind]
+	if !ok {
+		return fmt.Errorf("unknown kind: %s", kind)
+	}
+	cw := csv.NewWriter(w)
+	if err := cw.Write(append(append([]string{}, commonColumns...), columns...)); err != nil {
+		return err
+	}
+	for _, m := range meetings {
+		for _, row := range rows(kind, m) {
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// 全種類のログのCSVをzipにまとめて書き込む
+func WriteZip(w io.Writer, meetings []Meeting) error {
+	zw := zip.NewWriter(w)
+	for _, kind := range Kinds {
+		f, err := zw.Create(kind + ".csv")
+		if err != nil {
+			return err
+		}
+		if err := WriteCSV(f, kind, meetings); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+func rows(kind string, m Meeting) [][]string {
+	common := func(seq int64, timestamp time.Time, sinceMeetingStart int64, clientId, nickname string, section int) []string {
+		sectionColumn := ""
+		if section > 0 {
+			sectionColumn = strconv.Itoa(section)
+		}
+		return []string{
+			m.Id,
+			strconv.FormatInt(seq, 10),
+			timestamp.Format(time.RFC3339),
+			strconv.FormatInt(sinceMeetingStart, 10),
+			clientId,
+			nickname,
+			sectionColumn,
+		}
+	}
+
+	rows := make([][]string, 0)
+	// レベルはイベントに含まれないので、再生して導出する
+	if kind == "levels" {
+		for _, lc := range event.LevelChanges(m.Events) {
+			rows = append(rows, append(common(lc.Seq, lc.Timestamp, lc.SinceMeetingStart, "", "", lc.Section), strconv.Itoa(lc.Level)))
+		}
+		return rows
+	}
+	for _, ev := range event.Sorted(m.Events) {
+		row := common(ev.Seq, ev.Timestamp, ev.SinceMeetingStart, ev.ClientId, ev.Nickname, ev.Section)
+		switch {
+		case kind == "smile_points" && ev.Type == event.SmilePoint:
+			rows = append(rows, append(row, strconv.Itoa(ev.Point)))
+		case kind == "ideas" && ev.Type == event.Idea:
+			rows = append(rows, row)
+		case kind == "images" && ev.Type == event.Image:
+			rows = append(rows, append(row, ev.Prompt, ev.ImageUrl))
+		case kind == "messages" && ev.Type == event.Message:
+			rows = append(rows, append(row, ev.Text))
+		}
+	}
+	return rows
+}
@@ -0,0 +1,162 @@
+package export
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"io"
+	"smile-sync/src/event"
+	"strings"
+	"testing"
+	"time"
+)
+
+var start = time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+
+// Seqの順に並んでいないイベント。アジェンダのセクションを含む
+func testEvents() []event.Event {
+	at := func(seconds int) time.Time { return start.Add(time.Duration(seconds) * time.Second) }
+	return []event.Event{
+		{Seq: 1, Type: event.MeetingStarted, Timestamp: start},
+		{Seq: 2, Type: event.LevelThresholdsSet, Timestamp: at(5), SinceMeetingStart: 5, LevelThresholds: []int{5, 10}},
+		{Seq: 4, Type: event.SmilePoint, Timestamp: at(20), SinceMeetingStart: 20, ClientId: "client-b", Nickname: "bob", Point: 4, Section: 2},
+		{Seq: 3, Type: event.SmilePoint, Timestamp: at(10), SinceMeetingStart: 10, ClientId: "client-a", Nickname: "alice", Point: 3, Section: 1},
+		{Seq: 5, Type: event.Idea, Timestamp: at(30), SinceMeetingStart: 30, ClientId: "client-a", Nickname: "alice"},
+		{Seq: 6, Type: event.Message, Timestamp: at(40), SinceMeetingStart: 40, ClientId: "client-b", Nickname: "bob", Text: "hello, world"},
+		{Seq: 7, Type: event.Image, Timestamp: at(50), SinceMeetingStart: 50, Prompt: "a dog", ImageUrl: "https://example.com/1.png"},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	header := "meeting_id,seq,timestamp,since_meeting_start,client_id,nickname,section"
+	tests := []struct {
+		kind string
+		want []string
+	}{
+		{"smile_points", []string{
+			header + ",smile_point",
+			"m1,3,2024-08-01T10:00:10Z,10,client-a,alice,1,3",
+			"m1,4,2024-08-01T10:00:20Z,20,client-b,bob,2,4",
+		}},
+		{"ideas", []string{
+			header,
+			"m1,5,2024-08-01T10:00:30Z,30,client-a,alice,",
+		}},
+		// レベルはイベントを再生して、変化した時点を出力する
+		{"levels", []string{
+			header + ",level",
+			"m1,4,2024-08-01T10:00:20Z,20,,,2,2",
+		}},
+		{"images", []string{
+			header + ",prompt,image_url",
+			"m1,7,2024-08-01T10:00:50Z,50,,,,a dog,https://example.com/1.png",
+		}},
+		{"messages", []string{
+			header + ",text",
+			`m1,6,2024-08-01T10:00:40Z,40,client-b,bob,,"hello, world"`,
+		}},
+	}
+	meetings := []Meeting{{Id: "m1", Events: testEvents()}}
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := WriteCSV(&buf, tt.kind, meetings); err != nil {
+				t.Fatal(err)
+			}
+			if got, want := buf.String(), strings.Join(tt.want, "\n")+"\n"; got != want {
+				t.Errorf("WriteCSV(%s) =\n%s\nwant\n%s", tt.kind, got, want)
+			}
+		})
+	}
+
+	if err := WriteCSV(io.Discard, "unknown", meetings); err == nil {
+		t.Error("WriteCSV(unknown) = nil, want an error")
+	}
+}
+
+// 複数の会議は会議ごとに続けて出力する
+func TestWriteCSVMultipleMeetings(t *testing.T) {
+	meetings := []Meeting{
+		{Id: "m1", Events: testEvents()},
+		{Id: "m2", Events: []event.Event{{Seq: 1, Type: event.Idea, Timestamp: start, Nickname: "carol"}}},
+		{Id: "m3"},
+	}
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, "ideas", meetings); err != nil {
+		t.Fatal(err)
+	}
+	want := "meeting_id,seq,timestamp,since_meeting_start,client_id,nickname,section\n" +
+		"m1,5,2024-08-01T10:00:30Z,30,client-a,alice,\n" +
+		"m2,1,2024-08-01T10:00:00Z,0,,carol,\n"
+	if got := buf.String(); got != want {
+		t.Errorf("WriteCSV =\n%s\nwant\n%s", got, want)
+	}
+}
+
+// zipには全種類のCSVを含める
+func TestWriteZip(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteZip(&buf, []Meeting{{Id: "m1", Events: testEvents()}}); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != len(Kinds) {
+		t.Fatalf("files = %d, want %d", len(zr.File), len(Kinds))
+	}
+	for i, f := range zr.File {
+		if f.Name != Kinds[i]+".csv" {
+			t.Errorf("file %d = %s, want %s.csv", i, f.Name, Kinds[i])
+		}
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, _ := io.ReadAll(r)
+		r.Close()
+		var want bytes.Buffer
+		WriteCSV(&want, Kinds[i], []Meeting{{Id: "m1", Events: testEvents()}})
+		if string(data) != want.String() {
+			t.Errorf("%s =\n%s\nwant\n%s", f.Name, data, want.String())
+		}
+	}
+}
+
+// 期間に開始した会議のみを読み込む。toちょうどに開始した会議は含めない
+func TestLoadMeetings(t *testing.T) {
+	events := map[string][]event.Event{
+		"2024-08-01 10:00:00": testEvents(),
+		"2024-08-02 09:00:00": {{Seq: 1, Type: event.Idea, Timestamp: start}},
+	}
+	origListMeetingIds, origLoadEvents := listMeetingIds, loadEvents
+	t.Cleanup(func() {
+		listMeetingIds, loadEvents = origListMeetingIds, origLoadEvents
+	})
+	var gotFrom, gotTo string
+	listMeetingIds = func(ctx context.Context, fromId, toId string) ([]string, error) {
+		gotFrom, gotTo = fromId, toId
+		return []string{"2024-08-01 10:00:00", "2024-08-02 09:00:00"}, nil
+	}
+	loadEvents = func(ctx context.Context, meetingId string, afterSeq int64) ([]event.Event, error) {
+		return events[meetingId], nil
+	}
+
+	from := time.Date(2024, 8, 1, 0, 0, 0, 0, time.Local)
+	meetings, err := LoadMeetings(context.Background(), from, from.AddDate(0, 0, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gotFrom != "2024-08-01 00:00:00" || gotTo != "2024-08-02 23:59:59" {
+		t.Errorf("meeting ids between %q and %q, want 2024-08-01 00:00:00 and 2024-08-02 23:59:59", gotFrom, gotTo)
+	}
+	if len(meetings) != 2 || meetings[0].Id != "2024-08-01 10:00:00" || len(meetings[0].Events) != len(testEvents()) || len(meetings[1].Events) != 1 {
+		t.Errorf("meetings = %+v", meetings)
+	}
+
+	m, err := LoadMeeting(context.Background(), "2024-08-02 09:00:00")
+	if err != nil || m.Id != "2024-08-02 09:00:00" || len(m.Events) != 1 {
+		t.Errorf("LoadMeeting = %+v, %v", m, err)
+	}
+}
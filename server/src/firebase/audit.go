@@ -0,0 +1,106 @@
+package firebase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"smile-sync/src/audit"
+	"smile-sync/src/metrics"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// audit_log/{自動ID} に操作を1件ずつ保存する
+var AuditCollectionId = "audit_log"
+
+type AuditStore struct{}
+
+func (AuditStore) AppendAudit(ctx context.Context, entry audit.Entry) error {
+	start := time.Now()
+	_, _, err := Client.Collection(AuditCollectionId).Add(ctx, entry)
+	metrics.ObserveFirestoreWrite("append_audit", start, err)
+	return err
+}
+
+type auditCursor struct {
+	Timestamp time.Time `json:"timestamp"`
+	Id        string    `json:"id"`
+}
+
+func encodeAuditPageToken(entry audit.Entry) string {
+	data, _ := json.Marshal(auditCursor{Timestamp: entry.Timestamp, Id: entry.Id})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeAuditPageToken(token string) (auditCursor, error) {
+	var cursor auditCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, ErrInvalidPageToken
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Id == "" {
+		return cursor, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// 新しい順に操作の記録を取得する。続きがある場合は次のページのトークンも返す。
+// ListMeetingSummariesと同様に、Firestoreでは日時の範囲のみ絞り込む
+func ListAuditEntries(ctx context.Context, q audit.Query) ([]audit.Entry, string, error) {
+	query := Client.Collection(AuditCollectionId).Query
+	if !q.From.IsZero() {
+		query = query.Where("timestamp", ">=", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("timestamp", "<", q.To)
+	}
+	query = query.OrderBy("timestamp", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if q.PageToken != "" {
+		cursor, err := decodeAuditPageToken(q.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.StartAfter(cursor.Timestamp, cursor.Id)
+	}
+
+	entries := make([]audit.Entry, 0, q.Limit)
+	batchSize := max(q.Limit*2, 20)
+	for {
+		iter := query.Limit(batchSize).Documents(ctx)
+		fetched := 0
+		var last *firestore.DocumentSnapshot
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, "", err
+			}
+			fetched++
+			last = doc
+			var entry audit.Entry
+			if err := doc.DataTo(&entry); err != nil {
+				iter.Stop()
+				return nil, "", err
+			}
+			entry.Id = doc.Ref.ID
+			if !q.Matches(entry) {
+				continue
+			}
+			entries = append(entries, entry)
+			if len(entries) == q.Limit {
+				iter.Stop()
+				return entries, encodeAuditPageToken(entry), nil
+			}
+		}
+		iter.Stop()
+		if fetched < batchSize {
+			return entries, "", nil
+		}
+		query = query.StartAfter(last)
+	}
+}
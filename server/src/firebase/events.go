@@ -0,0 +1,114 @@
+package firebase
+
+import (
+	"context"
+	"fmt"
+	"smile-sync/src/event"
+	"smile-sync/src/metrics"
+	"smile-sync/src/utils"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+func meetingRef(meetingId string) *firestore.DocumentRef {
+	return Client.Collection(MeetingCollectionId).Doc(meetingId)
+}
+
+// Seqを0埋めしたものをドキュメントIDにし、ID順とSeq順を一致させる
+func eventRef(meetingId string, seq int64) *firestore.DocumentRef {
+	return meetingRef(meetingId).Collection(EventCollectionId).Doc(fmt.Sprintf("%010d", seq))
+}
+
+// 会議のイベントを1件1ドキュメントとしてまとめて書き込む。
+// ドキュメントIDはSeqから決まるので、同じイベントを再送しても重複しない
+func AppendEvents(ctx context.Context, meetingId string, events []event.Event) (err error) {
+	if len(events) == 0 {
+		return nil
+	}
+	defer func(start time.Time) {
+		metrics.ObserveFirestoreWrite("append_events", start, err)
+	}(time.Now())
+	if meetingId == "" {
+		meetingId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
+	}
+
+	bw := Client.BulkWriter(ctx)
+	jobs := make([]*firestore.BulkWriterJob, 0, len(events)+1)
+	// 会議一覧を取得できるように、親ドキュメントも作成しておく
+	job, err := bw.Set(meetingRef(meetingId), map[string]interface{}{
+		"meeting_id": meetingId,
+		"updated_at": time.Now(),
+	}, firestore.MergeAll)
+	if err != nil {
+		bw.End()
+		return err
+	}
+	jobs = append(jobs, job)
+	for _, ev := range events {
+		job, err := bw.Set(eventRef(meetingId, ev.Seq), ev)
+		if err != nil {
+			bw.End()
+			return err
+		}
+		jobs = append(jobs, job)
+	}
+	bw.End()
+
+	for _, job := range jobs {
+		if _, err := job.Results(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 会議のイベントのうち、afterSeqより後のものをSeq順に取得する
+func LoadEvents(ctx context.Context, meetingId string, afterSeq int64) ([]event.Event, error) {
+	iter := meetingRef(meetingId).Collection(EventCollectionId).
+		Where("seq", ">", afterSeq).
+		OrderBy("seq", firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	events := make([]event.Event, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var ev event.Event
+		if err := doc.DataTo(&ev); err != nil {
+			return nil, err
+		}
+		events = append(events, ev)
+	}
+	return events, nil
+}
+
+// DocIdが[fromId, toId]の範囲にある会議のDocIdを昇順に取得する。DocIdは開始日時の文字列なので日時の範囲になる
+func ListMeetingIds(ctx context.Context, fromId, toId string) ([]string, error) {
+	iter := Client.Collection(MeetingCollectionId).
+		Where(firestore.DocumentID, ">=", meetingRef(fromId)).
+		Where(firestore.DocumentID, "<=", meetingRef(toId)).
+		OrderBy(firestore.DocumentID, firestore.Asc).
+		Documents(ctx)
+	defer iter.Stop()
+
+	ids := make([]string, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, doc.Ref.ID)
+	}
+	return ids, nil
+}
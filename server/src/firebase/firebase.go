@@ -2,24 +2,24 @@ package firebase
 
 import (
 	"context"
-	"log"
+	"errors"
+	"log/slog"
 	"os"
-	"smile-sync/src/utils"
-	"time"
 
 	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
 	"google.golang.org/api/option"
 )
 
 var (
-	Client       *firestore.Client
-	CollectionId = "smilepoint_history"
-	DocId        string
+	Client *firestore.Client
+	// meetings/{会議のDocId}/events/{Seq} にイベントを1件ずつ保存する
+	MeetingCollectionId = "meetings"
+	EventCollectionId   = "events"
 )
 
-func InitFirestore() {
+func InitFirestore(projectId string) {
 	ctx := context.Background()
-	projectId := os.Getenv("FIRESTORE_PROJECT_ID")
 	// ローカル環境ではservice-account.jsonを使用
 	if _, ok := os.LookupEnv("GOOGLE_CLOUD_PROJECT"); !ok {
 		saPath := "./smilesync-service-account.json"
@@ -30,13 +30,15 @@ func InitFirestore() {
 		sa := option.WithCredentialsFile(saPath)
 		client, err := firestore.NewClient(ctx, projectId, sa)
 		if err != nil {
-			log.Fatalf("Failed to create Firestore client: %v", err)
+			slog.Error("Failed to create Firestore client", "error", err)
+			os.Exit(1)
 		}
 		Client = client
 	} else { // GCP環境では自動で認証
 		client, err := firestore.NewClient(ctx, projectId)
 		if err != nil {
-			log.Fatalf("Failed to create Firestore client: %v", err)
+			slog.Error("Failed to create Firestore client", "error", err)
+			os.Exit(1)
 		}
 		Client = client
 	}
@@ -45,161 +47,20 @@ func InitFirestore() {
 func CloseFirestore() {
 	if Client != nil {
 		if err := Client.Close(); err != nil {
-			log.Fatalf("Failed to close Firestore client: %v", err)
+			slog.Error("Failed to close Firestore client", "error", err)
 		}
 	}
 }
 
-type SmilePoint struct {
-	Timestamp         time.Time `firestore:"timestamp"`
-	SinceMeetingStart int64     `firestore:"since_meeting_start"`
-	ClientId          string    `firestore:"client_id"`
-	Nickname          string    `firestore:"nickname"`
-	Point             int       `firestore:"smile_point"`
-	TotalSmilePoint   int       `firestore:"total_smile_point"`
-}
-
-type SmileIdea struct {
-	Timestamp         time.Time `firestore:"timestamp"`
-	SinceMeetingStart int64     `firestore:"since_meeting_start"`
-	ClientId          string    `firestore:"client_id"`
-	Nickname          string    `firestore:"nickname"`
-}
-
-type SmileImage struct {
-	Timestamp         time.Time `firestore:"timestamp"`
-	SinceMeetingStart int64     `firestore:"since_meeting_start"`
-	TotalSmilePoint   int       `firestore:"total_smile_point"`
-	Prompt            string    `firestore:"prompt"`
-	ImageUrl          string    `firestore:"image_url"`
-}
-
-type SmileLevel struct {
-	Timestamp         time.Time `firestore:"timestamp"`
-	SinceMeetingStart int64     `firestore:"since_meeting_start"`
-	Level             int       `firestore:"level"`
-}
-
-func SaveSmilePoint(sp SmilePoint) error {
-	ctx := context.Background()
-	if DocId == "" {
-		DocId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
-	}
-	docRef := Client.Collection(CollectionId).Doc(DocId)
-	// Docが存在するか確認
-	_, err := docRef.Get(ctx)
-	if err != nil {
-		// 存在しないなら、新しいドキュメントを作成
-		_, err = docRef.Set(ctx, map[string]interface{}{
-			"smile_points_log": []SmilePoint{sp},
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		// 存在するなら、既存のドキュメントにメッセージを追加
-		_, err = docRef.Update(ctx, []firestore.Update{
-			{
-				Path:  "smile_points_log",
-				Value: firestore.ArrayUnion(sp),
-			},
-		})
-		if err != nil {
-			return err
-		}
+// Firestoreに接続できるかどうかを、会議のCollectionを1件読み込んで確認する
+func Ping(ctx context.Context) error {
+	if Client == nil {
+		return errors.New("firestore client is not initialized")
 	}
-	return nil
-}
-
-func SaveSmileIdea(si SmileIdea) error {
-	ctx := context.Background()
-	if DocId == "" {
-		DocId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
-	}
-	docRef := Client.Collection(CollectionId).Doc(DocId)
-	// Docが存在するか確認
-	_, err := docRef.Get(ctx)
-	if err != nil {
-		// 存在しないなら、新しいドキュメントを作成
-		_, err = docRef.Set(ctx, map[string]interface{}{
-			"smile_ideas_log": []SmileIdea{si},
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		// 存在するなら、既存のドキュメントにメッセージを追加
-		_, err = docRef.Update(ctx, []firestore.Update{
-			{
-				Path:  "smile_ideas_log",
-				Value: firestore.ArrayUnion(si),
-			},
-		})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func SaveSmileImage(si SmileImage) error {
-	ctx := context.Background()
-	if DocId == "" {
-		DocId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
-	}
-	docRef := Client.Collection(CollectionId).Doc(DocId)
-	// Docが存在するか確認
-	_, err := docRef.Get(ctx)
-	if err != nil {
-		// 存在しないなら、新しいドキュメントを作成
-		_, err = docRef.Set(ctx, map[string]interface{}{
-			"smile_image_log": []SmileImage{si},
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		// 存在するなら、既存のドキュメントにメッセージを追加
-		_, err = docRef.Update(ctx, []firestore.Update{
-			{
-				Path:  "smile_image_log",
-				Value: firestore.ArrayUnion(si),
-			},
-		})
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
-func SaveSmileLevel(sl SmileLevel) error {
-	ctx := context.Background()
-	if DocId == "" {
-		DocId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
-	}
-	docRef := Client.Collection(CollectionId).Doc(DocId)
-	// Docが存在するか確認
-	_, err := docRef.Get(ctx)
-	if err != nil {
-		// 存在しないなら、新しいドキュメントを作成
-		_, err = docRef.Set(ctx, map[string]interface{}{
-			"smile_level_log": []SmileLevel{sl},
-		})
-		if err != nil {
-			return err
-		}
-	} else {
-		// 存在するなら、既存のドキュメントにメッセージを追加
-		_, err = docRef.Update(ctx, []firestore.Update{
-			{
-				Path:  "smile_level_log",
-				Value: firestore.ArrayUnion(sl),
-			},
-		})
-		if err != nil {
-			return err
-		}
+	iter := Client.Collection(MeetingCollectionId).Limit(1).Documents(ctx)
+	defer iter.Stop()
+	if _, err := iter.Next(); err != nil && err != iterator.Done {
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,61 @@
+package firebase
+
+import (
+	"context"
+	"smile-sync/src/auth"
+	"smile-sync/src/metrics"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 会議の参加コードを保存するCollection。DocIdは参加コード
+var InviteCollectionId = "invites"
+
+// auth.InviteStoreのFirestoreでの実装
+type InviteStore struct{}
+
+func (InviteStore) CreateInvite(ctx context.Context, invite auth.Invite) error {
+	start := time.Now()
+	_, err := Client.Collection(InviteCollectionId).Doc(invite.Code).Create(ctx, invite)
+	if status.Code(err) == codes.AlreadyExists {
+		return auth.ErrInviteExists
+	}
+	metrics.ObserveFirestoreWrite("create_invite", start, err)
+	return err
+}
+
+// 1回限りのコードを同時に使われても1人だけが参加できるよう、トランザクションで読み込みと更新を行う
+func (InviteStore) RedeemInvite(ctx context.Context, code string, redeem func(invite *auth.Invite) error) (*auth.Invite, error) {
+	ref := Client.Collection(InviteCollectionId).Doc(code)
+	var invite auth.Invite
+	start := time.Now()
+	err := Client.RunTransaction(ctx, func(ctx context.Context, tx *firestore.Transaction) error {
+		doc, err := tx.Get(ref)
+		if status.Code(err) == codes.NotFound {
+			return auth.ErrInviteNotFound
+		}
+		if err != nil {
+			return err
+		}
+		invite = auth.Invite{}
+		if err := doc.DataTo(&invite); err != nil {
+			return err
+		}
+		used := invite.UsedAt
+		if err := redeem(&invite); err != nil {
+			return err
+		}
+		if invite.UsedAt.Equal(used) {
+			return nil
+		}
+		return tx.Set(ref, invite)
+	})
+	if err != nil {
+		return nil, err
+	}
+	metrics.ObserveFirestoreWrite("redeem_invite", start, nil)
+	return &invite, nil
+}
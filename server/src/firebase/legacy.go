@@ -0,0 +1,211 @@
+package firebase
+
+import (
+	"context"
+	"smile-sync/src/event"
+	"sort"
+	"time"
+
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// サブコレクション導入前に、サーバ起動ごとに1ドキュメントへ配列で保存していたCollection
+var LegacyCollectionId = "smilepoint_history"
+
+// イベントログ導入前に、種類ごとの配列として保存していたログの形式
+
+type SmilePoint struct {
+	Timestamp         time.Time `firestore:"timestamp"`
+	SinceMeetingStart int64     `firestore:"since_meeting_start"`
+	ClientId          string    `firestore:"client_id"`
+	Nickname          string    `firestore:"nickname"`
+	Point             int       `firestore:"smile_point"`
+	TotalSmilePoint   int       `firestore:"total_smile_point"`
+}
+
+type SmileIdea struct {
+	Timestamp         time.Time `firestore:"timestamp"`
+	SinceMeetingStart int64     `firestore:"since_meeting_start"`
+	ClientId          string    `firestore:"client_id"`
+	Nickname          string    `firestore:"nickname"`
+}
+
+type SmileImage struct {
+	Timestamp         time.Time `firestore:"timestamp"`
+	SinceMeetingStart int64     `firestore:"since_meeting_start"`
+	TotalSmilePoint   int       `firestore:"total_smile_point"`
+	Prompt            string    `firestore:"prompt"`
+	ImageUrl          string    `firestore:"image_url"`
+}
+
+type SmileLevel struct {
+	Timestamp         time.Time `firestore:"timestamp"`
+	SinceMeetingStart int64     `firestore:"since_meeting_start"`
+	Level             int       `firestore:"level"`
+}
+
+type MeetingLogs struct {
+	SmilePoints []SmilePoint `firestore:"smile_points_log"`
+	SmileIdeas  []SmileIdea  `firestore:"smile_ideas_log"`
+	SmileImages []SmileImage `firestore:"smile_image_log"`
+	SmileLevels []SmileLevel `firestore:"smile_level_log"`
+	// イベントログ導入後、サブコレクション導入前の形式
+	Events []event.Event `firestore:"events_log"`
+}
+
+// 会議のドキュメントに保存されている旧形式のログを全て取得する
+func LoadMeetingLogs(ctx context.Context, meetingId string) (*MeetingLogs, error) {
+	doc, err := Client.Collection(LegacyCollectionId).Doc(meetingId).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return &MeetingLogs{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var logs MeetingLogs
+	if err := doc.DataTo(&logs); err != nil {
+		return nil, err
+	}
+	return &logs, nil
+}
+
+func ListLegacyMeetingIds(ctx context.Context) ([]string, error) {
+	iter := Client.Collection(LegacyCollectionId).DocumentRefs(ctx)
+	ids := make([]string, 0)
+	for {
+		ref, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, ref.ID)
+	}
+	return ids, nil
+}
+
+// 同じ会議とみなす開始時刻のずれ。SinceMeetingStartは秒単位で切り捨てられているため
+const meetingStartTolerance = 2 * time.Second
+
+// 会議開始から閾値を設定するまでの時間(websocket.Server.startTimerと同じ)
+const levelThresholdDelay = 10 * time.Second
+
+// 旧形式のログをSeq付きのイベント列に変換する。
+// レベルはイベントから導出するので旧形式のレベルのログは変換せず、代わりに会議の開始と閾値の設定を補う
+func (logs *MeetingLogs) ToEvents() []event.Event {
+	if len(logs.Events) > 0 {
+		return event.Sorted(logs.Events)
+	}
+
+	type entry struct {
+		order int // 同時刻の場合の並び順。画像は元になったSmilePointの後
+		ev    event.Event
+	}
+	entries := make([]entry, 0, len(logs.SmilePoints)+len(logs.SmileIdeas)+len(logs.SmileImages))
+	for _, sp := range logs.SmilePoints {
+		entries = append(entries, entry{0, event.Event{
+			Type:              event.SmilePoint,
+			Timestamp:         sp.Timestamp,
+			SinceMeetingStart: sp.SinceMeetingStart,
+			ClientId:          sp.ClientId,
+			Nickname:          sp.Nickname,
+			Point:             sp.Point,
+		}})
+	}
+	for _, si := range logs.SmileIdeas {
+		entries = append(entries, entry{1, event.Event{
+			Type:              event.Idea,
+			Timestamp:         si.Timestamp,
+			SinceMeetingStart: si.SinceMeetingStart,
+			ClientId:          si.ClientId,
+			Nickname:          si.Nickname,
+		}})
+	}
+	for _, si := range logs.SmileImages {
+		entries = append(entries, entry{2, event.Event{
+			Type:              event.Image,
+			Timestamp:         si.Timestamp,
+			SinceMeetingStart: si.SinceMeetingStart,
+			Prompt:            si.Prompt,
+			ImageUrl:          si.ImageUrl,
+		}})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		if !entries[i].ev.Timestamp.Equal(entries[j].ev.Timestamp) {
+			return entries[i].ev.Timestamp.Before(entries[j].ev.Timestamp)
+		}
+		return entries[i].order < entries[j].order
+	})
+
+	events := make([]event.Event, 0, len(entries)+2)
+	var meetingStart, thresholdAt, lastTimestamp time.Time
+	thresholdsSet := false
+	totalSmilePoint := 0
+	for _, e := range entries {
+		// SinceMeetingStartから会議の開始時刻を求め、変わっていれば新しい会議が始まったとみなす
+		start := e.ev.Timestamp.Add(-time.Duration(e.ev.SinceMeetingStart) * time.Second)
+		if meetingStart.IsZero() || start.Sub(meetingStart).Abs() > meetingStartTolerance {
+			if !meetingStart.IsZero() {
+				events = append(events, event.Event{
+					Type:              event.MeetingEnded,
+					Timestamp:         lastTimestamp,
+					SinceMeetingStart: int64(lastTimestamp.Sub(meetingStart).Seconds()),
+				})
+			}
+			meetingStart = start
+			events = append(events, event.Event{Type: event.MeetingStarted, Timestamp: start})
+			// 閾値はサーバ起動後の最初の会議でのみ設定される
+			if thresholdAt.IsZero() {
+				thresholdAt = start.Add(levelThresholdDelay)
+			}
+		}
+		if !thresholdsSet && !e.ev.Timestamp.Before(thresholdAt) {
+			events = append(events, event.Event{
+				Type:              event.LevelThresholdsSet,
+				Timestamp:         thresholdAt,
+				SinceMeetingStart: int64(levelThresholdDelay.Seconds()),
+				LevelThresholds:   event.NewLevelThresholds(totalSmilePoint, event.MaxLevel),
+			})
+			thresholdsSet = true
+		}
+		if e.ev.Type == event.SmilePoint {
+			totalSmilePoint += e.ev.Point
+		}
+		events = append(events, e.ev)
+		lastTimestamp = e.ev.Timestamp
+	}
+	if !meetingStart.IsZero() {
+		events = append(events, event.Event{
+			Type:              event.MeetingEnded,
+			Timestamp:         lastTimestamp,
+			SinceMeetingStart: int64(lastTimestamp.Sub(meetingStart).Seconds()),
+		})
+	}
+
+	for i := range events {
+		events[i].Seq = int64(i + 1)
+	}
+	return events
+}
+
+// 変換したイベントから導出したレベルの推移が、旧形式のレベルのログと一致するか
+func (logs *MeetingLogs) LevelsMatch(events []event.Event) bool {
+	levels := make([]SmileLevel, len(logs.SmileLevels))
+	copy(levels, logs.SmileLevels)
+	sort.SliceStable(levels, func(i, j int) bool {
+		return levels[i].Timestamp.Before(levels[j].Timestamp)
+	})
+	changes := event.LevelChanges(events)
+	if len(changes) != len(levels) {
+		return false
+	}
+	for i := range changes {
+		if changes[i].Level != levels[i].Level {
+			return false
+		}
+	}
+	return true
+}
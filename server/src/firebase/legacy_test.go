@@ -0,0 +1,54 @@
+package firebase
+
+import (
+	"smile-sync/src/event"
+	"testing"
+	"time"
+)
+
+func TestMeetingLogsToEvents(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int64) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	logs := MeetingLogs{
+		SmilePoints: []SmilePoint{
+			{Timestamp: at(5), SinceMeetingStart: 5, Nickname: "a", Point: 2},
+			{Timestamp: at(20), SinceMeetingStart: 20, Nickname: "b", Point: 3, TotalSmilePoint: 2},
+		},
+		SmileIdeas: []SmileIdea{
+			{Timestamp: at(30), SinceMeetingStart: 30, Nickname: "a"},
+		},
+		SmileImages: []SmileImage{
+			{Timestamp: at(20), SinceMeetingStart: 20, ImageUrl: "https://example.com/1.png"},
+		},
+		SmileLevels: []SmileLevel{
+			{Timestamp: at(20), SinceMeetingStart: 20, Level: 3},
+		},
+	}
+
+	events := logs.ToEvents()
+	want := []event.Type{
+		event.MeetingStarted,
+		event.SmilePoint,
+		event.LevelThresholdsSet,
+		event.SmilePoint,
+		event.Image,
+		event.Idea,
+		event.MeetingEnded,
+	}
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d: %+v", len(events), len(want), events)
+	}
+	for i, ev := range events {
+		if ev.Type != want[i] || ev.Seq != int64(i+1) {
+			t.Errorf("events[%d] = %s (seq %d), want %s (seq %d)", i, ev.Type, ev.Seq, want[i], i+1)
+		}
+	}
+
+	st := event.Replay(events)
+	if st.TotalSmilePoint != 5 || st.TotalIdeas != 1 || st.Level != 3 {
+		t.Errorf("replayed state = %+v", st)
+	}
+	if !logs.LevelsMatch(events) {
+		t.Errorf("levels derived from events do not match smile_level_log")
+	}
+}
@@ -0,0 +1,175 @@
+package firebase
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"smile-sync/src/event"
+	"smile-sync/src/metrics"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+)
+
+// meetings/{id}に保存する会議の概要
+type MeetingSummary struct {
+	MeetingId       string                `firestore:"meeting_id" json:"meetingId"`
+	StartedAt       time.Time             `firestore:"started_at" json:"startedAt"`
+	EndedAt         time.Time             `firestore:"ended_at" json:"endedAt"`
+	IsMeetingActive bool                  `firestore:"is_meeting_active" json:"isMeetingActive"`
+	IsMeetingPaused bool                  `firestore:"is_meeting_paused" json:"isMeetingPaused"`
+	DurationSeconds int64                 `firestore:"duration_seconds" json:"durationSeconds"`
+	Participants    []string              `firestore:"participants" json:"participants"`
+	MaxLevel        int                   `firestore:"max_level" json:"maxLevel"`
+	ImageAnimalType string                `firestore:"image_animal_type" json:"imageAnimalType"`
+	Settings        event.MeetingSettings `firestore:"settings" json:"settings"`
+	TotalSmilePoint int                   `firestore:"total_smile_point" json:"totalSmilePoint"`
+	TotalIdeas      int                   `firestore:"total_ideas" json:"totalIdeas"`
+	ImageCount      int                   `firestore:"image_count" json:"imageCount"`
+	UpdatedAt       time.Time             `firestore:"updated_at" json:"updatedAt"`
+}
+
+func NewMeetingSummary(meetingId string, st event.State, now time.Time) MeetingSummary {
+	endedAt := st.LastEndTime
+	if st.IsMeetingActive || st.IsMeetingPaused {
+		endedAt = time.Time{}
+	}
+	return MeetingSummary{
+		MeetingId:       meetingId,
+		StartedAt:       st.FirstStartTime,
+		EndedAt:         endedAt,
+		IsMeetingActive: st.IsMeetingActive,
+		IsMeetingPaused: st.IsMeetingPaused,
+		DurationSeconds: int64(st.Duration(now).Seconds()),
+		Participants:    append(make([]string, 0, len(st.Participants)), st.Participants...),
+		MaxLevel:        st.MaxLevel,
+		ImageAnimalType: st.ImageAnimalType,
+		Settings:        st.Settings,
+		TotalSmilePoint: st.TotalSmilePoint,
+		TotalIdeas:      st.TotalIdeas,
+		ImageCount:      len(st.ImageUrls),
+		UpdatedAt:       now,
+	}
+}
+
+// 会議が開始されていない場合は一覧に載せるものがないので保存しない
+func SaveMeetingSummary(ctx context.Context, summary MeetingSummary) error {
+	if summary.StartedAt.IsZero() {
+		return nil
+	}
+	start := time.Now()
+	_, err := meetingRef(summary.MeetingId).Set(ctx, summary)
+	metrics.ObserveFirestoreWrite("save_summary", start, err)
+	return err
+}
+
+type MeetingQuery struct {
+	From       time.Time // 開始日時がFrom以上(ゼロ値なら制限なし)
+	To         time.Time // 開始日時がTo未満(ゼロ値なら制限なし)
+	Nickname   string    // この参加者を含む会議
+	MinLevel   int       // 到達したレベルがMinLevel以上の会議
+	AnimalType string
+	Limit      int
+	PageToken  string // 前のページの最後の会議の位置
+}
+
+func (q MeetingQuery) matches(summary MeetingSummary) bool {
+	if q.Nickname != "" && !slices.Contains(summary.Participants, q.Nickname) {
+		return false
+	}
+	if summary.MaxLevel < q.MinLevel {
+		return false
+	}
+	if q.AnimalType != "" && summary.ImageAnimalType != q.AnimalType {
+		return false
+	}
+	return true
+}
+
+type pageCursor struct {
+	StartedAt time.Time `json:"startedAt"`
+	MeetingId string    `json:"meetingId"`
+}
+
+var ErrInvalidPageToken = errors.New("invalid page token")
+
+func encodePageToken(summary MeetingSummary) string {
+	data, _ := json.Marshal(pageCursor{StartedAt: summary.StartedAt, MeetingId: summary.MeetingId})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodePageToken(token string) (pageCursor, error) {
+	var cursor pageCursor
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return cursor, ErrInvalidPageToken
+	}
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.MeetingId == "" {
+		return cursor, ErrInvalidPageToken
+	}
+	return cursor, nil
+}
+
+// 開始日時の新しい順に会議の概要を取得する。続きがある場合は次のページのトークンも返す。
+// Firestoreでは開始日時の範囲のみ絞り込み、その他の条件は取得後に絞り込む(複合インデックスを不要にするため)
+func ListMeetingSummaries(ctx context.Context, q MeetingQuery) ([]MeetingSummary, string, error) {
+	query := Client.Collection(MeetingCollectionId).Query
+	// started_atで並べ替えるので、started_atのない(開始されていない)会議は含まれない
+	if !q.From.IsZero() {
+		query = query.Where("started_at", ">=", q.From)
+	}
+	if !q.To.IsZero() {
+		query = query.Where("started_at", "<", q.To)
+	}
+	query = query.OrderBy("started_at", firestore.Desc).OrderBy(firestore.DocumentID, firestore.Desc)
+	if q.PageToken != "" {
+		cursor, err := decodePageToken(q.PageToken)
+		if err != nil {
+			return nil, "", err
+		}
+		query = query.StartAfter(cursor.StartedAt, cursor.MeetingId)
+	}
+
+	summaries := make([]MeetingSummary, 0, q.Limit)
+	// 絞り込みで減る分を見越して多めに取得する
+	batchSize := max(q.Limit*2, 20)
+	for {
+		iter := query.Limit(batchSize).Documents(ctx)
+		fetched := 0
+		var last *firestore.DocumentSnapshot
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, "", err
+			}
+			fetched++
+			last = doc
+			var summary MeetingSummary
+			if err := doc.DataTo(&summary); err != nil {
+				iter.Stop()
+				return nil, "", err
+			}
+			summary.MeetingId = doc.Ref.ID
+			if !q.matches(summary) {
+				continue
+			}
+			summaries = append(summaries, summary)
+			if len(summaries) == q.Limit {
+				iter.Stop()
+				return summaries, encodePageToken(summary), nil
+			}
+		}
+		iter.Stop()
+		if fetched < batchSize {
+			return summaries, "", nil
+		}
+		query = query.StartAfter(last)
+	}
+}
@@ -0,0 +1,66 @@
+package firebase
+
+import (
+	"context"
+	"smile-sync/src/event"
+	"smile-sync/src/metrics"
+	"time"
+
+	"google.golang.org/api/iterator"
+)
+
+// 会議中の状態を保存するCollection。DocIdは会議のDocIdと同じ
+var SnapshotCollectionId = "meeting_snapshots"
+
+// 会議の状態と、その状態に適用済みの最後のイベントのSeq(State.Seq)
+type MeetingSnapshot struct {
+	MeetingId  string    `firestore:"meeting_id"`
+	SnapshotAt time.Time `firestore:"snapshot_at"`
+	// 参加コードなしで接続する既定の会議かどうか
+	IsDefault bool `firestore:"is_default"`
+	event.State
+}
+
+func SaveSnapshot(ctx context.Context, snapshot MeetingSnapshot) error {
+	start := time.Now()
+	_, err := Client.Collection(SnapshotCollectionId).Doc(snapshot.MeetingId).Set(ctx, snapshot)
+	metrics.ObserveFirestoreWrite("save_snapshot", start, err)
+	return err
+}
+
+// 進行中、中断中、開始予定の会議のスナップショットを全て取得する
+func LoadActiveSnapshots(ctx context.Context) ([]MeetingSnapshot, error) {
+	var snapshots []MeetingSnapshot
+	// Firestoreでは異なるフィールドのORを1回で検索できないので、それぞれ取得する
+	for _, field := range []string{"is_meeting_active", "is_meeting_paused", "is_scheduled"} {
+		iter := Client.Collection(SnapshotCollectionId).Where(field, "==", true).Documents(ctx)
+		for {
+			doc, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				iter.Stop()
+				return nil, err
+			}
+			var snapshot MeetingSnapshot
+			if err := doc.DataTo(&snapshot); err != nil {
+				iter.Stop()
+				return nil, err
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+		iter.Stop()
+	}
+	return snapshots, nil
+}
+
+func DeleteSnapshot(ctx context.Context, meetingId string) error {
+	if meetingId == "" {
+		return nil
+	}
+	start := time.Now()
+	_, err := Client.Collection(SnapshotCollectionId).Doc(meetingId).Delete(ctx)
+	metrics.ObserveFirestoreWrite("delete_snapshot", start, err)
+	return err
+}
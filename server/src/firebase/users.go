@@ -0,0 +1,75 @@
+package firebase
+
+import (
+	"context"
+	"smile-sync/src/auth"
+	"smile-sync/src/metrics"
+	"time"
+
+	"cloud.google.com/go/firestore"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ログイン用のアカウントを保存するCollection。DocIdはNickname
+var UserCollectionId = "users"
+
+// auth.StoreのFirestoreでの実装
+type UserStore struct{}
+
+func (UserStore) GetUser(ctx context.Context, nickname string) (*auth.User, error) {
+	doc, err := Client.Collection(UserCollectionId).Doc(nickname).Get(ctx)
+	if status.Code(err) == codes.NotFound {
+		return nil, auth.ErrUserNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var user auth.User
+	if err := doc.DataTo(&user); err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (UserStore) SaveUser(ctx context.Context, user auth.User) error {
+	start := time.Now()
+	_, err := Client.Collection(UserCollectionId).Doc(user.Nickname).Set(ctx, user)
+	metrics.ObserveFirestoreWrite("save_user", start, err)
+	return err
+}
+
+func (UserStore) DeleteUser(ctx context.Context, nickname string) error {
+	ref := Client.Collection(UserCollectionId).Doc(nickname)
+	if _, err := ref.Get(ctx); status.Code(err) == codes.NotFound {
+		return auth.ErrUserNotFound
+	} else if err != nil {
+		return err
+	}
+	start := time.Now()
+	_, err := ref.Delete(ctx)
+	metrics.ObserveFirestoreWrite("delete_user", start, err)
+	return err
+}
+
+func (UserStore) ListUsers(ctx context.Context) ([]auth.User, error) {
+	iter := Client.Collection(UserCollectionId).OrderBy("nickname", firestore.Asc).Documents(ctx)
+	defer iter.Stop()
+	users := make([]auth.User, 0)
+	for {
+		doc, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		var user auth.User
+		if err := doc.DataTo(&user); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, nil
+}
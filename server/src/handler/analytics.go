@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"smile-sync/src/analytics"
+	"smile-sync/src/firebase"
+	"strconv"
+	"time"
+)
+
+// GET /meetings/{id}/analytics?window=60s&maxLag=5&lookback=30s
+func MeetingAnalyticsHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := analyticsOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meetingId := r.PathValue("id")
+	events, err := firebase.LoadEvents(r.Context(), meetingId, 0)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load events", "meeting_id", meetingId, "error", err)
+		http.Error(w, "Failed to load meeting", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, analytics.Analyze(meetingId, events, opts))
+}
+
+func analyticsOptions(r *http.Request) (analytics.Options, error) {
+	opts := analytics.DefaultOptions()
+	query := r.URL.Query()
+	if v := query.Get("window"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return opts, errInvalidParam("window", v)
+		}
+		opts.Window = d
+	}
+	if v := query.Get("lookback"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return opts, errInvalidParam("lookback", v)
+		}
+		opts.BurstLookback = d
+	}
+	if v := query.Get("maxLag"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 60 {
+			return opts, errInvalidParam("maxLag", v)
+		}
+		opts.MaxLag = n
+	}
+	return opts, nil
+}
@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/audit"
+	"smile-sync/src/firebase"
+	"smile-sync/src/utils"
+	"strconv"
+)
+
+// GET /audit?from=2024-08-01&to=2024-08-31&meetingId=...&actor=alice&action=participant.&limit=50&pageToken=...
+// 管理者の操作の記録を新しい順に返す。actionの末尾を"."にすると前方一致。管理者のみ
+func AuditHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := audit.Query{
+		MeetingId: query.Get("meetingId"),
+		Actor:     query.Get("actor"),
+		Action:    query.Get("action"),
+		Limit:     50,
+		PageToken: query.Get("pageToken"),
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := utils.ParseDate(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.From = from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := utils.ParseDate(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 終了日を含める
+		q.To = to.AddDate(0, 0, 1)
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 200 {
+			http.Error(w, errInvalidParam("limit", v).Error(), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	entries, nextPageToken, err := firebase.ListAuditEntries(r.Context(), q)
+	if errors.Is(err, firebase.ErrInvalidPageToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list audit entries", "error", err)
+		http.Error(w, "Failed to list audit entries", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, r, struct {
+		Entries       []audit.Entry `json:"entries"`
+		NextPageToken string        `json:"nextPageToken,omitempty"`
+	}{entries, nextPageToken})
+}
@@ -0,0 +1,278 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/event"
+	"smile-sync/src/websocket"
+	"time"
+)
+
+// 管理者のAPIから会議を操作した人
+func actorFrom(r *http.Request) websocket.Actor {
+	claims, _ := auth.ClaimsFrom(r.Context())
+	return websocket.Actor{Nickname: claims.Nickname, Via: audit.ViaAPI}
+}
+
+func meetingFrom(w http.ResponseWriter, r *http.Request, hub *websocket.Hub) (*websocket.Server, bool) {
+	meeting, ok := hub.Meeting(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+	}
+	return meeting, ok
+}
+
+// 会議の操作のエラーをステータスコードに変換する
+func writeControlError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, websocket.ErrMeetingActive), errors.Is(err, websocket.ErrMeetingNotActive), errors.Is(err, websocket.ErrMeetingNotStarted), errors.Is(err, websocket.ErrMeetingStarted):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, websocket.ErrNoNextSection):
+		http.Error(w, err.Error(), http.StatusConflict)
+	case errors.Is(err, websocket.ErrParticipantNotFound):
+		http.Error(w, err.Error(), http.StatusNotFound)
+	default:
+		slog.ErrorContext(r.Context(), "Failed to control meeting", "error", err)
+		http.Error(w, "Failed to control meeting", http.StatusInternalServerError)
+	}
+}
+
+type meetingAction func(s *websocket.Server, ctx context.Context, actor websocket.Actor) error
+
+// POST /meetings/{id}/start, /pause, /stop
+// websocketのmeetingStatusと同じ処理で会議を操作し、操作後の状態を返す。管理者のみ
+func MeetingActionHandler(hub *websocket.Hub, action meetingAction) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		if err := action(meeting, r.Context(), actorFrom(r)); err != nil {
+			writeControlError(w, r, err)
+			return
+		}
+		writeJSON(w, r, meeting.Status())
+	}
+}
+
+func StartMeetingHandler(hub *websocket.Hub) http.HandlerFunc {
+	return MeetingActionHandler(hub, (*websocket.Server).Start)
+}
+
+func PauseMeetingHandler(hub *websocket.Hub) http.HandlerFunc {
+	return MeetingActionHandler(hub, (*websocket.Server).Pause)
+}
+
+func StopMeetingHandler(hub *websocket.Hub) http.HandlerFunc {
+	return MeetingActionHandler(hub, (*websocket.Server).Stop)
+}
+
+// GET /meetings/{id}/settings
+func GetMeetingSettingsHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		writeJSON(w, r, meeting.Settings())
+	}
+}
+
+// PUT /meetings/{id}/settings {"levelCount": 5, "calibrationSeconds": 60, "imageAnimalType": "cat", "imageSize": "1024x1024"}
+// 開始前の会議の設定を変更する。省略した項目は変更しない。画像の動物は会議中以外いつでも変更できる。管理者のみ
+func MeetingSettingsHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		var settings event.MeetingSettings
+		if err := json.NewDecoder(r.Body).Decode(&settings); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		err := meeting.UpdateSettings(r.Context(), actorFrom(r), settings)
+		if errors.Is(err, websocket.ErrMeetingActive) {
+			http.Error(w, "Settings cannot be changed while the meeting is active", http.StatusConflict)
+			return
+		}
+		if errors.Is(err, websocket.ErrMeetingStarted) {
+			http.Error(w, "Only imageAnimalType can be changed after the meeting has started", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, meeting.Status())
+	}
+}
+
+type scheduleRequest struct {
+	StartAt  time.Time `json:"startAt"`  // RFC3339。省略すると手動で開始する
+	Duration string    `json:"duration"` // 30m, 1h30mなど。省略すると自動で終了しない
+	Warnings []string  `json:"warnings"` // 終了の何分前に予告するか。省略すると既定値
+}
+
+func (req scheduleRequest) schedule() (websocket.Schedule, error) {
+	sc := websocket.Schedule{StartAt: req.StartAt}
+	if req.Duration != "" {
+		d, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			return sc, errInvalidParam("duration", req.Duration)
+		}
+		sc.Duration = d
+	}
+	if req.Warnings != nil {
+		sc.Warnings = make([]time.Duration, 0, len(req.Warnings))
+		for _, v := range req.Warnings {
+			w, err := time.ParseDuration(v)
+			if err != nil {
+				return sc, errInvalidParam("warnings", v)
+			}
+			sc.Warnings = append(sc.Warnings, w)
+		}
+	}
+	return sc, nil
+}
+
+// PUT /meetings/{id}/schedule {"startAt": "2024-08-01T10:00:00+09:00", "duration": "30m", "warnings": ["5m", "1m"]}
+// 開始予定日時になると自動で開始し、予定の会議時間に達すると自動で終了する。管理者のみ
+func MeetingScheduleHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		var req scheduleRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		sc, err := req.schedule()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = meeting.Schedule(r.Context(), actorFrom(r), sc)
+		if errors.Is(err, websocket.ErrMeetingStarted) {
+			writeControlError(w, r, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, meeting.Status())
+	}
+}
+
+// PUT /meetings/{id}/agenda {"sections": [{"title": "warm-up", "duration": "5m"}, {"title": "diverge", "duration": "20m"}]}
+// 開始前の会議のアジェンダを設定する。セクションは予定の時間が過ぎると次に進む。管理者のみ
+func MeetingAgendaHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		var req struct {
+			Sections []struct {
+				Title    string `json:"title"`
+				Duration string `json:"duration"`
+			} `json:"sections"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		sections := make([]websocket.SectionPlan, 0, len(req.Sections))
+		for _, sec := range req.Sections {
+			d, err := time.ParseDuration(sec.Duration)
+			if err != nil {
+				http.Error(w, errInvalidParam("duration", sec.Duration).Error(), http.StatusBadRequest)
+				return
+			}
+			sections = append(sections, websocket.SectionPlan{Title: sec.Title, Duration: d})
+		}
+		err := meeting.SetAgenda(r.Context(), actorFrom(r), sections)
+		if errors.Is(err, websocket.ErrMeetingStarted) {
+			writeControlError(w, r, err)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, r, meeting.Status())
+	}
+}
+
+// POST /meetings/{id}/agenda/next
+// 予定の時間を待たずに次のセクションに進む。管理者のみ
+func NextSectionHandler(hub *websocket.Hub) http.HandlerFunc {
+	return MeetingActionHandler(hub, (*websocket.Server).NextSection)
+}
+
+// GET /meetings/{id}/participants
+// 接続中の参加者。idは参加者を切断するときに指定する。管理者のみ
+func MeetingParticipantsHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		writeJSON(w, r, meeting.Participants())
+	}
+}
+
+// DELETE /meetings/{id}/participants/{pid}?reason=...
+// 参加者を切断する。pidは参加者のid、Nickname、ClientIdのいずれか。管理者のみ
+func RemoveParticipantHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		err := meeting.Moderate(r.Context(), actorFrom(r), websocket.ActionKick, r.PathValue("pid"), r.URL.Query().Get("reason"))
+		if err != nil {
+			writeControlError(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// POST /meetings/{id}/participants/{pid}/{action} {"reason": "spam"}
+// actionはkick, mute, unmute, exclude, include, ban, unban。
+// 対象のClientに通知し、イベントとして記録する。管理者のみ
+func ModerateParticipantHandler(hub *websocket.Hub) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meeting, ok := meetingFrom(w, r, hub)
+		if !ok {
+			return
+		}
+		var req struct {
+			Reason string `json:"reason"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		err := meeting.Moderate(r.Context(), actorFrom(r), r.PathValue("action"), r.PathValue("pid"), req.Reason)
+		if errors.Is(err, websocket.ErrUnknownAction) {
+			http.Error(w, errInvalidParam("action", r.PathValue("action")).Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			writeControlError(w, r, err)
+			return
+		}
+		writeJSON(w, r, meeting.Participants())
+	}
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"fmt"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/export"
+	"smile-sync/src/utils"
+)
+
+// GET /meetings/{id}/export?kind=smile_points
+func MeetingExportHandler(w http.ResponseWriter, r *http.Request) {
+	meetingId := r.PathValue("id")
+	m, err := export.LoadMeeting(r.Context(), meetingId)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load events", "meeting_id", meetingId, "error", err)
+		http.Error(w, "Failed to load meeting", http.StatusInternalServerError)
+		return
+	}
+	if len(m.Events) == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	writeExport(w, r, "meeting", []export.Meeting{m})
+}
+
+// GET /meetings/export?from=2024-08-01&to=2024-08-31&kind=smile_points
+func MeetingsExportHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	from, to, err := utils.ParseDateRange(query.Get("from"), query.Get("to"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	meetings, err := export.LoadMeetings(r.Context(), from, to)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load meetings", "from", from, "to", to, "error", err)
+		http.Error(w, "Failed to load meetings", http.StatusInternalServerError)
+		return
+	}
+	writeExport(w, r, query.Get("from")+"_"+query.Get("to"), meetings)
+}
+
+// kindを指定した場合はそのCSVを、省略した場合は全種類のCSVをまとめたzipを返す。
+// 出力形式はCSVのみで、Parquetなど他のformatは501を返す
+func writeExport(w http.ResponseWriter, r *http.Request, name string, meetings []export.Meeting) {
+	query := r.URL.Query()
+	if format := query.Get("format"); format != "" && format != "csv" {
+		http.Error(w, fmt.Sprintf("Unsupported format: %s", format), http.StatusNotImplemented)
+		return
+	}
+
+	kind := query.Get("kind")
+	if kind == "" {
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+".zip"))
+		if err := export.WriteZip(w, meetings); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to write export", "error", err)
+		}
+		return
+	}
+	if !export.IsKind(kind) {
+		http.Error(w, fmt.Sprintf("Unknown kind: %s", kind), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name+"_"+kind+".csv"))
+	if err := export.WriteCSV(w, kind, meetings); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write export", "error", err)
+	}
+}
@@ -0,0 +1,61 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smile-sync/src/event"
+	"smile-sync/src/export"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteExport(t *testing.T) {
+	meetings := []export.Meeting{{Id: "m1", Events: []event.Event{
+		{Seq: 1, Type: event.Idea, Timestamp: time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC), Nickname: "alice"},
+	}}}
+	cases := []struct {
+		query       string
+		status      int
+		contentType string
+		filename    string
+	}{
+		{"", http.StatusOK, "application/zip", "m1.zip"},
+		{"kind=ideas", http.StatusOK, "text/csv; charset=utf-8", "m1_ideas.csv"},
+		{"kind=ideas&format=csv", http.StatusOK, "text/csv; charset=utf-8", "m1_ideas.csv"},
+		{"kind=unknown", http.StatusBadRequest, "", ""},
+		// 出力形式はCSVのみ
+		{"kind=ideas&format=parquet", http.StatusNotImplemented, "", ""},
+		{"format=parquet", http.StatusNotImplemented, "", ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/meetings/m1/export?"+c.query, nil)
+		w := httptest.NewRecorder()
+		writeExport(w, req, "m1", meetings)
+		if w.Code != c.status {
+			t.Errorf("%q: status = %d, want %d", c.query, w.Code, c.status)
+			continue
+		}
+		if c.status != http.StatusOK {
+			continue
+		}
+		if got := w.Header().Get("Content-Type"); got != c.contentType {
+			t.Errorf("%q: Content-Type = %q, want %q", c.query, got, c.contentType)
+		}
+		if got := w.Header().Get("Content-Disposition"); !strings.Contains(got, `"`+c.filename+`"`) {
+			t.Errorf("%q: Content-Disposition = %q, want %s", c.query, got, c.filename)
+		}
+	}
+}
+
+// 期間の指定が不正な場合は会議を読み込まずに400を返す
+func TestMeetingsExportInvalidRange(t *testing.T) {
+	for _, query := range []string{"", "from=2024-08-01", "from=2024-08-31&to=2024-08-01", "from=08/01&to=08/31"} {
+		req := httptest.NewRequest("GET", "/meetings/export?"+query, nil)
+		w := httptest.NewRecorder()
+		MeetingsExportHandler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("%q: status = %d, want %d", query, w.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,29 @@
+package handler
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/health"
+)
+
+// GET /healthz プロセスが応答できるかどうかだけを返す
+func HealthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, r, map[string]string{"status": health.StatusOK})
+}
+
+// GET /readyz 依存先を確認し、利用できないものがあれば503を返す
+func ReadyzHandler(checker *health.Checker) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		report := checker.Run(r.Context())
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		if !report.OK() {
+			slog.WarnContext(r.Context(), "Readiness check failed", "checks", report.Checks)
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		if err := json.NewEncoder(w).Encode(report); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to write response", "error", err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/event"
+	"smile-sync/src/websocket"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type InviteOptions struct {
+	ClientAddress string        // 招待リンクの飛び先
+	DefaultTTL    time.Duration // ttlを省略した場合の参加コードの有効期間
+	AuditLog      *audit.Log    // 参加コードの発行を記録する
+}
+
+type inviteRequest struct {
+	TTL       string `json:"ttl"` // 10m, 24hなど。省略時はDefaultTTL、0なら期限なし
+	SingleUse bool   `json:"singleUse"`
+	// 会議の作成時のみ。省略した設定は既定値のまま
+	Settings *event.MeetingSettings `json:"settings"`
+	Schedule *scheduleRequest       `json:"schedule"`
+}
+
+type inviteResponse struct {
+	MeetingId string     `json:"meetingId"`
+	Code      string     `json:"code"`
+	InviteUrl string     `json:"inviteUrl"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+	SingleUse bool       `json:"singleUse"`
+}
+
+// 本文は省略できる
+func decodeInviteRequest(r *http.Request, opts InviteOptions) (inviteRequest, time.Duration, error) {
+	var req inviteRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return req, 0, errors.New("invalid request payload")
+	}
+	if req.TTL == "" {
+		return req, opts.DefaultTTL, nil
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil || ttl < 0 {
+		return req, 0, errInvalidParam("ttl", req.TTL)
+	}
+	return req, ttl, nil
+}
+
+func createInvite(w http.ResponseWriter, r *http.Request, authn *auth.Authenticator, opts InviteOptions, meetingId string, ttl time.Duration, singleUse bool) {
+	claims, _ := auth.ClaimsFrom(r.Context())
+	invite, link, err := authn.CreateInvite(r.Context(), meetingId, claims.Nickname, ttl, singleUse)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to create invite", "meeting_id", meetingId, "error", err)
+		http.Error(w, "Failed to create invite", http.StatusInternalServerError)
+		return
+	}
+	slog.InfoContext(r.Context(), "Invite created", "meeting_id", meetingId, "created_by", claims.Nickname, "single_use", singleUse)
+	// 参加コードそのものは記録しない
+	opts.AuditLog.Record(r.Context(), audit.Entry{
+		Actor:     claims.Nickname,
+		Via:       audit.ViaAPI,
+		Action:    audit.InviteCreate,
+		MeetingId: meetingId,
+		After:     map[string]interface{}{"singleUse": invite.SingleUse, "expiresAt": invite.ExpiresAt},
+	})
+	resp := inviteResponse{
+		MeetingId: meetingId,
+		Code:      invite.Code,
+		InviteUrl: strings.TrimSuffix(opts.ClientAddress, "/") + "/join?invite=" + url.QueryEscape(link),
+		SingleUse: invite.SingleUse,
+	}
+	if !invite.ExpiresAt.IsZero() {
+		resp.ExpiresAt = &invite.ExpiresAt
+	}
+	w.WriteHeader(http.StatusCreated)
+	writeJSON(w, r, resp)
+}
+
+// POST /meetings {"ttl": "24h", "singleUse": false, "settings": {"imageAnimalType": "cat"}, "schedule": {"startAt": "...", "duration": "30m"}}
+// 会議を作成し、その会議の参加コードと招待リンクを返す。管理者のみ
+func CreateMeetingHandler(hub *websocket.Hub, authn *auth.Authenticator, opts InviteOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		req, ttl, err := decodeInviteRequest(r, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Settings != nil {
+			if err := req.Settings.Or(event.DefaultSettings()).Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		var sc *websocket.Schedule
+		if req.Schedule != nil {
+			schedule, err := req.Schedule.schedule()
+			if err == nil {
+				err = schedule.Validate(time.Now())
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			sc = &schedule
+		}
+		meetingId := hub.CreateMeeting(r.Context(), actorFrom(r))
+		meeting, _ := hub.Meeting(meetingId)
+		// 設定できなかった会議は参加コードを発行する前に削除し、ルームを残さない
+		if req.Settings != nil {
+			if err := meeting.UpdateSettings(r.Context(), actorFrom(r), *req.Settings); err != nil {
+				hub.DiscardMeeting(meetingId)
+				writeControlError(w, r, err)
+				return
+			}
+		}
+		if sc != nil {
+			if err := meeting.Schedule(r.Context(), actorFrom(r), *sc); err != nil {
+				hub.DiscardMeeting(meetingId)
+				writeControlError(w, r, err)
+				return
+			}
+		}
+		createInvite(w, r, authn, opts, meetingId, ttl, req.SingleUse)
+	}
+}
+
+// POST /meetings/{id}/invites {"ttl": "10m", "singleUse": true}
+// 作成済みの会議の参加コードを追加で発行する。管理者のみ
+func CreateInviteHandler(hub *websocket.Hub, authn *auth.Authenticator, opts InviteOptions) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		meetingId := r.PathValue("id")
+		if _, ok := hub.Meeting(meetingId); !ok {
+			http.Error(w, "Meeting not found", http.StatusNotFound)
+			return
+		}
+		req, ttl, err := decodeInviteRequest(r, opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		createInvite(w, r, authn, opts, meetingId, ttl, req.SingleUse)
+	}
+}
+
+// POST /join {"code": "ABC234", "nickname": "alice"} または {"invite": "<招待リンクのトークン>", "nickname": "alice"}
+// 参加コードを確認し、その会議にのみ接続できるセッションを返す
+func JoinHandler(hub *websocket.Hub, authn *auth.Authenticator, trustProxy bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Code     string `json:"code"`
+			Invite   string `json:"invite"`
+			Nickname string `json:"nickname"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
+			return
+		}
+		codeOrLink := req.Code
+		if req.Invite != "" {
+			codeOrLink = req.Invite
+		}
+
+		ip := clientIP(r, trustProxy)
+		claims, token, err := authn.Join(r.Context(), ip, codeOrLink, req.Nickname)
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			slog.WarnContext(r.Context(), "Join locked", "ip", ip)
+			w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+1)))
+			http.Error(w, "Too many failed attempts", http.StatusTooManyRequests)
+			return
+		case errors.Is(err, auth.ErrInviteNotFound):
+			slog.InfoContext(r.Context(), "Join failed", "ip", ip, "nickname", req.Nickname)
+			http.Error(w, "Invalid join code", http.StatusNotFound)
+			return
+		case errors.Is(err, auth.ErrInviteExpired), errors.Is(err, auth.ErrInviteUsed):
+			http.Error(w, err.Error(), http.StatusGone)
+			return
+		case errors.Is(err, auth.ErrInvalidNickname):
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to join meeting", "error", err)
+			http.Error(w, "Failed to join meeting", http.StatusInternalServerError)
+			return
+		}
+		// 再起動で開始前の会議のルームがなくなっていても参加できるようにする
+		if err := hub.EnsureMeeting(r.Context(), claims.MeetingId); err != nil {
+			slog.ErrorContext(r.Context(), "Failed to load meeting", "meeting_id", claims.MeetingId, "error", err)
+			http.Error(w, "Failed to join meeting", http.StatusInternalServerError)
+			return
+		}
+		slog.InfoContext(r.Context(), "Joined meeting", "meeting_id", claims.MeetingId, "nickname", claims.Nickname)
+		writeJSON(w, r, map[string]string{
+			"token":     token,
+			"meetingId": claims.MeetingId,
+			"nickname":  claims.Nickname,
+			"role":      claims.Role,
+		})
+	}
+}
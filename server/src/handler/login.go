@@ -2,37 +2,70 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"log/slog"
+	"net"
 	"net/http"
-	"os"
+	"smile-sync/src/auth"
+	"strconv"
+	"strings"
 )
 
-func LoginHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
-		return
-	}
-
-	var creds map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
-		http.Error(w, "Invalid request payload", http.StatusBadRequest)
-	}
-
-	adminNickname := os.Getenv("ADMIN_NICKNAME")
-	adminPassword := os.Getenv("ADMIN_PASSWORD")
-	loginPassword := os.Getenv("LOGIN_PASSWORD")
+// trustProxyが有効な場合のみ、X-Forwarded-Forからログインの失敗を数えるIPを決める
+func LoginHandler(authn *auth.Authenticator, trustProxy bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Invalid request method", http.StatusMethodNotAllowed)
+			return
+		}
 
-	if creds["nickname"] == adminNickname {
-		// 管理者の場合はADMIN_PASSWORD
-		if creds["password"] != adminPassword {
-			http.Error(w, "Invalid password for Admin", http.StatusUnauthorized)
+		var creds map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&creds); err != nil {
+			http.Error(w, "Invalid request payload", http.StatusBadRequest)
 			return
 		}
-	} else {
-		// 一般ユーザの場合はLOGIN_PASSWORD
-		if creds["password"] != loginPassword {
+
+		// 管理者はアカウントのパスワードかADMIN_PASSWORD、一般ユーザはアカウントのパスワードかLOGIN_PASSWORD
+		ip := clientIP(r, trustProxy)
+		user, err := authn.Login(r.Context(), ip, creds["nickname"], creds["password"])
+		var locked *auth.LockedError
+		switch {
+		case errors.As(err, &locked):
+			slog.WarnContext(r.Context(), "Login locked", "ip", ip, "nickname", creds["nickname"])
+			w.Header().Set("Retry-After", strconv.Itoa(int(locked.RetryAfter.Seconds()+1)))
+			http.Error(w, "Too many failed login attempts", http.StatusTooManyRequests)
+			return
+		case errors.Is(err, auth.ErrInvalidCredentials):
+			slog.InfoContext(r.Context(), "Login failed", "ip", ip, "nickname", creds["nickname"])
 			http.Error(w, "Invalid password", http.StatusUnauthorized)
 			return
+		case err != nil:
+			slog.ErrorContext(r.Context(), "Failed to authenticate", "error", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		// websocketの接続や管理者のAPIではこのセッションを使う
+		token, err := authn.NewSession(user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to issue session", "error", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
 		}
+		writeJSON(w, r, map[string]string{"nickname": user.Nickname, "role": user.Role, "token": token})
+	}
+}
+
+// Cloud Runではプロキシが接続元のIPをX-Forwarded-Forの末尾に追加する。
+// 先頭側はClientが自由に指定できるので使わない。プロキシを通さずに直接接続できる環境では
+// 末尾も偽装できるので、trustProxyが有効な場合のみX-Forwarded-Forを使う
+func clientIP(r *http.Request, trustProxy bool) string {
+	if forwarded := r.Header.Get("X-Forwarded-For"); trustProxy && forwarded != "" {
+		parts := strings.Split(forwarded, ",")
+		return strings.TrimSpace(parts[len(parts)-1])
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
 	}
-	w.WriteHeader(http.StatusOK)
+	return host
 }
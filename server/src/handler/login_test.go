@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	cases := []struct {
+		forwarded  string
+		trustProxy bool
+		want       string
+	}{
+		{"", false, "192.0.2.1"},
+		{"", true, "192.0.2.1"},
+		// プロキシを信頼しない場合、X-Forwarded-Forは偽装できるので使わない
+		{"203.0.113.9", false, "192.0.2.1"},
+		// プロキシが末尾に追加したIPを使い、Clientが指定した先頭側は使わない
+		{"198.51.100.7, 203.0.113.9", true, "203.0.113.9"},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/login", nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if c.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", c.forwarded)
+		}
+		if got := clientIP(req, c.trustProxy); got != c.want {
+			t.Errorf("clientIP(%q, %v) = %q, want %q", c.forwarded, c.trustProxy, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/firebase"
+	"smile-sync/src/report"
+	"smile-sync/src/utils"
+	"strconv"
+	"time"
+)
+
+// GET /meetings/{id}/report
+func MeetingReportHandler(w http.ResponseWriter, r *http.Request) {
+	meetingId := r.PathValue("id")
+	events, err := firebase.LoadEvents(r.Context(), meetingId, 0)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to load events", "meeting_id", meetingId, "error", err)
+		http.Error(w, "Failed to load meeting", http.StatusInternalServerError)
+		return
+	}
+	if len(events) == 0 {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, r, report.Build(meetingId, events, time.Now()))
+}
+
+func writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.ErrorContext(r.Context(), "Failed to write response", "error", err)
+	}
+}
+
+func errInvalidParam(name, value string) error {
+	return fmt.Errorf("invalid %s: %q", name, value)
+}
+
+// GET /meetings?from=2024-08-01&to=2024-08-31&nickname=alice&minLevel=5&animalType=cat&limit=20&pageToken=...
+func MeetingsHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := firebase.MeetingQuery{
+		Nickname:   query.Get("nickname"),
+		AnimalType: query.Get("animalType"),
+		Limit:      20,
+		PageToken:  query.Get("pageToken"),
+	}
+	if v := query.Get("from"); v != "" {
+		from, err := utils.ParseDate(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.From = from
+	}
+	if v := query.Get("to"); v != "" {
+		to, err := utils.ParseDate(v)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// 終了日を含める
+		q.To = to.AddDate(0, 0, 1)
+	}
+	if v := query.Get("minLevel"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			http.Error(w, errInvalidParam("minLevel", v).Error(), http.StatusBadRequest)
+			return
+		}
+		q.MinLevel = n
+	}
+	if v := query.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			http.Error(w, errInvalidParam("limit", v).Error(), http.StatusBadRequest)
+			return
+		}
+		q.Limit = n
+	}
+
+	meetings, nextPageToken, err := firebase.ListMeetingSummaries(r.Context(), q)
+	if errors.Is(err, firebase.ErrInvalidPageToken) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Failed to list meetings", "error", err)
+		http.Error(w, "Failed to list meetings", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, r, struct {
+		Meetings      []firebase.MeetingSummary `json:"meetings"`
+		NextPageToken string                    `json:"nextPageToken,omitempty"`
+	}{meetings, nextPageToken})
+}
@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"smile-sync/src/auth"
+	"smile-sync/src/logging"
+	"smile-sync/src/oidc"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	loginStateCookie = "oidc_state"
+	loginStateTTL    = 10 * time.Minute // IDプロバイダでのログインにかけられる時間
+)
+
+// GET /auth/oidc/login
+// IDプロバイダのログイン画面にリダイレクトする。stateとnonceは署名してCookieに保存する
+func OIDCLoginHandler(provider *oidc.Provider, authn *auth.Authenticator) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		state := auth.Claims{
+			Code:     logging.NewID() + logging.NewID(),
+			Nonce:    logging.NewID() + logging.NewID(),
+			Verifier: oauth2.GenerateVerifier(),
+		}
+		authURL, err := provider.AuthCodeURL(r.Context(), state.Code, state.Nonce, state.Verifier)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to start OIDC login", "error", err)
+			http.Error(w, "Identity provider is unavailable", http.StatusBadGateway)
+			return
+		}
+		cookie, err := authn.SignLoginState(state, loginStateTTL)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to sign login state", "error", err)
+			http.Error(w, "Failed to log in", http.StatusInternalServerError)
+			return
+		}
+		http.SetCookie(w, &http.Cookie{
+			Name:     loginStateCookie,
+			Value:    cookie,
+			Path:     "/auth/oidc",
+			MaxAge:   int(loginStateTTL.Seconds()),
+			HttpOnly: true,
+			Secure:   isHTTPS(r),
+			// IDプロバイダからのリダイレクト(トップレベルのGET)では送られる
+			SameSite: http.SameSiteLaxMode,
+		})
+		http.Redirect(w, r, authURL, http.StatusFound)
+	}
+}
+
+// GET /auth/oidc/callback?code=...&state=...
+// 認可コードをIDトークンと交換し、セッションを付けてClientのログイン画面にリダイレクトする。
+// トークンがアクセスログやRefererに残らないよう、URLのフラグメントで渡す
+func OIDCCallbackHandler(provider *oidc.Provider, authn *auth.Authenticator, clientAddress string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		redirect := func(values url.Values) {
+			http.Redirect(w, r, strings.TrimSuffix(clientAddress, "/")+"/login#"+values.Encode(), http.StatusFound)
+		}
+		fail := func(reason string) {
+			redirect(url.Values{"error": {reason}})
+		}
+		query := r.URL.Query()
+		// 使い終わったstateは削除する
+		http.SetCookie(w, &http.Cookie{Name: loginStateCookie, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true, Secure: isHTTPS(r)})
+
+		if reason := query.Get("error"); reason != "" {
+			slog.InfoContext(r.Context(), "OIDC login was not completed", "error", reason, "description", query.Get("error_description"))
+			fail(reason)
+			return
+		}
+		cookie, err := r.Cookie(loginStateCookie)
+		if err != nil {
+			fail("missing_state")
+			return
+		}
+		state, err := authn.VerifyLoginState(cookie.Value)
+		if err != nil || state.Code != query.Get("state") {
+			slog.WarnContext(r.Context(), "OIDC callback with invalid state", "error", err)
+			fail("invalid_state")
+			return
+		}
+
+		user, err := provider.Exchange(r.Context(), query.Get("code"), state.Nonce, state.Verifier)
+		if err != nil {
+			slog.WarnContext(r.Context(), "OIDC login failed", "error", err)
+			fail("login_failed")
+			return
+		}
+		token, err := authn.NewSession(user)
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Failed to issue session", "error", err)
+			fail("server_error")
+			return
+		}
+		slog.InfoContext(r.Context(), "Logged in with OIDC", "nickname", user.Nickname, "role", user.Role)
+		redirect(url.Values{"token": {token}, "nickname": {user.Nickname}, "role": {user.Role}})
+	}
+}
+
+// Cloud RunではプロキシでTLSが終端されるので、X-Forwarded-Protoも確認する
+func isHTTPS(r *http.Request) bool {
+	return r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https"
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"smile-sync/src/oidc"
+	"smile-sync/src/oidc/oidctest"
+	"testing"
+	"time"
+)
+
+const testClientAddress = "http://client.test"
+
+// モックのIDプロバイダと、ログインのエンドポイントだけを持つサーバを起動する
+func newOIDCTestServer(t *testing.T) (*oidctest.Provider, *httptest.Server, *auth.Authenticator) {
+	t.Helper()
+	idp, err := oidctest.NewProvider("smilesync", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+
+	authn := auth.NewAuthenticator(nil, nil, auth.Options{Signer: auth.NewTokenSigner([]byte("secret")), SessionTTL: time.Hour})
+	mux := http.NewServeMux()
+	srv := httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	provider := oidc.NewProvider(config.OIDC{
+		Issuer:        idp.Issuer(),
+		ClientId:      "smilesync",
+		ClientSecret:  "client-secret",
+		RedirectURL:   srv.URL + "/auth/oidc/callback",
+		NicknameClaim: "preferred_username",
+		RoleClaim:     "groups",
+		AdminGroups:   []string{"smilesync-admins"},
+	})
+	mux.HandleFunc("GET /auth/oidc/login", OIDCLoginHandler(provider, authn))
+	mux.HandleFunc("GET /auth/oidc/callback", OIDCCallbackHandler(provider, authn, testClientAddress))
+	return idp, srv, authn
+}
+
+// ログインを開始し、Clientに戻ってきたときのフラグメントを返す
+func oidcLogin(t *testing.T, srv *httptest.Server, path string) url.Values {
+	t.Helper()
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{
+		Jar: jar,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if req.URL.Host == "client.test" {
+				return http.ErrUseLastResponse
+			}
+			return nil
+		},
+	}
+	resp, err := client.Get(srv.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	location, err := resp.Location()
+	if err != nil {
+		t.Fatalf("status = %d, want a redirect to the client", resp.StatusCode)
+	}
+	fragment, err := url.ParseQuery(location.Fragment)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fragment
+}
+
+func TestOIDCLogin(t *testing.T) {
+	idp, srv, authn := newOIDCTestServer(t)
+	idp.SetClaims(map[string]interface{}{"preferred_username": "alice", "groups": []string{"smilesync-admins"}})
+
+	fragment := oidcLogin(t, srv, "/auth/oidc/login")
+	if fragment.Get("error") != "" {
+		t.Fatalf("login failed: %s", fragment.Get("error"))
+	}
+	if fragment.Get("nickname") != "alice" || fragment.Get("role") != auth.RoleAdmin {
+		t.Errorf("fragment = %v", fragment)
+	}
+	claims, err := authn.VerifySession(fragment.Get("token"))
+	if err != nil || claims.Nickname != "alice" || !claims.IsAdmin() {
+		t.Errorf("VerifySession = %+v, %v", claims, err)
+	}
+
+	idp.SetClaims(map[string]interface{}{"preferred_username": "bob", "groups": []string{"staff"}})
+	if fragment := oidcLogin(t, srv, "/auth/oidc/login"); fragment.Get("role") != auth.RoleMember {
+		t.Errorf("fragment = %v", fragment)
+	}
+}
+
+func TestOIDCCallbackRejectsForgedState(t *testing.T) {
+	_, srv, _ := newOIDCTestServer(t)
+	// ログインを開始していない(stateのCookieがない)コールバックは受け付けない
+	fragment := oidcLogin(t, srv, "/auth/oidc/callback?code=abc&state=xyz")
+	if fragment.Get("error") != "missing_state" || fragment.Get("token") != "" {
+		t.Errorf("fragment = %v", fragment)
+	}
+}
@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StatusOK          = "ok"
+	StatusUnavailable = "unavailable"
+	StatusSkipped     = "skipped" // 任意の依存先が設定されていない。利用可能かどうかには影響しない
+)
+
+// 依存先が設定されていないことを表す。チェックはこれをラップして理由を返す
+var ErrSkipped = errors.New("skipped")
+
+// 1つのチェックにかける時間の上限
+const defaultTimeout = 2 * time.Second
+
+// 依存先が利用可能であればnilを返す
+type CheckFunc func(ctx context.Context) error
+
+type check struct {
+	name string
+	fn   CheckFunc
+}
+
+type CheckResult struct {
+	Status     string `json:"status"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"durationMs"`
+}
+
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+func (r Report) OK() bool {
+	return r.Status == StatusOK
+}
+
+// 登録されたチェックを並行に実行し、スキップしたもの以外が全て成功した場合のみ利用可能とする
+type Checker struct {
+	checks  []check
+	timeout time.Duration
+}
+
+func NewChecker() *Checker {
+	return &Checker{timeout: defaultTimeout}
+}
+
+func (c *Checker) Register(name string, fn CheckFunc) {
+	c.checks = append(c.checks, check{name: name, fn: fn})
+}
+
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]CheckResult, len(c.checks))}
+	results := make([]CheckResult, len(c.checks))
+	var wg sync.WaitGroup
+	for i, ch := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = c.run(ctx, ch.fn)
+		}()
+	}
+	wg.Wait()
+
+	for i, ch := range c.checks {
+		report.Checks[ch.name] = results[i]
+		if results[i].Status == StatusUnavailable {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, fn CheckFunc) CheckResult {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := time.Now()
+	// 応答しないチェックがあっても期限で打ち切る
+	done := make(chan error, 1)
+	go func() {
+		done <- fn(ctx)
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := CheckResult{Status: StatusOK, DurationMs: time.Since(start).Milliseconds()}
+	switch {
+	case errors.Is(err, ErrSkipped):
+		result.Status = StatusSkipped
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = StatusUnavailable
+		result.Error = err.Error()
+	}
+	return result
+}
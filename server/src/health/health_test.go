@@ -0,0 +1,71 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestRunAllChecksOK(t *testing.T) {
+	c := NewChecker()
+	c.Register("storage", func(ctx context.Context) error { return nil })
+	c.Register("imageProvider", func(ctx context.Context) error { return nil })
+
+	report := c.Run(context.Background())
+	if !report.OK() {
+		t.Fatalf("report = %+v, want ok", report)
+	}
+	if len(report.Checks) != 2 {
+		t.Errorf("checks = %d, want 2", len(report.Checks))
+	}
+}
+
+func TestRunFailingCheck(t *testing.T) {
+	c := NewChecker()
+	c.Register("storage", func(ctx context.Context) error { return nil })
+	c.Register("broadcastLoop", func(ctx context.Context) error { return errors.New("stalled") })
+
+	report := c.Run(context.Background())
+	if report.Status != StatusUnavailable {
+		t.Fatalf("status = %s, want %s", report.Status, StatusUnavailable)
+	}
+	if got := report.Checks["broadcastLoop"]; got.Status != StatusUnavailable || got.Error != "stalled" {
+		t.Errorf("broadcastLoop = %+v", got)
+	}
+	if got := report.Checks["storage"]; got.Status != StatusOK {
+		t.Errorf("storage = %+v", got)
+	}
+}
+
+func TestRunTimesOutHangingCheck(t *testing.T) {
+	c := NewChecker()
+	c.timeout = 10 * time.Millisecond
+	block := make(chan struct{})
+	defer close(block)
+	c.Register("storage", func(ctx context.Context) error {
+		<-block
+		return nil
+	})
+
+	report := c.Run(context.Background())
+	if got := report.Checks["storage"]; got.Status != StatusUnavailable || got.Error != context.DeadlineExceeded.Error() {
+		t.Errorf("storage = %+v", got)
+	}
+}
+
+// 設定されていない任意の依存先はスキップとして返し、利用可能かどうかには影響しない
+func TestRunSkippedCheck(t *testing.T) {
+	c := NewChecker()
+	c.Register("storage", func(ctx context.Context) error { return nil })
+	c.Register("imageProvider", func(ctx context.Context) error { return fmt.Errorf("%w: not set", ErrSkipped) })
+
+	report := c.Run(context.Background())
+	if !report.OK() {
+		t.Fatalf("report = %+v, want ok", report)
+	}
+	if got := report.Checks["imageProvider"]; got.Status != StatusSkipped || got.Error != "skipped: not set" {
+		t.Errorf("imageProvider = %+v", got)
+	}
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+)
+
+func TestWithAddsAttrsToRecords(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info", "json")
+
+	ctx := With(context.Background(), "meeting_id", "20240101000000")
+	ctx = With(ctx, "conn_id", "abc")
+	logger.InfoContext(ctx, "Received", "type", "smilePoint")
+	logger.DebugContext(ctx, "not logged")
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("expected a single JSON line, got %q: %v", buf.String(), err)
+	}
+	want := map[string]any{
+		"severity":   "INFO",
+		"message":    "Received",
+		"type":       "smilePoint",
+		"meeting_id": "20240101000000",
+		"conn_id":    "abc",
+	}
+	for k, v := range want {
+		if line[k] != v {
+			t.Errorf("%s = %v, want %v", k, line[k], v)
+		}
+	}
+}
+
+func TestWithDoesNotShareAttrs(t *testing.T) {
+	base := With(context.Background(), "meeting_id", "m")
+	a := With(base, "conn_id", "a")
+	b := With(base, "conn_id", "b")
+
+	attrsA := a.Value(attrsKey{}).([]slog.Attr)
+	attrsB := b.Value(attrsKey{}).([]slog.Attr)
+	if attrsA[1].Value.String() != "a" || attrsB[1].Value.String() != "b" {
+		t.Errorf("attrs = %v, %v", attrsA, attrsB)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	cases := map[string]slog.Level{
+		"debug":   slog.LevelDebug,
+		"WARN":    slog.LevelWarn,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+		"":        slog.LevelInfo,
+		"verbose": slog.LevelInfo,
+	}
+	for in, want := range cases {
+		if got := ParseLevel(in); got != want {
+			t.Errorf("ParseLevel(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestSampler(t *testing.T) {
+	s := NewSampler(3)
+	got := make([]bool, 7)
+	for i := range got {
+		got[i] = s.Allow()
+	}
+	want := []bool{true, false, false, true, false, false, true}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("Allow() = %v, want %v", got, want)
+		}
+	}
+}
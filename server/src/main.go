@@ -1,49 +1,216 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/cli"
+	"smile-sync/src/config"
 	"smile-sync/src/firebase"
 	"smile-sync/src/handler"
+	"smile-sync/src/health"
+	"smile-sync/src/logging"
+	"smile-sync/src/metrics"
 	"smile-sync/src/middleware"
-	"smile-sync/src/utils"
+	"smile-sync/src/oidc"
+	"smile-sync/src/origin"
+	"smile-sync/src/persistence"
 	"smile-sync/src/websocket"
+	"syscall"
 	"time"
-
-	"github.com/joho/godotenv"
 )
 
-func init() {
-	envPath := ".env"
-	// debug時の.envファイルのパス指定
-	if _, err := os.Stat(envPath); os.IsNotExist(err) {
-		envPath = "../.env"
-	}
-	err := godotenv.Load(envPath)
+func main() {
+	// 環境変数、.env、設定ファイルから設定を読み込む。不足や不正な値があれば起動しない
+	cfg, err := config.Load()
 	if err != nil {
-		log.Fatalf("Error loading .env file: %v", err)
+		// ロガーの設定も読み込めていないので、標準エラー出力に1行ずつ出す
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(1)
+	}
+	logging.Setup(cfg.Log.Level, cfg.Log.Format)
+	for _, warning := range cfg.Warnings {
+		slog.Warn(warning)
+	}
+
+	// サブコマンドが指定された場合は、サーバを起動せずに実行して終了する
+	if len(os.Args) > 1 {
+		firebase.InitFirestore(cfg.Firestore.ProjectId)
+		err := cli.Run(os.Args[1:])
+		firebase.CloseFirestore()
+		if err != nil {
+			slog.Error("Command failed", "error", err)
+			os.Exit(1)
+		}
+		return
 	}
-}
 
-func main() {
-	// FirestoreのドキュメントIDを生成
-	firebase.DocId = utils.ConvertYYYYMMDDHHMMSS(time.Now())
 	// Firestore初期化
-	firebase.InitFirestore()
+	firebase.InitFirestore(cfg.Firestore.ProjectId)
 	defer firebase.CloseFirestore()
 
-	s := websocket.NewServer()
+	// イベントはキューに溜めて、まとめてFirestoreに書き込む
+	queue := persistence.NewQueue(firebase.AppendEvents, persistence.Options{
+		BatchSize:     cfg.Persistence.BatchSize,
+		FlushInterval: cfg.Persistence.FlushInterval,
+		MaxDepth:      cfg.Persistence.MaxDepth,
+		MaxRetries:    cfg.Persistence.MaxRetries,
+		RetryBackoff:  cfg.Persistence.RetryBackoff,
+	})
+	queue.Start()
+	metrics.RegisterGaugeFunc("persistence_queue_depth", "Events waiting to be written to Firestore.", func() float64 {
+		return float64(queue.Depth())
+	})
+	metrics.RegisterCounterFunc("persistence_failed_events_total", "Events dropped after exhausting write retries.", func() float64 {
+		return float64(queue.Failed())
+	})
+
+	origins, err := origin.Parse(cfg.Origins())
+	if err != nil {
+		slog.Error("Invalid allowed origins", "error", err)
+		os.Exit(1)
+	}
+
+	// セッションと招待リンクの署名鍵。設定がなければ起動ごとに生成する
+	secret := []byte(cfg.Auth.Secret)
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			slog.Error("Failed to generate auth secret", "error", err)
+			os.Exit(1)
+		}
+	}
+	authn := auth.NewAuthenticator(firebase.UserStore{}, firebase.InviteStore{}, auth.Options{
+		SharedPassword: cfg.Auth.LoginPassword,
+		AdminNickname:  cfg.Auth.AdminNickname,
+		AdminPassword:  cfg.Auth.AdminPassword,
+		Limiter: auth.LimiterOptions{
+			MaxFailures:      cfg.Auth.MaxLoginFailures,
+			MaxFailuresPerIP: cfg.Auth.MaxLoginFailuresPerIP,
+			Lockout:          cfg.Auth.LoginLockout,
+		},
+		Signer:     auth.NewTokenSigner(secret),
+		SessionTTL: cfg.Auth.SessionTTL,
+	})
+
+	// 管理者の操作を記録する
+	auditLog := audit.NewLog(firebase.AuditStore{})
+	hub := websocket.NewHub(queue, cfg.Websocket, cfg.ImageProvider, origins, authn, auditLog)
+	// 前回のシャットダウン時に進行中だった会議があれば復元
+	if err := hub.Restore(context.Background()); err != nil {
+		slog.Error("Failed to restore meeting snapshot", "error", err)
+	}
+	inviteOptions := handler.InviteOptions{ClientAddress: cfg.ClientAddress, DefaultTTL: cfg.Auth.InviteTTL, AuditLog: auditLog}
 
 	mux := http.NewServeMux()
-	mux.HandleFunc("/login", handler.LoginHandler)
-	mux.HandleFunc("/ws", s.HandleClients)
-	go s.HandleMessages()
+	mux.HandleFunc("/login", handler.LoginHandler(authn, cfg.Auth.TrustProxy))
+	mux.HandleFunc("POST /join", handler.JoinHandler(hub, authn, cfg.Auth.TrustProxy))
+	// 共有パスワードの代わりに、社内のIDプロバイダでログインする
+	var oidcProvider *oidc.Provider
+	if cfg.OIDC.Enabled() {
+		oidcProvider = oidc.NewProvider(cfg.OIDC)
+		mux.HandleFunc("GET /auth/oidc/login", handler.OIDCLoginHandler(oidcProvider, authn))
+		mux.HandleFunc("GET /auth/oidc/callback", handler.OIDCCallbackHandler(oidcProvider, authn, cfg.ClientAddress))
+	}
+	mux.Handle("POST /meetings", middleware.RequireAdmin(authn, handler.CreateMeetingHandler(hub, authn, inviteOptions)))
+	mux.Handle("POST /meetings/{id}/invites", middleware.RequireAdmin(authn, handler.CreateInviteHandler(hub, authn, inviteOptions)))
+	mux.Handle("POST /meetings/{id}/start", middleware.RequireAdmin(authn, handler.StartMeetingHandler(hub)))
+	mux.Handle("POST /meetings/{id}/pause", middleware.RequireAdmin(authn, handler.PauseMeetingHandler(hub)))
+	mux.Handle("POST /meetings/{id}/stop", middleware.RequireAdmin(authn, handler.StopMeetingHandler(hub)))
+	mux.Handle("GET /meetings/{id}/settings", middleware.RequireAdmin(authn, handler.GetMeetingSettingsHandler(hub)))
+	mux.Handle("PUT /meetings/{id}/settings", middleware.RequireAdmin(authn, handler.MeetingSettingsHandler(hub)))
+	mux.Handle("PUT /meetings/{id}/schedule", middleware.RequireAdmin(authn, handler.MeetingScheduleHandler(hub)))
+	mux.Handle("PUT /meetings/{id}/agenda", middleware.RequireAdmin(authn, handler.MeetingAgendaHandler(hub)))
+	mux.Handle("POST /meetings/{id}/agenda/next", middleware.RequireAdmin(authn, handler.NextSectionHandler(hub)))
+	mux.Handle("GET /meetings/{id}/participants", middleware.RequireAdmin(authn, handler.MeetingParticipantsHandler(hub)))
+	mux.Handle("DELETE /meetings/{id}/participants/{pid}", middleware.RequireAdmin(authn, handler.RemoveParticipantHandler(hub)))
+	mux.Handle("POST /meetings/{id}/participants/{pid}/{action}", middleware.RequireAdmin(authn, handler.ModerateParticipantHandler(hub)))
+	mux.Handle("GET /audit", middleware.RequireAdmin(authn, http.HandlerFunc(handler.AuditHandler)))
+	mux.Handle("GET /meetings", middleware.RequireAdmin(authn, http.HandlerFunc(handler.MeetingsHandler)))
+	mux.Handle("GET /meetings/{id}/report", middleware.RequireAdmin(authn, http.HandlerFunc(handler.MeetingReportHandler)))
+	mux.Handle("GET /meetings/{id}/analytics", middleware.RequireAdmin(authn, http.HandlerFunc(handler.MeetingAnalyticsHandler)))
+	mux.Handle("GET /meetings/{id}/export", middleware.RequireAdmin(authn, http.HandlerFunc(handler.MeetingExportHandler)))
+	mux.Handle("GET /meetings/export", middleware.RequireAdmin(authn, http.HandlerFunc(handler.MeetingsExportHandler)))
+	mux.HandleFunc("/ws", hub.HandleClients)
+	// EventSourceはヘッダを付けられないので、?token=かCookieでもセッションを受け付ける
+	mux.Handle("GET /meetings/{id}/events", middleware.RequireAdminStream(authn, http.HandlerFunc(hub.HandleEventStream)))
+
+	checker := health.NewChecker()
+	checker.Register("storage", firebase.Ping)
+	checker.Register("imageProvider", hub.CheckImageProvider)
+	checker.Register("broadcastLoop", hub.CheckBroadcastLoop)
+	if oidcProvider != nil {
+		checker.Register("oidc", oidcProvider.Check)
+	}
+	mux.HandleFunc("GET /healthz", handler.HealthzHandler)
+	mux.HandleFunc("GET /readyz", handler.ReadyzHandler(checker))
+
+	port := cfg.Port
+	srv := &http.Server{
+		Addr:    fmt.Sprintf(":%v", port),
+		Handler: middleware.RequestID(middleware.EnableCORS(origins, mux)),
+	}
+	// Shutdownは処理中のリクエストの終了を待つので、SSEの配信は先に終了させる
+	srv.RegisterOnShutdown(hub.CloseStreams)
+
+	// メトリクスは認証がないので、公開するポートとは別の内部のポートで配信する
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", metrics.Handler())
+	metricsSrv := &http.Server{
+		Addr:    fmt.Sprintf(":%v", cfg.MetricsPort),
+		Handler: metricsMux,
+	}
 
-	port := os.Getenv("PORT")
-	log.Printf("Server started on port %s", port)
-	if err := http.ListenAndServe(fmt.Sprintf(":%v", port), middleware.EnableCORS(mux)); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
+	// SIGTERM(Cloud Runの停止時)やSIGINTを受け取ったらシャットダウンする
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		slog.Info("Server started", "port", port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed to start", "error", err)
+			os.Exit(1)
+		}
+	}()
+	go func() {
+		slog.Info("Metrics server started", "port", cfg.MetricsPort)
+		if err := metricsSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Metrics server failed to start", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	<-ctx.Done()
+	slog.Info("Shutting down server")
+
+	// Cloud RunはSIGTERMから10秒後に強制終了するので、それまでに終わらせる
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 8*time.Second)
+	defer cancel()
+	// 新しい接続の受付を停止
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down HTTP server", "error", err)
+	}
+	if err := metricsSrv.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to shut down metrics server", "error", err)
+	}
+	// Clientへの通知、書き込みの完了待ち、会議の状態の保存
+	if err := hub.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Failed to save meeting snapshot", "error", err)
+	}
+	// キューに残っているイベントを書き込む
+	if err := queue.Close(shutdownCtx); err != nil {
+		slog.Error("Failed to flush pending events", "error", err, "events", queue.Depth())
+	}
+	// 監査ログの保存を待つ
+	if err := auditLog.Close(shutdownCtx); err != nil {
+		slog.Error("Failed to save audit entries", "error", err)
 	}
+	slog.Info("Server stopped")
 }
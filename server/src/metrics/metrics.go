@@ -0,0 +1,97 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "smilesync"
+
+var (
+	ConnectedClients = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "connected_clients",
+		Help:      "Number of websocket clients currently connected.",
+	})
+	ActiveMeetings = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "active_meetings",
+		Help:      "Number of meetings currently in progress.",
+	})
+	MessagesReceived = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Websocket messages received from clients, by type.",
+	}, []string{"type"})
+	MessagesSent = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_sent_total",
+		Help:      "Websocket messages sent to clients, by type.",
+	}, []string{"type"})
+	MessagesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages that were not delivered or not accepted, by reason.",
+	}, []string{"reason"})
+	BroadcastDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "broadcast_duration_seconds",
+		Help:      "Time taken to send a message to all connected clients, by type.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"type"})
+	FirestoreWriteDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "firestore_write_duration_seconds",
+		Help:      "Latency of Firestore writes, by operation.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"operation"})
+	FirestoreWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "firestore_write_errors_total",
+		Help:      "Failed Firestore writes, by operation.",
+	}, []string{"operation"})
+	ImageGenerationDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "image_generation_duration_seconds",
+		Help:      "Time taken to generate an image with the image provider.",
+		Buckets:   []float64{1, 2.5, 5, 10, 15, 20, 30, 45, 60},
+	})
+	ImageGenerationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "image_generation_failures_total",
+		Help:      "Image generations that failed or returned no image.",
+	})
+)
+
+// Firestoreへの書き込みの所要時間とエラーを記録する
+func ObserveFirestoreWrite(operation string, start time.Time, err error) {
+	FirestoreWriteDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+	if err != nil {
+		FirestoreWriteErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// 他のパッケージが持つ値を、取得時に読み出すゲージとして登録する
+func RegisterGaugeFunc(name, help string, f func() float64) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, f)
+}
+
+func RegisterCounterFunc(name, help string, f func() float64) {
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      name,
+		Help:      help,
+	}, f)
+}
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func scrape(t *testing.T) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	Handler().ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	body, err := io.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// 書き込みの所要時間は全て記録し、エラーは失敗した場合のみ数える
+func TestObserveFirestoreWrite(t *testing.T) {
+	ObserveFirestoreWrite("test_write", time.Now(), nil)
+	ObserveFirestoreWrite("test_write", time.Now(), errors.New("unavailable"))
+
+	body := scrape(t)
+	for _, want := range []string{
+		`smilesync_firestore_write_duration_seconds_count{operation="test_write"} 2`,
+		`smilesync_firestore_write_errors_total{operation="test_write"} 1`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
+
+// 登録した関数の値を取得時に読み出す
+func TestRegisterFuncs(t *testing.T) {
+	depth := 3.0
+	RegisterGaugeFunc("test_queue_depth", "Test gauge.", func() float64 { return depth })
+	RegisterCounterFunc("test_failed_total", "Test counter.", func() float64 { return 7 })
+
+	depth = 5
+	body := scrape(t)
+	for _, want := range []string{
+		"smilesync_test_queue_depth 5",
+		"smilesync_test_failed_total 7",
+		"# TYPE smilesync_test_failed_total counter",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
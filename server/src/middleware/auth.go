@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"smile-sync/src/auth"
+	"strings"
+)
+
+// EventSourceはヘッダを付けられないので、SSEではクエリかCookieでセッションを渡す
+const SessionCookie = "session"
+
+// Authorization: Bearer <セッション> を確認し、管理者のセッションでなければ拒否する。
+// 確認したClaimsはauth.ClaimsFromで取り出せる
+func RequireAdmin(authn *auth.Authenticator, next http.Handler) http.Handler {
+	return requireAdmin(authn, bearerToken, next)
+}
+
+// RequireAdminと同じだが、Authorizationヘッダの代わりに?token=かCookieのセッションも受け付ける。
+// EventSourceで接続するSSEに使う
+func RequireAdminStream(authn *auth.Authenticator, next http.Handler) http.Handler {
+	return requireAdmin(authn, streamToken, next)
+}
+
+func requireAdmin(authn *auth.Authenticator, tokenFrom func(*http.Request) string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := tokenFrom(r)
+		if token == "" {
+			http.Error(w, "Authorization required", http.StatusUnauthorized)
+			return
+		}
+		claims, err := authn.VerifySession(token)
+		if err != nil {
+			slog.InfoContext(r.Context(), "Rejected invalid session", "error", err)
+			http.Error(w, "Invalid session", http.StatusUnauthorized)
+			return
+		}
+		if !claims.IsAdmin() {
+			http.Error(w, "Admin only", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(auth.WithClaims(r.Context(), claims)))
+	})
+}
+
+func bearerToken(r *http.Request) string {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return ""
+	}
+	return token
+}
+
+func streamToken(r *http.Request) string {
+	if token := bearerToken(r); token != "" {
+		return token
+	}
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	if cookie, err := r.Cookie(SessionCookie); err == nil {
+		return cookie.Value
+	}
+	return ""
+}
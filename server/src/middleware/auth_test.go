@@ -0,0 +1,89 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smile-sync/src/auth"
+	"testing"
+	"time"
+)
+
+func TestRequireAdmin(t *testing.T) {
+	signer := auth.NewTokenSigner([]byte("secret"))
+	authn := auth.NewAuthenticator(nil, nil, auth.Options{Signer: signer, SessionTTL: time.Hour})
+	admin, _ := authn.NewSession(&auth.User{Nickname: "admin", Role: auth.RoleAdmin})
+	member, _ := authn.NewSession(&auth.User{Nickname: "bob", Role: auth.RoleMember})
+	invite, _ := signer.Sign(auth.Claims{Kind: auth.KindInvite, Role: auth.RoleAdmin}, time.Hour)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if claims, ok := auth.ClaimsFrom(r.Context()); !ok || claims.Nickname != "admin" {
+			t.Errorf("claims = %+v", claims)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := RequireAdmin(authn, next)
+
+	cases := []struct {
+		authorization string
+		status        int
+	}{
+		{"Bearer " + admin, http.StatusNoContent},
+		{"Bearer " + member, http.StatusForbidden},
+		{"Bearer " + invite, http.StatusUnauthorized},
+		{admin, http.StatusUnauthorized},
+		{"", http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("POST", "/meetings", nil)
+		if c.authorization != "" {
+			req.Header.Set("Authorization", c.authorization)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("Authorization %.20q: status = %d, want %d", c.authorization, rec.Code, c.status)
+		}
+	}
+}
+
+func TestRequireAdminStream(t *testing.T) {
+	signer := auth.NewTokenSigner([]byte("secret"))
+	authn := auth.NewAuthenticator(nil, nil, auth.Options{Signer: signer, SessionTTL: time.Hour})
+	admin, _ := authn.NewSession(&auth.User{Nickname: "admin", Role: auth.RoleAdmin})
+	member, _ := authn.NewSession(&auth.User{Nickname: "bob", Role: auth.RoleMember})
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := RequireAdminStream(authn, next)
+
+	cases := []struct {
+		name    string
+		prepare func(*http.Request)
+		status  int
+	}{
+		{"header", func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+admin) }, http.StatusNoContent},
+		{"query", func(r *http.Request) { r.URL.RawQuery = "token=" + admin }, http.StatusNoContent},
+		{"cookie", func(r *http.Request) { r.AddCookie(&http.Cookie{Name: SessionCookie, Value: admin}) }, http.StatusNoContent},
+		{"member", func(r *http.Request) { r.URL.RawQuery = "token=" + member }, http.StatusForbidden},
+		{"invalid", func(r *http.Request) { r.URL.RawQuery = "token=invalid" }, http.StatusUnauthorized},
+		{"none", func(r *http.Request) {}, http.StatusUnauthorized},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest("GET", "/meetings/m1/events", nil)
+		c.prepare(req)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != c.status {
+			t.Errorf("%s: status = %d, want %d", c.name, rec.Code, c.status)
+		}
+	}
+
+	// 通常の管理者向けAPIはクエリのセッションを受け付けない
+	req := httptest.NewRequest("GET", "/meetings?token="+admin, nil)
+	rec := httptest.NewRecorder()
+	RequireAdmin(authn, next).ServeHTTP(rec, req)
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("RequireAdmin with query token: status = %d, want %d", rec.Code, http.StatusUnauthorized)
+	}
+}
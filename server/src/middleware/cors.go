@@ -2,16 +2,27 @@ package middleware
 
 import (
 	"net/http"
-	"os"
+	"smile-sync/src/origin"
 )
 
-func EnableCORS(next http.Handler) http.Handler {
-	clientAddress := os.Getenv("CLIENT_ADDRESS")
+// 許可されたOriginからのリクエストにのみCORSのヘッダを付ける
+func EnableCORS(origins *origin.Allowlist, next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Access-Control-Allow-Origin", clientAddress)
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		// Originによってレスポンスが変わるので、キャッシュでOriginごとに区別させる
+		w.Header().Add("Vary", "Origin")
+		requestOrigin := r.Header.Get("Origin")
+		allowed := requestOrigin != "" && origins.Allowed(requestOrigin)
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", requestOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization")
+		}
 		if r.Method == "OPTIONS" {
+			// 許可されていないOriginからのプリフライトは拒否する
+			if requestOrigin != "" && !allowed {
+				http.Error(w, "Origin not allowed", http.StatusForbidden)
+				return
+			}
 			w.WriteHeader(http.StatusOK)
 			return
 		}
@@ -0,0 +1,50 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"smile-sync/src/origin"
+	"testing"
+)
+
+func TestEnableCORS(t *testing.T) {
+	origins, err := origin.Parse([]string{"http://localhost:3000", "https://smilesync-*.vercel.app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	h := EnableCORS(origins, next)
+
+	cases := []struct {
+		method      string
+		origin      string
+		status      int
+		allowOrigin string
+	}{
+		{"GET", "https://smilesync-git-main.vercel.app", http.StatusNoContent, "https://smilesync-git-main.vercel.app"},
+		{"GET", "https://evil.example.com", http.StatusNoContent, ""},
+		{"GET", "", http.StatusNoContent, ""},
+		{"OPTIONS", "http://localhost:3000", http.StatusOK, "http://localhost:3000"},
+		{"OPTIONS", "https://evil.example.com", http.StatusForbidden, ""},
+	}
+	for _, c := range cases {
+		req := httptest.NewRequest(c.method, "/meetings", nil)
+		if c.origin != "" {
+			req.Header.Set("Origin", c.origin)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		if rec.Code != c.status {
+			t.Errorf("%s from %q: status = %d, want %d", c.method, c.origin, rec.Code, c.status)
+		}
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != c.allowOrigin {
+			t.Errorf("%s from %q: Access-Control-Allow-Origin = %q, want %q", c.method, c.origin, got, c.allowOrigin)
+		}
+		if got := rec.Header().Get("Vary"); got != "Origin" {
+			t.Errorf("%s from %q: Vary = %q, want Origin", c.method, c.origin, got)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"smile-sync/src/logging"
+)
+
+// リクエストごとにIDを振り、そのリクエストのログに付ける。
+// X-Request-Idヘッダが指定されていればそれを使う
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if id == "" || len(id) > 64 {
+			id = logging.NewID()
+		}
+		w.Header().Set("X-Request-Id", id)
+		ctx := logging.With(r.Context(), "request_id", id)
+		slog.DebugContext(ctx, "Request", "method", r.Method, "path", r.URL.Path)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"sync"
+
+	gooidc "github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+var (
+	ErrMissingIDToken = errors.New("token response does not contain an id_token")
+	ErrNonceMismatch  = errors.New("id_token nonce does not match")
+)
+
+// OIDCのIDプロバイダで認可コードフローのログインを行う。
+// IDプロバイダが起動時に応答しなくてもサーバは起動できるよう、ディスカバリは初めて使うときに行う
+type Provider struct {
+	cfg      config.OIDC
+	oauth    *oauth2.Config
+	verifier *gooidc.IDTokenVerifier
+	mu       sync.Mutex
+}
+
+func NewProvider(cfg config.OIDC) *Provider {
+	return &Provider{cfg: cfg}
+}
+
+// ディスカバリを行い、成功したら結果を使い回す
+func (p *Provider) discover(ctx context.Context) (*oauth2.Config, *gooidc.IDTokenVerifier, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.oauth != nil {
+		return p.oauth, p.verifier, nil
+	}
+	provider, err := gooidc.NewProvider(ctx, p.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to discover %s: %w", p.cfg.Issuer, err)
+	}
+	p.oauth = &oauth2.Config{
+		ClientID:     p.cfg.ClientId,
+		ClientSecret: p.cfg.ClientSecret,
+		RedirectURL:  p.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       []string{gooidc.ScopeOpenID, "profile", "email"},
+	}
+	p.verifier = provider.Verifier(&gooidc.Config{ClientID: p.cfg.ClientId})
+	return p.oauth, p.verifier, nil
+}
+
+// IDプロバイダのディスカバリができるかどうか
+func (p *Provider) Check(ctx context.Context) error {
+	_, _, err := p.discover(ctx)
+	return err
+}
+
+// IDプロバイダのログイン画面のURL。stateとnonceとPKCEのverifierは、コールバックで確認するために呼び出し側で保存しておく
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	oauth, _, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+	return oauth.AuthCodeURL(state, gooidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier)), nil
+}
+
+// 認可コードをトークンと交換し、IDトークンを検証してUserを返す
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*auth.User, error) {
+	oauth, idTokenVerifier, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	token, err := oauth.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("failed to exchange code: %w", err)
+	}
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok || rawIDToken == "" {
+		return nil, ErrMissingIDToken
+	}
+	idToken, err := idTokenVerifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return nil, fmt.Errorf("invalid id_token: %w", err)
+	}
+	if idToken.Nonce != nonce {
+		return nil, ErrNonceMismatch
+	}
+	var claims map[string]interface{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, err
+	}
+	return userFromClaims(p.cfg, claims)
+}
+
+// IDトークンのClaimsからNicknameと役割を決める
+func userFromClaims(cfg config.OIDC, claims map[string]interface{}) (*auth.User, error) {
+	nickname, _ := claims[cfg.NicknameClaim].(string)
+	if nickname == "" {
+		return nil, fmt.Errorf("id_token does not contain the %s claim", cfg.NicknameClaim)
+	}
+	if err := auth.ValidateNickname(nickname); err != nil {
+		return nil, err
+	}
+	role := auth.RoleMember
+	var groups []string
+	switch v := claims[cfg.RoleClaim].(type) {
+	case string:
+		groups = []string{v}
+	case []interface{}:
+		for _, g := range v {
+			if s, ok := g.(string); ok {
+				groups = append(groups, s)
+			}
+		}
+	}
+	for _, g := range groups {
+		if slices.Contains(cfg.AdminGroups, g) {
+			role = auth.RoleAdmin
+			break
+		}
+	}
+	return &auth.User{Nickname: nickname, Role: role}, nil
+}
@@ -0,0 +1,38 @@
+package oidc
+
+import (
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"testing"
+)
+
+func TestUserFromClaims(t *testing.T) {
+	cfg := config.OIDC{NicknameClaim: "preferred_username", RoleClaim: "groups", AdminGroups: []string{"smilesync-admins"}}
+	cases := []struct {
+		claims   map[string]interface{}
+		nickname string
+		role     string
+	}{
+		{map[string]interface{}{"preferred_username": "alice", "groups": []interface{}{"staff", "smilesync-admins"}}, "alice", auth.RoleAdmin},
+		{map[string]interface{}{"preferred_username": "bob", "groups": "staff"}, "bob", auth.RoleMember},
+		{map[string]interface{}{"preferred_username": "carol"}, "carol", auth.RoleMember},
+	}
+	for _, c := range cases {
+		user, err := userFromClaims(cfg, c.claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.Nickname != c.nickname || user.Role != c.role {
+			t.Errorf("userFromClaims(%v) = %+v", c.claims, user)
+		}
+	}
+
+	for _, claims := range []map[string]interface{}{
+		{"email": "alice@example.com"},
+		{"preferred_username": "a/b"},
+	} {
+		if _, err := userFromClaims(cfg, claims); err == nil {
+			t.Errorf("userFromClaims(%v) succeeded", claims)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package origin
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// ブラウザからの接続を許可するOriginの一覧。
+// パターンは "https://example.com" のような完全一致か、先頭のラベルに*を含むもの
+// ("https://*.example.com", "https://smilesync-*.vercel.app")。*はドットを含まない1文字以上に一致する
+type Allowlist struct {
+	patterns []*regexp.Regexp
+}
+
+func Parse(patterns []string) (*Allowlist, error) {
+	a := &Allowlist{}
+	for _, p := range patterns {
+		re, err := compile(p)
+		if err != nil {
+			return nil, err
+		}
+		a.patterns = append(a.patterns, re)
+	}
+	return a, nil
+}
+
+func compile(pattern string) (*regexp.Regexp, error) {
+	// 末尾の/は付けても付けなくてもよい
+	p := strings.TrimSuffix(strings.TrimSpace(pattern), "/")
+	u, err := url.Parse(strings.Replace(p, "*", "wildcard", -1))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid origin %q: must be like https://example.com", pattern)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return nil, fmt.Errorf("invalid origin %q: must not contain a path", pattern)
+	}
+	scheme, host, _ := strings.Cut(p, "://")
+	labels := strings.Split(host, ".")
+	for i, label := range labels {
+		if i > 0 && strings.Contains(label, "*") {
+			return nil, fmt.Errorf("invalid origin %q: * is only allowed in the first label", pattern)
+		}
+	}
+	if strings.Contains(labels[0], "*") && len(labels) < 3 {
+		// "https://*.com" のように広すぎるものは許可しない
+		return nil, fmt.Errorf("invalid origin %q: wildcard must be followed by at least two labels", pattern)
+	}
+	expr := regexp.QuoteMeta(strings.ToLower(scheme + "://" + host))
+	expr = strings.Replace(expr, `\*`, `[a-z0-9-]+`, -1)
+	return regexp.MustCompile("^" + expr + "$"), nil
+}
+
+// Originヘッダの値が許可されているかどうか
+func (a *Allowlist) Allowed(origin string) bool {
+	origin = strings.ToLower(origin)
+	for _, re := range a.patterns {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
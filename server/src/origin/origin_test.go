@@ -0,0 +1,53 @@
+package origin
+
+import "testing"
+
+func TestAllowed(t *testing.T) {
+	a, err := Parse([]string{
+		"http://localhost:3000/",
+		"https://smilesync.example.com",
+		"https://smilesync-*.vercel.app",
+		"https://*.preview.example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := map[string]bool{
+		"http://localhost:3000":                  true,
+		"http://localhost:3001":                  false,
+		"https://localhost:3000":                 false,
+		"https://SmileSync.example.com":          true,
+		"https://evil.smilesync.example.com":     false,
+		"https://smilesync.example.com.evil.com": false,
+		"https://smilesync-git-main.vercel.app":  true,
+		"https://smilesync-.vercel.app":          false,
+		"https://other.vercel.app":               false,
+		"https://a.b.vercel.app":                 false,
+		"https://pr-12.preview.example.com":      true,
+		"https://x.pr-12.preview.example.com":    false,
+		"https://preview.example.com":            false,
+		"http://pr-12.preview.example.com":       false,
+		"":                                       false,
+		"null":                                   false,
+	}
+	for origin, want := range cases {
+		if got := a.Allowed(origin); got != want {
+			t.Errorf("Allowed(%q) = %v, want %v", origin, got, want)
+		}
+	}
+}
+
+func TestParseRejectsInvalidPatterns(t *testing.T) {
+	for _, p := range []string{
+		"localhost:3000",
+		"ftp://example.com",
+		"https://example.com/path",
+		"https://*.com",
+		"https://app.*.example.com",
+		"*",
+	} {
+		if _, err := Parse([]string{p}); err == nil {
+			t.Errorf("Parse(%q) succeeded, want error", p)
+		}
+	}
+}
@@ -0,0 +1,258 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"math/rand"
+	"smile-sync/src/event"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// 会議のイベントをまとめて保存する関数(firebase.AppendEvents)
+type Writer func(ctx context.Context, meetingId string, events []event.Event) error
+
+type Options struct {
+	BatchSize     int           // 1回の書き込みにまとめるイベント数の上限。溜まったらすぐに書き込む
+	FlushInterval time.Duration // BatchSizeに達しなくても書き込む間隔
+	MaxDepth      int           // これを超えるとOverloadedを返し、新しいイベントの受付を止めてもらう
+	MaxRetries    int           // 一時的なエラーの場合のリトライ回数
+	RetryBackoff  time.Duration // リトライ間隔の初期値。リトライごとに2倍にする
+}
+
+// Closeの後に追加されたイベントは書き込まない
+var ErrClosed = errors.New("persistence queue is closed")
+
+type item struct {
+	meetingId string
+	ev        event.Event
+}
+
+// イベントをメモリ上に溜めておき、別のgoroutineでまとめて書き込むキュー。
+// Enqueueはブロックしないので、websocketの読み込みを止めずに済む
+type Queue struct {
+	write    Writer
+	opts     Options
+	mu       sync.Mutex
+	pending  []item
+	inflight atomic.Int64     // 書き込み中のイベント数
+	failed   atomic.Int64     // リトライしても書き込めずに破棄したイベント数
+	closed   bool             // Closeの後はEnqueueを受け付けない
+	settled  map[string]int64 // 会議ごとに、書き込みが終わった(破棄した場合を含む)最後のSeq
+	changed  chan struct{}    // settledが更新されると閉じて作り直す
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+func NewQueue(write Writer, opts Options) *Queue {
+	return &Queue{
+		write:   write,
+		opts:    opts,
+		pending: make([]item, 0, opts.BatchSize),
+		settled: make(map[string]int64),
+		changed: make(chan struct{}),
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+func (q *Queue) Start() {
+	go q.run()
+}
+
+// イベントをキューに追加する。書き込みは非同期に行われる。
+// Closeの後はイベントをログに残してFailedに数え、ErrClosedを返す
+func (q *Queue) Enqueue(meetingId string, ev event.Event) error {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		q.failed.Add(1)
+		slog.Error("Dropped event enqueued after close", "meeting_id", meetingId, "seq", ev.Seq, "type", ev.Type)
+		return ErrClosed
+	}
+	q.pending = append(q.pending, item{meetingId: meetingId, ev: ev})
+	n := len(q.pending)
+	q.mu.Unlock()
+	if n >= q.opts.BatchSize {
+		select {
+		case q.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// 未書き込みのイベント数(書き込み中を含む)
+func (q *Queue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) + int(q.inflight.Load())
+}
+
+func (q *Queue) Failed() int64 {
+	return q.failed.Load()
+}
+
+// 書き込みが追いついていないかどうか。trueの間はClientからのイベントを受け付けないこと
+func (q *Queue) Overloaded() bool {
+	return q.Depth() >= q.opts.MaxDepth
+}
+
+// 会議のseqまでのイベントの書き込みが終わるまで待つ。リトライしても書き込めずに破棄した場合も終わったとみなす。
+// 会議ごとのイベントは追加された順に書き込むので、seqのイベントが終われば、それより前のイベントも終わっている
+func (q *Queue) Wait(ctx context.Context, meetingId string, seq int64) error {
+	for {
+		q.mu.Lock()
+		done := q.settled[meetingId] >= seq
+		changed := q.changed
+		q.mu.Unlock()
+		if done {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// 書き込みが終わったイベントを記録し、Waitしているgoroutineを起こす。
+// flushは会議ごとに先頭から順に書き込み、失敗したら以降を書き込まずに戻すので、eventsより前のイベントは全て終わっている
+func (q *Queue) settle(meetingId string, events []event.Event) {
+	seq := events[len(events)-1].Seq
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if seq <= q.settled[meetingId] {
+		return
+	}
+	q.settled[meetingId] = seq
+	close(q.changed)
+	q.changed = make(chan struct{})
+}
+
+// 新しい書き込みを止め、残っているイベントを全て書き込んでから終了する
+func (q *Queue) Close(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		close(q.stop)
+	}
+	q.mu.Unlock()
+	select {
+	case <-q.done:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	if depth := q.Depth(); depth > 0 {
+		return errors.New("persistence queue closed with unwritten events")
+	}
+	return nil
+}
+
+func (q *Queue) run() {
+	defer close(q.done)
+	ticker := time.NewTicker(q.opts.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-q.wake:
+		case <-q.stop:
+			// 残りを全て書き込む。一時的なエラーで戻されたものは諦める
+			for q.flush(context.Background()) {
+			}
+			return
+		}
+		q.flush(context.Background())
+	}
+}
+
+// 溜まっているイベントを会議ごと・BatchSizeごとに書き込む。
+// 一時的なエラーでリトライしきれなかった会議は、そのバッチ以降のイベントをキューの先頭に戻し、falseを返す
+func (q *Queue) flush(ctx context.Context) bool {
+	q.mu.Lock()
+	items := q.pending
+	q.pending = make([]item, 0, q.opts.BatchSize)
+	q.inflight.Add(int64(len(items)))
+	q.mu.Unlock()
+	if len(items) == 0 {
+		return false
+	}
+
+	// 会議ごとに、追加された順番を保ったまま分ける
+	order := make([]string, 0)
+	byMeeting := make(map[string][]event.Event)
+	for _, it := range items {
+		if _, ok := byMeeting[it.meetingId]; !ok {
+			order = append(order, it.meetingId)
+		}
+		byMeeting[it.meetingId] = append(byMeeting[it.meetingId], it.ev)
+	}
+
+	retry := make([]item, 0)
+	for _, meetingId := range order {
+		events := byMeeting[meetingId]
+		for start := 0; start < len(events); start += q.opts.BatchSize {
+			end := min(start+q.opts.BatchSize, len(events))
+			batch := events[start:end]
+			err := q.writeWithRetry(ctx, meetingId, batch)
+			if err == nil {
+				q.settle(meetingId, batch)
+				continue
+			}
+			if isTransient(err) {
+				// 順番が入れ替わらないよう、この会議の残りのイベントも書き込まずに戻す
+				slog.Warn("Failed to write events, will retry later", "meeting_id", meetingId, "events", len(events)-start, "error", err)
+				for _, ev := range events[start:] {
+					retry = append(retry, item{meetingId: meetingId, ev: ev})
+				}
+				break
+			} else {
+				slog.Error("Dropped events", "meeting_id", meetingId, "events", len(batch), "error", err)
+				q.failed.Add(int64(len(batch)))
+				q.settle(meetingId, batch)
+			}
+		}
+	}
+
+	q.mu.Lock()
+	q.inflight.Add(-int64(len(items)))
+	if len(retry) > 0 {
+		q.pending = append(retry, q.pending...)
+	}
+	q.mu.Unlock()
+	return len(retry) == 0 && q.Depth() > 0
+}
+
+func (q *Queue) writeWithRetry(ctx context.Context, meetingId string, events []event.Event) error {
+	backoff := q.opts.RetryBackoff
+	var err error
+	for attempt := 0; attempt <= q.opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			// 同時にリトライが集中しないように揺らぎを入れる
+			time.Sleep(backoff/2 + time.Duration(rand.Int63n(int64(backoff))))
+			backoff *= 2
+		}
+		if err = q.write(ctx, meetingId, events); err == nil || !isTransient(err) {
+			return err
+		}
+	}
+	return err
+}
+
+// リトライすれば成功する可能性があるエラーかどうか
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted, codes.Internal:
+		return true
+	}
+	return errors.Is(err, context.DeadlineExceeded)
+}
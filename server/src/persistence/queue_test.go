@@ -0,0 +1,278 @@
+package persistence
+
+import (
+	"context"
+	"errors"
+	"smile-sync/src/event"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestQueueBatchesAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	batches := make(map[string][][]int64)
+	failures := 1
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		// 最初の1回だけ一時的なエラーを返す
+		if failures > 0 {
+			failures--
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		seqs := make([]int64, 0, len(events))
+		for _, ev := range events {
+			seqs = append(seqs, ev.Seq)
+		}
+		batches[meetingId] = append(batches[meetingId], seqs)
+		return nil
+	}
+
+	q := NewQueue(write, Options{
+		BatchSize:     2,
+		FlushInterval: time.Hour, // BatchSizeとCloseでのみ書き込まれるようにする
+		MaxDepth:      3,
+		MaxRetries:    2,
+		RetryBackoff:  time.Millisecond,
+	})
+	for seq := int64(1); seq <= 3; seq++ {
+		q.Enqueue("a", event.Event{Seq: seq})
+	}
+	q.Enqueue("b", event.Event{Seq: 1})
+	if !q.Overloaded() {
+		t.Errorf("Overloaded() = false with depth %d", q.Depth())
+	}
+
+	q.Start()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Close(ctx); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	if q.Depth() != 0 {
+		t.Errorf("Depth() = %d after Close, want 0", q.Depth())
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	got := 0
+	for _, batch := range batches["a"] {
+		if len(batch) > 2 {
+			t.Errorf("batch %v exceeds BatchSize", batch)
+		}
+		got += len(batch)
+	}
+	if got != 3 || len(batches["b"]) != 1 {
+		t.Errorf("written batches = %v, want all events of both meetings", batches)
+	}
+}
+
+// 書き込み中のイベントもDepthに含め、MaxDepthに達している間はOverloadedを返す
+func TestQueueOverloaded(t *testing.T) {
+	release := make(chan struct{})
+	writing := make(chan struct{}, 1)
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		writing <- struct{}{}
+		<-release
+		return nil
+	}
+	q := NewQueue(write, Options{BatchSize: 2, FlushInterval: time.Hour, MaxDepth: 2, MaxRetries: 0, RetryBackoff: time.Millisecond})
+	q.Start()
+	defer q.Close(context.Background())
+
+	q.Enqueue("a", event.Event{Seq: 1})
+	if q.Overloaded() {
+		t.Error("Overloaded() = true below MaxDepth")
+	}
+	q.Enqueue("a", event.Event{Seq: 2})
+	<-writing
+	if !q.Overloaded() || q.Depth() != 2 {
+		t.Errorf("while writing: Overloaded() = %v, Depth() = %d", q.Overloaded(), q.Depth())
+	}
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for q.Overloaded() && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if q.Overloaded() || q.Depth() != 0 {
+		t.Errorf("after writing: Overloaded() = %v, Depth() = %d", q.Overloaded(), q.Depth())
+	}
+}
+
+// Closeは残っているイベントを全て書き込み、その後のEnqueueは破棄する
+func TestQueueCloseDrainsAndRejects(t *testing.T) {
+	var mu sync.Mutex
+	var written []int64
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ev := range events {
+			written = append(written, ev.Seq)
+		}
+		return nil
+	}
+	q := NewQueue(write, Options{BatchSize: 10, FlushInterval: time.Hour, MaxDepth: 100, MaxRetries: 0, RetryBackoff: time.Millisecond})
+	q.Start()
+	for seq := int64(1); seq <= 5; seq++ {
+		if err := q.Enqueue("a", event.Event{Seq: seq}); err != nil {
+			t.Fatalf("Enqueue() = %v", err)
+		}
+	}
+	if err := q.Close(context.Background()); err != nil {
+		t.Fatalf("Close() = %v", err)
+	}
+	mu.Lock()
+	if len(written) != 5 {
+		t.Errorf("written = %v, want 5 events", written)
+	}
+	mu.Unlock()
+
+	if err := q.Enqueue("a", event.Event{Seq: 6}); !errors.Is(err, ErrClosed) {
+		t.Errorf("Enqueue() after Close = %v, want ErrClosed", err)
+	}
+	if q.Failed() != 1 || q.Depth() != 0 {
+		t.Errorf("Failed() = %d, Depth() = %d", q.Failed(), q.Depth())
+	}
+	// 2回目のCloseもエラーにしない
+	if err := q.Close(context.Background()); err != nil {
+		t.Errorf("second Close() = %v", err)
+	}
+}
+
+// 一時的なエラーはMaxRetriesまでリトライし、書き込めなければ残したままCloseがエラーを返す。
+// 一時的でないエラーはリトライせずに破棄する
+func TestQueueExhaustsRetries(t *testing.T) {
+	var mu sync.Mutex
+	attempts := make(map[string]int)
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[meetingId]++
+		if meetingId == "invalid" {
+			return status.Error(codes.InvalidArgument, "invalid")
+		}
+		return status.Error(codes.Unavailable, "unavailable")
+	}
+	q := NewQueue(write, Options{BatchSize: 10, FlushInterval: time.Hour, MaxDepth: 100, MaxRetries: 2, RetryBackoff: time.Millisecond})
+	q.Start()
+	q.Enqueue("unavailable", event.Event{Seq: 1})
+	q.Enqueue("unavailable", event.Event{Seq: 2})
+	q.Enqueue("invalid", event.Event{Seq: 1})
+
+	if err := q.Close(context.Background()); err == nil {
+		t.Error("Close() = nil with unwritten events")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["unavailable"] != 3 || attempts["invalid"] != 1 {
+		t.Errorf("attempts = %v, want 3 for unavailable and 1 for invalid", attempts)
+	}
+	if q.Depth() != 2 || q.Failed() != 1 {
+		t.Errorf("Depth() = %d, Failed() = %d, want 2 and 1", q.Depth(), q.Failed())
+	}
+}
+
+// Waitは他の会議の書き込みを待たず、その会議のseqまで書き込まれたら戻る
+func TestQueueWait(t *testing.T) {
+	release := make(chan struct{})
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		if meetingId == "slow" {
+			<-release
+		}
+		if meetingId == "invalid" {
+			return status.Error(codes.InvalidArgument, "invalid")
+		}
+		return nil
+	}
+	q := NewQueue(write, Options{BatchSize: 1, FlushInterval: time.Millisecond, MaxDepth: 100, MaxRetries: 0, RetryBackoff: time.Millisecond})
+	q.Start()
+	defer q.Close(context.Background())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	q.Enqueue("a", event.Event{Seq: 1})
+	q.Enqueue("a", event.Event{Seq: 2})
+	if err := q.Wait(ctx, "a", 2); err != nil {
+		t.Fatalf("Wait(a, 2) = %v", err)
+	}
+	// 破棄されたイベントも待ち続けない
+	q.Enqueue("invalid", event.Event{Seq: 1})
+	if err := q.Wait(ctx, "invalid", 1); err != nil {
+		t.Fatalf("Wait(invalid, 1) = %v", err)
+	}
+
+	q.Enqueue("slow", event.Event{Seq: 1})
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	if err := q.Wait(short, "slow", 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait(slow, 1) before writing = %v, want DeadlineExceeded", err)
+	}
+	close(release)
+	if err := q.Wait(ctx, "slow", 1); err != nil {
+		t.Errorf("Wait(slow, 1) = %v", err)
+	}
+}
+
+// 一時的なエラーで戻したイベントより後のイベントを先に書き込まず、Waitも戻らない
+func TestQueueKeepsOrderAfterTransientError(t *testing.T) {
+	var mu sync.Mutex
+	var written []int64
+	calls := 0
+	failed := make(chan struct{})
+	release := make(chan struct{})
+	write := func(ctx context.Context, meetingId string, events []event.Event) error {
+		mu.Lock()
+		calls++
+		call := calls
+		mu.Unlock()
+		if call == 1 {
+			close(failed)
+			return status.Error(codes.Unavailable, "unavailable")
+		}
+		// 戻されたイベントの書き直しは、Waitを確かめるまで止めておく
+		if events[0].Seq == 1 {
+			<-release
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		for _, ev := range events {
+			written = append(written, ev.Seq)
+		}
+		return nil
+	}
+	q := NewQueue(write, Options{BatchSize: 2, FlushInterval: 10 * time.Millisecond, MaxDepth: 100, MaxRetries: 0, RetryBackoff: time.Millisecond})
+	for seq := int64(1); seq <= 4; seq++ {
+		q.Enqueue("a", event.Event{Seq: seq})
+	}
+	q.Start()
+	defer q.Close(context.Background())
+
+	<-failed
+	short, cancelShort := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancelShort()
+	if err := q.Wait(short, "a", 4); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait(a, 4) while the first batch is unwritten = %v, want DeadlineExceeded", err)
+	}
+	close(release)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.Wait(ctx, "a", 4); err != nil {
+		t.Fatalf("Wait(a, 4) = %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	want := []int64{1, 2, 3, 4}
+	if len(written) != len(want) {
+		t.Fatalf("written = %v, want %v", written, want)
+	}
+	for i := range want {
+		if written[i] != want[i] {
+			t.Fatalf("written = %v, want %v", written, want)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package report
+
+import (
+	"smile-sync/src/event"
+	"sort"
+	"time"
+)
+
+type ParticipantStats struct {
+	Nickname   string `json:"nickname"`
+	Smiles     int    `json:"smiles"`     // SmilePointを送った回数
+	SmilePoint int    `json:"smilePoint"` // 送ったSmilePointの合計
+	Ideas      int    `json:"ideas"`
+}
+
+type Image struct {
+	Timestamp         time.Time `json:"timestamp"`
+	SinceMeetingStart int64     `json:"sinceMeetingStart"`
+	Level             int       `json:"level"`
+	Prompt            string    `json:"prompt"`
+	ImageUrl          string    `json:"imageUrl"`
+}
+
+// アジェンダのセクションごとの集計
+type SectionStats struct {
+	Index           int    `json:"index"` // 1始まり
+	Title           string `json:"title"`
+	PlannedSeconds  int64  `json:"plannedSeconds"`
+	DurationSeconds int64  `json:"durationSeconds"` // 実際にかかった時間。進まなかったセクションは0
+	Smiles          int    `json:"smiles"`
+	SmilePoint      int    `json:"smilePoint"`
+	Ideas           int    `json:"ideas"`
+	MaxLevel        int    `json:"maxLevel"` // 進まなかったセクションは0
+}
+
+type Report struct {
+	MeetingId          string              `json:"meetingId"`
+	StartedAt          time.Time           `json:"startedAt"`
+	EndedAt            time.Time           `json:"endedAt"`
+	IsMeetingActive    bool                `json:"isMeetingActive"`
+	IsMeetingPaused    bool                `json:"isMeetingPaused"`
+	DurationSeconds    int64               `json:"durationSeconds"`
+	PlannedSeconds     int64               `json:"plannedSeconds,omitempty"` // 予定の会議時間
+	TotalSmiles        int                 `json:"totalSmiles"`
+	TotalSmilePoint    int                 `json:"totalSmilePoint"`
+	TotalIdeas         int                 `json:"totalIdeas"`
+	FinalLevel         int                 `json:"finalLevel"`
+	SmileRatePerMinute float64             `json:"smileRatePerMinute"` // 会議時間1分あたりのSmilePoint
+	SmilePointByMinute []int               `json:"smilePointByMinute"` // 会議開始からの各1分間のSmilePoint
+	Participants       []ParticipantStats  `json:"participants"`
+	LevelUps           []event.LevelChange `json:"levelUps"`
+	Images             []Image             `json:"images"`
+	Sections           []SectionStats      `json:"sections,omitempty"`
+}
+
+// 会議のイベントからレポートを作成する。進行中の会議はnowまでの時間で集計する
+func Build(meetingId string, events []event.Event, now time.Time) Report {
+	events = event.Sorted(events)
+	r := Report{
+		MeetingId:          meetingId,
+		SmilePointByMinute: make([]int, 0),
+		Participants:       make([]ParticipantStats, 0),
+		LevelUps:           event.LevelChanges(events),
+		Images:             make([]Image, 0),
+	}
+
+	st := event.NewState()
+	participants := make(map[string]*ParticipantStats)
+	participant := func(nickname string) *ParticipantStats {
+		p, ok := participants[nickname]
+		if !ok {
+			p = &ParticipantStats{Nickname: nickname}
+			participants[nickname] = p
+		}
+		return p
+	}
+	var duration time.Duration
+	var activeSince time.Time
+	for _, ev := range events {
+		prevSection, prevSectionStart := st.Section, st.SectionStartSeconds
+		st.Apply(ev)
+		if ev.Type == event.AgendaSet {
+			r.Sections = newSectionStats(st.Agenda)
+		}
+		if st.Section != prevSection {
+			// 次のセクションに進んだか、会議が終了した時点で前のセクションを締める
+			if prevSection > 0 && prevSection <= len(r.Sections) {
+				end := st.SectionStartSeconds
+				if st.Section == 0 {
+					end = int64(st.Duration(ev.Timestamp).Seconds())
+				}
+				r.Sections[prevSection-1].DurationSeconds += end - prevSectionStart
+			}
+			if st.Section > 0 {
+				r.Sections[st.Section-1].MaxLevel = max(r.Sections[st.Section-1].MaxLevel, st.Level)
+			}
+		}
+		section := sectionOf(r.Sections, ev)
+		switch ev.Type {
+		case event.MeetingStarted:
+			if r.StartedAt.IsZero() {
+				r.StartedAt = ev.Timestamp
+			}
+			activeSince = ev.Timestamp
+		case event.MeetingEnded:
+			if !activeSince.IsZero() {
+				duration += ev.Timestamp.Sub(activeSince)
+				activeSince = time.Time{}
+			}
+			r.EndedAt = ev.Timestamp
+		case event.MeetingPaused:
+			if !activeSince.IsZero() {
+				duration += ev.Timestamp.Sub(activeSince)
+				activeSince = time.Time{}
+			}
+		case event.SmilePoint:
+			p := participant(ev.Nickname)
+			p.Smiles++
+			p.SmilePoint += ev.Point
+			r.TotalSmiles++
+			minute := int(ev.SinceMeetingStart / 60)
+			for len(r.SmilePointByMinute) <= minute {
+				r.SmilePointByMinute = append(r.SmilePointByMinute, 0)
+			}
+			r.SmilePointByMinute[minute] += ev.Point
+			if section != nil {
+				section.Smiles++
+				section.SmilePoint += ev.Point
+				section.MaxLevel = max(section.MaxLevel, st.Level)
+			}
+		case event.Idea:
+			participant(ev.Nickname).Ideas++
+			if section != nil {
+				section.Ideas++
+			}
+		case event.Image:
+			r.Images = append(r.Images, Image{
+				Timestamp:         ev.Timestamp,
+				SinceMeetingStart: ev.SinceMeetingStart,
+				Level:             st.Level,
+				Prompt:            ev.Prompt,
+				ImageUrl:          ev.ImageUrl,
+			})
+		}
+	}
+	if !activeSince.IsZero() {
+		duration += now.Sub(activeSince)
+		r.IsMeetingActive = true
+		r.EndedAt = time.Time{}
+	}
+
+	if st.IsMeetingPaused {
+		r.IsMeetingPaused = true
+		r.EndedAt = time.Time{}
+	}
+
+	r.DurationSeconds = int64(duration.Seconds())
+	if st.Section > 0 {
+		r.Sections[st.Section-1].DurationSeconds += int64(st.Duration(now).Seconds()) - st.SectionStartSeconds
+	}
+	r.PlannedSeconds = st.PlannedSeconds
+	r.TotalSmilePoint = st.TotalSmilePoint
+	r.TotalIdeas = st.TotalIdeas
+	r.FinalLevel = st.Level
+	if duration > 0 {
+		r.SmileRatePerMinute = float64(st.TotalSmilePoint) / duration.Minutes()
+	}
+	for _, p := range participants {
+		r.Participants = append(r.Participants, *p)
+	}
+	sort.Slice(r.Participants, func(i, j int) bool {
+		return r.Participants[i].Nickname < r.Participants[j].Nickname
+	})
+	return r
+}
+
+func newSectionStats(agenda []event.Section) []SectionStats {
+	sections := make([]SectionStats, 0, len(agenda))
+	for i, sec := range agenda {
+		sections = append(sections, SectionStats{Index: i + 1, Title: sec.Title, PlannedSeconds: sec.Seconds})
+	}
+	return sections
+}
+
+// イベントが発生したセクション。アジェンダがないか、セクションの外のイベントならnil
+func sectionOf(sections []SectionStats, ev event.Event) *SectionStats {
+	if ev.Section < 1 || ev.Section > len(sections) {
+		return nil
+	}
+	return &sections[ev.Section-1]
+}
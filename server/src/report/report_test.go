@@ -0,0 +1,91 @@
+package report
+
+import (
+	"smile-sync/src/event"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int64) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	events := []event.Event{
+		{Seq: 1, Type: event.MeetingStarted, Timestamp: at(0)},
+		{Seq: 2, Type: event.SmilePoint, Timestamp: at(5), SinceMeetingStart: 5, Nickname: "a", Point: 2},
+		{Seq: 3, Type: event.LevelThresholdsSet, Timestamp: at(10), SinceMeetingStart: 10, LevelThresholds: event.NewLevelThresholds(2, event.MaxLevel)},
+		{Seq: 4, Type: event.SmilePoint, Timestamp: at(70), SinceMeetingStart: 70, Nickname: "b", Point: 4},
+		{Seq: 5, Type: event.Image, Timestamp: at(75), SinceMeetingStart: 75, ImageUrl: "https://example.com/1.png"},
+		{Seq: 6, Type: event.Idea, Timestamp: at(80), SinceMeetingStart: 80, Nickname: "a"},
+		{Seq: 7, Type: event.MeetingEnded, Timestamp: at(120), SinceMeetingStart: 120},
+	}
+
+	r := Build("m1", events, at(600))
+	if r.DurationSeconds != 120 || r.IsMeetingActive {
+		t.Errorf("DurationSeconds = %d, IsMeetingActive = %v, want 120, false", r.DurationSeconds, r.IsMeetingActive)
+	}
+	if r.TotalSmiles != 2 || r.TotalSmilePoint != 6 || r.TotalIdeas != 1 {
+		t.Errorf("totals = %d smiles, %dpt, %d ideas", r.TotalSmiles, r.TotalSmilePoint, r.TotalIdeas)
+	}
+	if r.SmileRatePerMinute != 3 {
+		t.Errorf("SmileRatePerMinute = %v, want 3", r.SmileRatePerMinute)
+	}
+	if len(r.SmilePointByMinute) != 2 || r.SmilePointByMinute[0] != 2 || r.SmilePointByMinute[1] != 4 {
+		t.Errorf("SmilePointByMinute = %v, want [2 4]", r.SmilePointByMinute)
+	}
+	if len(r.Participants) != 2 || r.Participants[0].Ideas != 1 || r.Participants[1].SmilePoint != 4 {
+		t.Errorf("Participants = %+v", r.Participants)
+	}
+	if len(r.LevelUps) != 1 || r.LevelUps[0].Level != 3 {
+		t.Errorf("LevelUps = %+v, want a single level up to 3", r.LevelUps)
+	}
+	if len(r.Images) != 1 || r.Images[0].Level != 3 {
+		t.Errorf("Images = %+v, want a single image at level 3", r.Images)
+	}
+}
+
+func TestBuildPausedMeeting(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int64) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	events := []event.Event{
+		{Seq: 1, Type: event.MeetingStarted, Timestamp: at(0)},
+		{Seq: 2, Type: event.MeetingPaused, Timestamp: at(60)},
+		{Seq: 3, Type: event.MeetingStarted, Timestamp: at(300)},
+		{Seq: 4, Type: event.MeetingPaused, Timestamp: at(330)},
+	}
+	// 中断中の時間は会議時間に数えない
+	r := Build("m1", events, at(600))
+	if r.DurationSeconds != 90 || r.IsMeetingActive || !r.IsMeetingPaused || !r.EndedAt.IsZero() {
+		t.Errorf("DurationSeconds = %d, active = %v, paused = %v, EndedAt = %s", r.DurationSeconds, r.IsMeetingActive, r.IsMeetingPaused, r.EndedAt)
+	}
+}
+
+func TestBuildSections(t *testing.T) {
+	start := time.Date(2024, 8, 1, 10, 0, 0, 0, time.UTC)
+	at := func(sec int64) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	agenda := []event.Section{{Title: "warm-up", Seconds: 60}, {Title: "diverge", Seconds: 120}, {Title: "converge", Seconds: 60}}
+	events := []event.Event{
+		{Seq: 1, Type: event.AgendaSet, Agenda: agenda},
+		{Seq: 2, Type: event.MeetingStarted, Timestamp: at(0)},
+		{Seq: 3, Type: event.SmilePoint, Timestamp: at(10), SinceMeetingStart: 10, Section: 1, Nickname: "a", Point: 2},
+		{Seq: 4, Type: event.SectionStarted, Timestamp: at(60), SinceMeetingStart: 60, Section: 2},
+		{Seq: 5, Type: event.Idea, Timestamp: at(70), SinceMeetingStart: 70, Section: 2, Nickname: "b"},
+		{Seq: 6, Type: event.SmilePoint, Timestamp: at(80), SinceMeetingStart: 80, Section: 2, Nickname: "b", Point: 3},
+		{Seq: 7, Type: event.MeetingEnded, Timestamp: at(150), SinceMeetingStart: 150, Section: 2},
+	}
+
+	r := Build("m1", events, at(600))
+	if len(r.Sections) != 3 {
+		t.Fatalf("Sections = %+v", r.Sections)
+	}
+	warmUp, diverge, converge := r.Sections[0], r.Sections[1], r.Sections[2]
+	if warmUp.DurationSeconds != 60 || warmUp.SmilePoint != 2 || warmUp.Ideas != 0 || warmUp.MaxLevel != 1 {
+		t.Errorf("warm-up = %+v", warmUp)
+	}
+	if diverge.DurationSeconds != 90 || diverge.Smiles != 1 || diverge.SmilePoint != 3 || diverge.Ideas != 1 {
+		t.Errorf("diverge = %+v", diverge)
+	}
+	// 進まなかったセクション
+	if converge.DurationSeconds != 0 || converge.MaxLevel != 0 || converge.PlannedSeconds != 60 {
+		t.Errorf("converge = %+v", converge)
+	}
+}
@@ -1,6 +1,9 @@
 package utils
 
-import "time"
+import (
+	"fmt"
+	"time"
+)
 
 func ConvertHHMMSS(t time.Time) string {
 	return t.Format("15:04:05") // このレイアウトって具体的で良いらしい
@@ -9,3 +12,30 @@ func ConvertHHMMSS(t time.Time) string {
 func ConvertYYYYMMDDHHMMSS(t time.Time) string {
 	return t.Format("2006-01-02 15:04:05")
 }
+
+const dateLayout = "2006-01-02"
+
+// "2006-01-02"形式の日付を、その日の0時(ローカル時間)に変換する
+func ParseDate(date string) (time.Time, error) {
+	t, err := time.ParseInLocation(dateLayout, date, time.Local)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date %q: expected %s", date, dateLayout)
+	}
+	return t, nil
+}
+
+// "2006-01-02"形式の開始日と終了日(終了日を含む)を、[from, to)の日時の範囲に変換する
+func ParseDateRange(fromDate, toDate string) (time.Time, time.Time, error) {
+	from, err := ParseDate(fromDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	to, err := ParseDate(toDate)
+	if err != nil {
+		return time.Time{}, time.Time{}, err
+	}
+	if to.Before(from) {
+		return time.Time{}, time.Time{}, fmt.Errorf("to date %s is before from date %s", toDate, fromDate)
+	}
+	return from, to.AddDate(0, 0, 1), nil
+}
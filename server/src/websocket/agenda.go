@@ -0,0 +1,148 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"smile-sync/src/audit"
+	"smile-sync/src/event"
+)
+
+var ErrNoNextSection = errors.New("no next section")
+
+// アジェンダのセクションの上限
+const maxSections = 20
+
+// 進行中のセクション
+type SectionStatus struct {
+	Index            int    `json:"index"` // 1始まり
+	Title            string `json:"title"`
+	Count            int    `json:"count"` // セクションの数
+	PlannedSeconds   int64  `json:"plannedSeconds"`
+	RemainingSeconds int64  `json:"remainingSeconds"` // 予定の時間を超過している場合はマイナス
+}
+
+// 管理者が指定するセクション
+type SectionPlan struct {
+	Title    string
+	Duration time.Duration
+}
+
+func ValidateAgenda(sections []SectionPlan) error {
+	if len(sections) == 0 || len(sections) > maxSections {
+		return fmt.Errorf("agenda must have 1 to %d sections", maxSections)
+	}
+	for i, sec := range sections {
+		title := strings.TrimSpace(sec.Title)
+		if title == "" || len(title) > 64 {
+			return fmt.Errorf("invalid title of section %d: %q", i+1, sec.Title)
+		}
+		if sec.Duration < time.Second || sec.Duration > 24*time.Hour {
+			return fmt.Errorf("invalid duration of section %d: %s", i+1, sec.Duration)
+		}
+	}
+	return nil
+}
+
+// アジェンダを設定する。開始前の会議のみ
+func (s *Server) SetAgenda(ctx context.Context, actor Actor, sections []SectionPlan) error {
+	if err := ValidateAgenda(sections); err != nil {
+		return err
+	}
+	agenda := make([]event.Section, 0, len(sections))
+	for _, sec := range sections {
+		agenda = append(agenda, event.Section{Title: strings.TrimSpace(sec.Title), Seconds: int64(sec.Duration.Seconds())})
+	}
+	s.mu.Lock()
+	if !s.state.FirstStartTime.IsZero() {
+		s.mu.Unlock()
+		return ErrMeetingStarted
+	}
+	before := append([]event.Section(nil), s.state.Agenda...)
+	ev := s.applyEvent(event.Event{
+		Type:     event.AgendaSet,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+		Agenda:   agenda,
+	})
+	msg := s.agendaMessage(time.Now())
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Agenda set", "sections", len(agenda), "by", actor.Nickname)
+	s.record(ctx, actor, audit.Entry{
+		Action: audit.MeetingAgenda,
+		Before: map[string]interface{}{"agenda": before},
+		After:  map[string]interface{}{"agenda": agenda},
+	})
+
+	s.saveEvent(ev)
+	send(s, s.broadcast, msg)
+	return nil
+}
+
+// 予定の時間を待たずに次のセクションに進む
+func (s *Server) NextSection(ctx context.Context, actor Actor) error {
+	s.mu.Lock()
+	if !s.state.IsMeetingActive && !s.state.IsMeetingPaused {
+		s.mu.Unlock()
+		return ErrMeetingNotStarted
+	}
+	if s.state.Section == 0 || s.state.Section >= len(s.state.Agenda) {
+		s.mu.Unlock()
+		return ErrNoNextSection
+	}
+	before := s.state.Section
+	now := time.Now()
+	ev := s.applyEvent(event.Event{
+		Type:      event.SectionStarted,
+		Timestamp: now,
+		ClientId:  actor.ClientId,
+		Nickname:  actor.Nickname,
+		Section:   s.state.Section + 1,
+	})
+	section := s.sectionStatus(now)
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Section started", "section", section.Index, "title", section.Title, "by", actor.Nickname)
+	s.record(ctx, actor, audit.Entry{
+		Action: audit.MeetingSection,
+		Before: map[string]interface{}{"section": before},
+		After:  map[string]interface{}{"section": section.Index},
+	})
+
+	s.saveEvent(ev)
+	send(s, s.broadcast, Message{Type: "section", Timestamp: now, Section: section})
+	return nil
+}
+
+// 進行中のセクション。なければnil。呼び出し側でs.muをロックしておくこと
+func (s *Server) sectionStatus(now time.Time) *SectionStatus {
+	remaining, ok := s.state.SectionRemaining(now)
+	if !ok {
+		return nil
+	}
+	sec := s.state.Agenda[s.state.Section-1]
+	// 経過時間と足して予定の時間になるよう切り上げる
+	remainingSeconds := int64(remaining / time.Second)
+	if remaining%time.Second > 0 {
+		remainingSeconds++
+	}
+	return &SectionStatus{
+		Index:            s.state.Section,
+		Title:            sec.Title,
+		Count:            len(s.state.Agenda),
+		PlannedSeconds:   sec.Seconds,
+		RemainingSeconds: remainingSeconds,
+	}
+}
+
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) agendaMessage(now time.Time) Message {
+	return Message{
+		Type:    "agenda",
+		Agenda:  append([]event.Section(nil), s.state.Agenda...),
+		Section: s.sectionStatus(now),
+	}
+}
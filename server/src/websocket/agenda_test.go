@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"smile-sync/src/auth"
+	"testing"
+	"time"
+)
+
+// 予定の時間が過ぎると次のセクションに進み、NextSectionでも待たずに進められる
+func TestNextSection(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	meetingId := th.CreateMeeting(context.Background(), admin)
+	room, _ := th.Meeting(meetingId)
+	err := room.SetAgenda(context.Background(), admin, []SectionPlan{
+		{Title: "warm-up", Duration: time.Second},
+		{Title: "diverge", Duration: time.Hour},
+		{Title: "converge", Duration: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := room.NextSection(context.Background(), admin); !errors.Is(err, ErrMeetingNotStarted) {
+		t.Errorf("NextSection() before start = %v, want ErrMeetingNotStarted", err)
+	}
+	conn := th.dial(t, th.session(t, "alice", auth.RoleMember, meetingId), "", "alice")
+	if msg := expect(t, conn, "agenda"); len(msg.Agenda) != 3 || msg.Section != nil {
+		t.Errorf("agenda before start = %+v", msg)
+	}
+	if err := room.Start(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+
+	if msg := expect(t, conn, "section"); msg.Section.Index != 2 || msg.Section.Title != "diverge" {
+		t.Errorf("section after warm-up = %+v", msg.Section)
+	}
+	if err := room.NextSection(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	if msg := expect(t, conn, "section"); msg.Section.Index != 3 || msg.Section.Count != 3 || msg.Section.RemainingSeconds != 3600 {
+		t.Errorf("section after NextSection = %+v", msg.Section)
+	}
+	if err := room.NextSection(context.Background(), admin); !errors.Is(err, ErrNoNextSection) {
+		t.Errorf("NextSection() on last section = %v, want ErrNoNextSection", err)
+	}
+	if msg := expect(t, conn, "timer"); msg.Section == nil || msg.Section.Index != 3 {
+		t.Errorf("timer section = %+v", msg.Section)
+	}
+}
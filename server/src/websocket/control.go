@@ -0,0 +1,364 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/event"
+)
+
+var (
+	ErrMeetingActive       = errors.New("meeting is already active")
+	ErrMeetingNotActive    = errors.New("meeting is not active")
+	ErrMeetingNotStarted   = errors.New("meeting has not been started")
+	ErrParticipantNotFound = errors.New("participant not found")
+	ErrUnknownAction       = errors.New("unknown action")
+	ErrMeetingStarted      = errors.New("meeting has already been started")
+)
+
+// 会議を操作した人。websocketのClientか、REST APIを呼び出した管理者
+type Actor struct {
+	ClientId string
+	Nickname string
+	Via      string // audit.ViaWebsocket, audit.ViaAPI
+}
+
+// 会議の予定
+type Schedule struct {
+	StartAt  time.Time       // 開始予定日時。ゼロ値なら手動で開始する
+	Duration time.Duration   // 予定の会議時間(中断中を除く)。0なら自動で終了しない
+	Warnings []time.Duration // 終了の何分前に予告するか。nilなら既定値
+}
+
+func (sc Schedule) Validate(now time.Time) error {
+	if !sc.StartAt.IsZero() && !sc.StartAt.After(now) {
+		return fmt.Errorf("startAt %s is in the past", sc.StartAt.Format(time.RFC3339))
+	}
+	if sc.Duration < 0 || sc.Duration > 24*time.Hour {
+		return fmt.Errorf("invalid duration %s", sc.Duration)
+	}
+	if len(sc.Warnings) > 10 {
+		return errors.New("too many warnings")
+	}
+	for _, w := range sc.Warnings {
+		if w <= 0 || (sc.Duration > 0 && w >= sc.Duration) {
+			return fmt.Errorf("invalid warning %s", w)
+		}
+	}
+	return nil
+}
+
+// 自動で開始・終了するときの操作した人。参加者に数えないようNicknameは空にする
+var schedulerActor = Actor{Via: audit.ViaScheduler}
+
+// 接続中の参加者
+type Participant struct {
+	Id        string `json:"id"`
+	ClientId  string `json:"clientId,omitempty"`
+	Nickname  string `json:"nickname"`
+	LatencyMs int64  `json:"latencyMs"`
+	Muted     bool   `json:"muted"`
+	Excluded  bool   `json:"excluded"`
+}
+
+func (s *Server) MeetingId() string {
+	return s.meetingId
+}
+
+// 会議を開始する。中断中の会議は再開する
+func (s *Server) Start(ctx context.Context, actor Actor) error {
+	s.mu.Lock()
+	if s.state.IsMeetingActive {
+		s.mu.Unlock()
+		return ErrMeetingActive
+	}
+	before := meetingStatus(s.state)
+	ev := s.applyEvent(event.Event{
+		Type:     event.MeetingStarted,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+	})
+	// 予定より前に手動で開始した場合は、開始予定のタイマーを止める
+	s.armSchedule()
+	s.startTimer()
+	s.startSnapshotter()
+	go s.startMeetingSummary(s.snapshot())
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Meeting started", "by", actor.Nickname)
+	s.recordStatus(ctx, actor, audit.MeetingStart, before, meetingStatusActive)
+
+	s.saveEvent(ev)
+	s.broadcastMeetingStatus()
+	return nil
+}
+
+// 会議を中断する。再開するまでの時間は会議時間に数えず、イベントも受け付けない
+func (s *Server) Pause(ctx context.Context, actor Actor) error {
+	s.mu.Lock()
+	if !s.state.IsMeetingActive {
+		s.mu.Unlock()
+		return ErrMeetingNotActive
+	}
+	before := meetingStatus(s.state)
+	ev := s.applyEvent(event.Event{
+		Type:     event.MeetingPaused,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+	})
+	go s.keepMeetingSnapshot(s.snapshot())
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Meeting paused", "by", actor.Nickname)
+	s.recordStatus(ctx, actor, audit.MeetingPause, before, meetingStatusPaused)
+
+	s.saveEvent(ev)
+	s.broadcastMeetingStatus()
+	return nil
+}
+
+// 会議を終了する
+func (s *Server) Stop(ctx context.Context, actor Actor) error {
+	s.mu.Lock()
+	if !s.state.IsMeetingActive && !s.state.IsMeetingPaused {
+		s.mu.Unlock()
+		return ErrMeetingNotStarted
+	}
+	before := meetingStatus(s.state)
+	ev := s.applyEvent(event.Event{
+		Type:     event.MeetingEnded,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+	})
+	go s.finishMeetingSnapshot(s.snapshot())
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Meeting ended", "by", actor.Nickname)
+	s.recordStatus(ctx, actor, audit.MeetingStop, before, meetingStatusEnded)
+
+	s.saveEvent(ev)
+	s.broadcastMeetingStatus()
+	// APIや予定で終了し、接続がなければルームを削除する
+	s.releaseIfIdle()
+	return nil
+}
+
+// 会議の設定を変更する。ゼロ値の項目は変更しない。
+// 画像の動物は会議中以外いつでも変更でき、その他の項目は開始前のみ変更できる
+func (s *Server) UpdateSettings(ctx context.Context, actor Actor, settings event.MeetingSettings) error {
+	s.mu.Lock()
+	before := s.state.Settings
+	settings = settings.Or(before)
+	settings.ImageAnimalType = strings.TrimSpace(settings.ImageAnimalType)
+	if err := settings.Validate(); err != nil {
+		s.mu.Unlock()
+		return err
+	}
+	if s.state.IsMeetingActive {
+		s.mu.Unlock()
+		return ErrMeetingActive
+	}
+	animalOnly := before
+	animalOnly.ImageAnimalType = settings.ImageAnimalType
+	if !s.state.FirstStartTime.IsZero() && settings != animalOnly {
+		s.mu.Unlock()
+		return ErrMeetingStarted
+	}
+	ev := s.applyEvent(event.Event{
+		Type:     event.SettingsUpdated,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+		Settings: &settings,
+	})
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Meeting settings updated", "settings", settings, "by", actor.Nickname)
+	s.record(ctx, actor, audit.Entry{
+		Action: audit.MeetingSettings,
+		Before: map[string]interface{}{"settings": before},
+		After:  map[string]interface{}{"settings": settings},
+	})
+
+	s.saveEvent(ev)
+	// 全てのClientに新しい設定と、以前のClient向けにImageAnimalTypeを送信
+	send(s, s.broadcast, Message{Type: "settings", Settings: &settings})
+	send(s, s.imageAnimalTypeBroadcast, settings.ImageAnimalType)
+	return nil
+}
+
+// 現在の会議の設定
+func (s *Server) Settings() event.MeetingSettings {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.state.Settings
+}
+
+// 会議の予定を設定する。開始予定日時は開始前の会議のみ設定でき、予定の会議時間は会議中にも変更できる
+func (s *Server) Schedule(ctx context.Context, actor Actor, sc Schedule) error {
+	if err := sc.Validate(time.Now()); err != nil {
+		return err
+	}
+	warnings := sc.Warnings
+	if warnings == nil {
+		warnings = s.endWarnings
+	}
+	warningSeconds := make([]int64, 0, len(warnings))
+	for _, w := range warnings {
+		// 予定の会議時間より長い既定値は使わない
+		if sc.Duration == 0 || w < sc.Duration {
+			warningSeconds = append(warningSeconds, int64(w.Seconds()))
+		}
+	}
+	s.mu.Lock()
+	if !sc.StartAt.IsZero() && !s.state.FirstStartTime.IsZero() {
+		s.mu.Unlock()
+		return ErrMeetingStarted
+	}
+	before := scheduleValues(s.state)
+	wasScheduled := s.state.IsScheduled
+	ev := s.applyEvent(event.Event{
+		Type:             event.MeetingScheduled,
+		ClientId:         actor.ClientId,
+		Nickname:         actor.Nickname,
+		ScheduledStartAt: sc.StartAt,
+		PlannedSeconds:   int64(sc.Duration.Seconds()),
+		WarningSeconds:   warningSeconds,
+	})
+	s.armSchedule()
+	// 開始予定の会議は再起動後も自動で開始できるよう、スナップショットを残す
+	if s.state.IsScheduled {
+		go s.keepMeetingSnapshot(s.snapshot())
+	} else if wasScheduled {
+		go s.finishMeetingSnapshot(s.snapshot())
+	}
+	after := scheduleValues(s.state)
+	s.mu.Unlock()
+	slog.InfoContext(ctx, "Meeting scheduled", "start_at", sc.StartAt, "planned_seconds", ev.PlannedSeconds, "by", actor.Nickname)
+	s.record(ctx, actor, audit.Entry{Action: audit.MeetingSchedule, Before: before, After: after})
+
+	s.saveEvent(ev)
+	s.broadcastMeetingStatus()
+	return nil
+}
+
+// 監査ログに記録する予定
+func scheduleValues(st event.State) map[string]interface{} {
+	return map[string]interface{}{
+		"scheduledStartAt": st.ScheduledStartAt,
+		"plannedSeconds":   st.PlannedSeconds,
+		"warningSeconds":   append([]int64(nil), st.WarningSeconds...),
+	}
+}
+
+// 開始予定日時に会議を開始する。予定が変わった場合は前のタイマーを止める。呼び出し側でs.muをロックしておくこと
+func (s *Server) armSchedule() {
+	if s.scheduleTimer != nil {
+		s.scheduleTimer.Stop()
+		s.scheduleTimer = nil
+	}
+	if !s.state.IsScheduled || s.shuttingDown {
+		return
+	}
+	startAt := s.state.ScheduledStartAt
+	s.scheduleTimer = time.AfterFunc(time.Until(startAt), func() {
+		s.mu.Lock()
+		due := s.state.IsScheduled && s.state.ScheduledStartAt.Equal(startAt) && !s.shuttingDown
+		s.mu.Unlock()
+		if !due {
+			return
+		}
+		if err := s.Start(s.meetingContext(), schedulerActor); err != nil {
+			slog.WarnContext(s.meetingContext(), "Failed to start scheduled meeting", "error", err)
+		}
+	})
+}
+
+func (s *Server) Participants() []Participant {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	participants := make([]Participant, 0, len(s.clients))
+	for _, c := range s.clients {
+		participants = append(participants, Participant{
+			Id:        c.id,
+			ClientId:  c.clientId,
+			Nickname:  c.nickname,
+			LatencyMs: c.latency.Milliseconds(),
+			Muted:     s.state.IsMuted(c.nickname, c.clientId),
+			Excluded:  s.state.IsExcluded(c.nickname, c.clientId),
+		})
+	}
+	return participants
+}
+
+// 現在の会議の状態と集計値
+func (s *Server) Status() LiveMetrics {
+	return s.liveMetrics(time.Now())
+}
+
+func (s *Server) broadcastMeetingStatus() {
+	s.mu.Lock()
+	msg := s.meetingStatusMessage()
+	s.mu.Unlock()
+	send(s, s.broadcast, msg)
+}
+
+// 会議の状態と予定。呼び出し側でs.muをロックしておくこと
+func (s *Server) meetingStatusMessage() Message {
+	msg := Message{
+		Type:            "meetingStatus",
+		IsMeetingActive: s.state.IsMeetingActive,
+		IsMeetingPaused: s.state.IsMeetingPaused,
+		PlannedSeconds:  s.state.PlannedSeconds,
+	}
+	if s.state.IsScheduled {
+		startAt := s.state.ScheduledStartAt
+		msg.ScheduledStartAt = &startAt
+	}
+	return msg
+}
+
+// 監査ログに記録する会議の状態
+const (
+	meetingStatusNotStarted = "notStarted"
+	meetingStatusActive     = "active"
+	meetingStatusPaused     = "paused"
+	meetingStatusEnded      = "ended"
+)
+
+func meetingStatus(st event.State) string {
+	switch {
+	case st.IsMeetingActive:
+		return meetingStatusActive
+	case st.IsMeetingPaused:
+		return meetingStatusPaused
+	case st.FirstStartTime.IsZero():
+		return meetingStatusNotStarted
+	}
+	return meetingStatusEnded
+}
+
+// 操作を監査ログに記録する。保存はaudit.Logがバックグラウンドで行う
+func (s *Server) record(ctx context.Context, actor Actor, entry audit.Entry) {
+	// Clientが送ったNicknameではなく、確認済みのセッションのNicknameを記録する
+	if claims, ok := auth.ClaimsFrom(ctx); ok {
+		entry.Actor = claims.Nickname
+	} else {
+		entry.Actor = actor.Nickname
+	}
+	if entry.Actor == "" {
+		entry.Actor = actor.Via
+	}
+	entry.Via = actor.Via
+	entry.MeetingId = s.meetingId
+	s.auditLog.Record(ctx, entry)
+}
+
+func (s *Server) recordStatus(ctx context.Context, actor Actor, action, before, after string) {
+	s.record(ctx, actor, audit.Entry{
+		Action: action,
+		Before: map[string]interface{}{"status": before},
+		After:  map[string]interface{}{"status": after},
+	})
+}
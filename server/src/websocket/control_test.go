@@ -0,0 +1,42 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"smile-sync/src/auth"
+	"testing"
+	"time"
+)
+
+// 予定の会議時間に達する前に予告し、達したら自動で終了してレポートを送る
+func TestAutoStopSendsEndWarningAndReport(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	meetingId := th.CreateMeeting(context.Background(), admin)
+	room, _ := th.Meeting(meetingId)
+	if err := room.Schedule(context.Background(), admin, Schedule{Duration: 2 * time.Second, Warnings: []time.Duration{time.Second}}); err != nil {
+		t.Fatal(err)
+	}
+	conn := th.dial(t, th.session(t, "alice", auth.RoleMember, meetingId), "", "alice")
+	if msg := expect(t, conn, "meetingStatus"); msg.PlannedSeconds != 2 {
+		t.Errorf("PlannedSeconds = %d, want 2", msg.PlannedSeconds)
+	}
+	expect(t, conn, "imageAnimalType")
+	if err := room.Start(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(Message{Type: "smilePoint", Point: 4})
+
+	if msg := expect(t, conn, "endWarning"); msg.RemainingSeconds != 1 {
+		t.Errorf("endWarning = %+v", msg)
+	}
+	if msg := expect(t, conn, "meetingStatus"); msg.IsMeetingActive {
+		t.Error("meeting was not stopped automatically")
+	}
+	msg := expect(t, conn, "report")
+	if msg.Report == nil || msg.Report.MeetingId != meetingId || msg.Report.TotalSmilePoint != 4 || msg.Report.PlannedSeconds != 2 {
+		t.Errorf("report = %+v", msg.Report)
+	}
+	if err := room.Stop(context.Background(), admin); !errors.Is(err, ErrMeetingNotStarted) {
+		t.Errorf("Stop() after auto stop = %v, want ErrMeetingNotStarted", err)
+	}
+}
@@ -0,0 +1,22 @@
+package websocket
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// 待ち受け中もlatencyの送信でpingIntervalごとにループが回るので、その数回分進まなければ止まっているとみなす
+const broadcastLoopStallFactor = 3
+
+// handleMessagesのループが進んでいるかどうか。止まっているとbroadcastへの送信で各Clientの処理も止まる
+func (s *Server) checkBroadcastLoop() error {
+	last := s.lastLoopAt.Load()
+	if last == 0 {
+		return errors.New("broadcast loop has not started")
+	}
+	if since := time.Since(time.Unix(0, last)); since > broadcastLoopStallFactor*s.pingInterval {
+		return fmt.Errorf("broadcast loop has not made progress for %s", since.Round(time.Second))
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package websocket
+
+import (
+	"context"
+	"testing"
+
+	"smile-sync/src/config"
+	"smile-sync/src/health"
+)
+
+// 画像生成APIを設定していなくても準備完了とし、チェックはスキップとして返す
+func TestReadyWithoutImageProvider(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	checker := health.NewChecker()
+	checker.Register("imageProvider", th.CheckImageProvider)
+
+	report := checker.Run(context.Background())
+	if !report.OK() {
+		t.Fatalf("report = %+v, want ok without an image provider", report)
+	}
+	if got := report.Checks["imageProvider"]; got.Status != health.StatusSkipped {
+		t.Errorf("imageProvider = %+v, want %s", got, health.StatusSkipped)
+	}
+
+	th.imageProvider = config.ImageProvider{Endpoint: "https://example.com", ApiKey: "key"}
+	if got := checker.Run(context.Background()).Checks["imageProvider"]; got.Status != health.StatusOK {
+		t.Errorf("imageProvider with settings = %+v, want %s", got, health.StatusOK)
+	}
+}
@@ -0,0 +1,266 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"smile-sync/src/health"
+	"smile-sync/src/origin"
+	"smile-sync/src/persistence"
+	"smile-sync/src/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// 会議ごとのServer(ルーム)を管理し、接続を振り分ける。
+// 参加コードのセッションがないClient(ログインのセッション)は既定の会議に参加する
+type Hub struct {
+	queue         *persistence.Queue
+	ws            config.Websocket
+	imageProvider config.ImageProvider
+	authn         *auth.Authenticator
+	auditLog      *audit.Log
+	upgrader      websocket.Upgrader
+	rooms         map[string]*Server // 会議のDocIdごとのルーム。終了して接続がなくなった会議は削除する
+	defaultRoom   *Server
+	mu            sync.Mutex
+}
+
+func NewHub(queue *persistence.Queue, ws config.Websocket, imageProvider config.ImageProvider, origins *origin.Allowlist, authn *auth.Authenticator, auditLog *audit.Log) *Hub {
+	return &Hub{
+		queue:         queue,
+		ws:            ws,
+		imageProvider: imageProvider,
+		authn:         authn,
+		auditLog:      auditLog,
+		upgrader: websocket.Upgrader{
+			ReadBufferSize:  1024,
+			WriteBufferSize: 1024,
+			// 他のサイトから利用者のブラウザ経由で接続されないよう、許可したOriginのみ受け付ける。
+			// Originヘッダを送らないブラウザ以外のClientはそのまま受け付ける
+			CheckOrigin: func(r *http.Request) bool {
+				requestOrigin := r.Header.Get("Origin")
+				if requestOrigin == "" || origins.Allowed(requestOrigin) {
+					return true
+				}
+				slog.WarnContext(r.Context(), "Rejected websocket connection from disallowed origin", "origin", requestOrigin)
+				return false
+			},
+		},
+		rooms: make(map[string]*Server),
+	}
+}
+
+// 開始日時から会議のDocIdを作る。同じ秒に作成された会議があれば1秒ずらす。呼び出し側でh.muをロックしておくこと
+func (h *Hub) newMeetingId(now time.Time) string {
+	for {
+		id := utils.ConvertYYYYMMDDHHMMSS(now)
+		if _, ok := h.rooms[id]; !ok {
+			return id
+		}
+		now = now.Add(time.Second)
+	}
+}
+
+// ルームを登録してメッセージの配信を開始する。呼び出し側でh.muをロックしておくこと
+func (h *Hub) addRoom(room *Server) {
+	if room.isDefault && h.defaultRoom == nil {
+		h.defaultRoom = room
+	} else {
+		room.isDefault = false
+	}
+	room.auditLog = h.auditLog
+	room.onIdle = h.releaseRoom
+	h.rooms[room.meetingId] = room
+	go room.handleMessages()
+}
+
+// 前回のシャットダウン時に進行中だった会議を全て復元する。既定の会議が復元されなければ新しく作る
+func (h *Hub) Restore(ctx context.Context) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	snapshots, err := loadActiveSnapshots(ctx)
+	errs := []error{err}
+	for _, snapshot := range snapshots {
+		room := NewServer(snapshot.MeetingId, h.queue, h.ws, h.imageProvider)
+		room.isDefault = snapshot.IsDefault
+		if err := room.restore(ctx, snapshot); err != nil {
+			errs = append(errs, fmt.Errorf("meeting %s: %w", snapshot.MeetingId, err))
+			continue
+		}
+		h.addRoom(room)
+	}
+	if h.defaultRoom == nil {
+		room := NewServer(h.newMeetingId(time.Now()), h.queue, h.ws, h.imageProvider)
+		room.isDefault = true
+		h.addRoom(room)
+	}
+	return errors.Join(errs...)
+}
+
+// 参加コードで参加する会議を作成し、DocIdを返す
+func (h *Hub) CreateMeeting(ctx context.Context, actor Actor) string {
+	h.mu.Lock()
+	room := NewServer(h.newMeetingId(time.Now()), h.queue, h.ws, h.imageProvider)
+	h.addRoom(room)
+	h.mu.Unlock()
+	slog.InfoContext(room.meetingContext(), "Meeting created", "by", actor.Nickname)
+	room.record(ctx, actor, audit.Entry{
+		Action: audit.MeetingCreate,
+		After:  map[string]interface{}{"status": meetingStatusNotStarted},
+	})
+	return room.meetingId
+}
+
+// 参加コードで作成した会議のルームがなければ、保存済みのイベントから状態を復元して作る。
+// 開始前の会議はスナップショットがないので、再起動後に参加コードで接続された場合に使う
+func (h *Hub) EnsureMeeting(ctx context.Context, meetingId string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.rooms[meetingId]; ok {
+		return nil
+	}
+	room := NewServer(meetingId, h.queue, h.ws, h.imageProvider)
+	if err := room.restore(ctx, firebase.MeetingSnapshot{MeetingId: meetingId, State: event.NewState()}); err != nil {
+		return err
+	}
+	h.addRoom(room)
+	return nil
+}
+
+func (h *Hub) room(meetingId string) (*Server, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	room, ok := h.rooms[meetingId]
+	return room, ok
+}
+
+// このサーバで接続を受け付けている会議
+func (h *Hub) Meeting(meetingId string) (*Server, bool) {
+	return h.room(meetingId)
+}
+
+func (h *Hub) roomList() []*Server {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	rooms := make([]*Server, 0, len(h.rooms))
+	for _, room := range h.rooms {
+		rooms = append(rooms, room)
+	}
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].meetingId < rooms[j].meetingId })
+	return rooms
+}
+
+// GET /ws?token=...&meeting=...
+// セッションのない接続は受け付けない。参加コードで発行したセッションはその会議に接続する。
+// 管理者はmeetingで接続先の会議を指定できる。それ以外のセッションは既定の会議に接続する
+func (h *Hub) HandleClients(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	query := r.URL.Query()
+	meetingId := query.Get("meeting")
+	token := query.Get("token")
+	if token == "" {
+		http.Error(w, "A session is required", http.StatusUnauthorized)
+		return
+	}
+	claims, err := h.authn.VerifySession(token)
+	if err != nil {
+		slog.InfoContext(ctx, "Rejected websocket connection with invalid session", "error", err)
+		http.Error(w, "Invalid session", http.StatusUnauthorized)
+		return
+	}
+	if claims.MeetingId != "" {
+		if meetingId != "" && meetingId != claims.MeetingId {
+			http.Error(w, "Session is not valid for this meeting", http.StatusForbidden)
+			return
+		}
+		meetingId = claims.MeetingId
+		if err := h.EnsureMeeting(ctx, meetingId); err != nil {
+			slog.ErrorContext(ctx, "Failed to load meeting", "meeting_id", meetingId, "error", err)
+			http.Error(w, "Failed to load meeting", http.StatusInternalServerError)
+			return
+		}
+	} else if meetingId != "" && !claims.IsAdmin() {
+		http.Error(w, "Only admins can choose a meeting", http.StatusForbidden)
+		return
+	}
+	ctx = auth.WithClaims(ctx, claims)
+
+	h.mu.Lock()
+	room := h.defaultRoom
+	if meetingId != "" {
+		room = h.rooms[meetingId]
+	}
+	h.mu.Unlock()
+	if room == nil {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.WarnContext(ctx, "Failed to upgrade connection", "error", err)
+		return
+	}
+	room.handleClient(ctx, conn)
+}
+
+// GET /meetings/{id}/events
+// 管理者のみ。セッションの確認はmiddleware.RequireAdminStreamで行う
+func (h *Hub) HandleEventStream(w http.ResponseWriter, r *http.Request) {
+	room, ok := h.room(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Meeting not found", http.StatusNotFound)
+		return
+	}
+	room.handleEventStream(w, r)
+}
+
+// 全ての会議のClientを切断し、進行中の会議の状態を保存する
+func (h *Hub) Shutdown(ctx context.Context) error {
+	var errs []error
+	for _, room := range h.roomList() {
+		if err := room.shutdown(ctx); err != nil {
+			errs = append(errs, fmt.Errorf("meeting %s: %w", room.meetingId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// SSEの配信を全て終了する。http.Server.Shutdownは配信中のリクエストの終了を待つので、その前に呼ぶ
+func (h *Hub) CloseStreams() {
+	for _, room := range h.roomList() {
+		room.closeStreams()
+	}
+}
+
+// 全ての会議でhandleMessagesのループが進んでいるかどうか
+func (h *Hub) CheckBroadcastLoop(ctx context.Context) error {
+	var errs []error
+	for _, room := range h.roomList() {
+		if err := room.checkBroadcastLoop(); err != nil {
+			errs = append(errs, fmt.Errorf("meeting %s: %w", room.meetingId, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// 画像生成APIの設定があるかどうか。リクエストすると課金されるので、疎通までは確認しない。
+// 設定がない場合は画像を生成しないだけなので、スキップとして返す
+func (h *Hub) CheckImageProvider(ctx context.Context) error {
+	if err := h.imageProvider.Check(); err != nil {
+		return fmt.Errorf("%w: %v", health.ErrSkipped, err)
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"smile-sync/src/audit"
+	"smile-sync/src/event"
+	"smile-sync/src/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+// 管理者が参加者に行える操作
+const (
+	ActionKick    = "kick"    // 切断する。再接続はできる
+	ActionMute    = "mute"    // メッセージを送れなくする
+	ActionUnmute  = "unmute"  //
+	ActionExclude = "exclude" // SmilePointを集計に含めない
+	ActionInclude = "include" //
+	ActionBan     = "ban"     // 切断し、会議が終了するまで接続できなくする
+	ActionUnban   = "unban"   //
+)
+
+var moderationEvents = map[string]event.Type{
+	ActionKick:    event.ParticipantKicked,
+	ActionMute:    event.ParticipantMuted,
+	ActionUnmute:  event.ParticipantUnmuted,
+	ActionExclude: event.ParticipantExcluded,
+	ActionInclude: event.ParticipantIncluded,
+	ActionBan:     event.ParticipantBanned,
+	ActionUnban:   event.ParticipantUnbanned,
+}
+
+// 参加者に操作を行い、対象のClientに通知する。
+// participantIdは接続のID、Nickname、ClientIdのいずれか。接続のIDはその接続のNicknameに読み替える
+func (s *Server) Moderate(ctx context.Context, actor Actor, action, participantId, reason string) error {
+	evType, ok := moderationEvents[action]
+	if !ok {
+		return ErrUnknownAction
+	}
+	s.mu.Lock()
+	target := participantId
+	for _, c := range s.clients {
+		if c.id == participantId {
+			target = c.nickname
+			break
+		}
+	}
+	targets := s.connsFor(target)
+	// 切断は接続中の参加者にしかできない。それ以外は接続前や切断後の参加者にも設定できる
+	if action == ActionKick && len(targets) == 0 {
+		s.mu.Unlock()
+		return ErrParticipantNotFound
+	}
+
+	before := s.restrictionsOf(target)
+	ev := s.applyEvent(event.Event{
+		Type:     evType,
+		ClientId: actor.ClientId,
+		Nickname: actor.Nickname,
+		Target:   target,
+		Reason:   reason,
+	})
+	// s.muをロックしたまま書き込みキューに追加する。Enqueueはブロックしない
+	s.saveEvent(ev)
+	slog.InfoContext(ctx, "Participant moderated", "action", action, "target", target, "reason", reason, "by", actor.Nickname, "connections", len(targets))
+
+	for _, conn := range targets {
+		s.notifyModeration(conn, action, reason)
+		if action == ActionKick || action == ActionBan {
+			s.disconnect(conn, action)
+		}
+	}
+	after := s.restrictionsOf(target)
+	after["connections"] = len(targets)
+	s.mu.Unlock()
+
+	s.record(ctx, actor, audit.Entry{
+		Action: audit.ParticipantPrefix + action,
+		Target: target,
+		Before: before,
+		After:  after,
+	})
+	return nil
+}
+
+// 監査ログに記録する参加者への制限。呼び出し側でs.muをロックしておくこと
+func (s *Server) restrictionsOf(target string) map[string]interface{} {
+	return map[string]interface{}{
+		"muted":    s.state.IsMuted(target, target),
+		"excluded": s.state.IsExcluded(target, target),
+		"banned":   s.state.IsBanned(target, target),
+	}
+}
+
+// Nickname、ClientIdが一致する接続。呼び出し側でs.muをロックしておくこと
+func (s *Server) connsFor(target string) []*websocket.Conn {
+	var conns []*websocket.Conn
+	for conn, c := range s.clients {
+		if c.nickname == target || (c.clientId != "" && c.clientId == target) {
+			conns = append(conns, conn)
+		}
+	}
+	return conns
+}
+
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) notifyModeration(conn *websocket.Conn, action, reason string) {
+	s.sendMessage(conn, Message{
+		Type:      "moderation",
+		Timestamp: time.Now(),
+		Action:    action,
+		Text:      reason,
+	})
+}
+
+// 理由を付けて接続を閉じる。ReadMessageがエラーになり、handleClientの後処理で参加者の一覧を更新する。
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) disconnect(conn *websocket.Conn, reason string) {
+	s.closeConn(conn, websocket.ClosePolicyViolation, reason)
+}
+
+// 接続中の参加者への制限
+func (s *Server) restrictions(conn *websocket.Conn) (muted, excluded bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c, ok := s.clients[conn]
+	if !ok {
+		return false, false
+	}
+	return s.state.IsMuted(c.nickname, c.clientId), s.state.IsExcluded(c.nickname, c.clientId)
+}
+
+// 制限されている参加者からのイベントを受け付けなかったことを、送信元のClientに通知する
+func (s *Server) rejectRestricted(ctx context.Context, conn *websocket.Conn, rejected Message, action string) {
+	slog.DebugContext(ctx, "Rejected event from restricted participant", "type", rejected.Type, "restriction", action)
+	metrics.MessagesDropped.WithLabelValues(action).Inc()
+	s.mu.Lock()
+	s.notifyModeration(conn, action, "")
+	s.mu.Unlock()
+}
@@ -0,0 +1,109 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// 終了した会議のルームを削除する前に、イベントの書き込みを待つ時間
+const releaseTimeout = 30 * time.Second
+
+// ルームのhandleMessagesに送る。ルームを削除した後は受け取る側がいないので捨てる
+func send[T any](s *Server, ch chan<- T, v T) {
+	select {
+	case ch <- v:
+	case <-s.stopped:
+	}
+}
+
+// 終了した会議で、接続がなくなったかどうか。既定の会議は削除しない。呼び出し側でs.muをロックしておくこと
+func (s *Server) idle() bool {
+	return !s.isDefault && !s.released && !s.shuttingDown && len(s.clients) == 0 && meetingStatus(s.state) == meetingStatusEnded
+}
+
+// 接続がなくなったらHubにルームの削除を依頼する
+func (s *Server) releaseIfIdle() {
+	s.mu.Lock()
+	idle := s.idle()
+	s.mu.Unlock()
+	if idle && s.onIdle != nil {
+		go s.onIdle(s)
+	}
+}
+
+// seqまで保存済みで、まだ削除できる状態ならルームを閉じる。
+// 以降の接続は切断し、再接続時にHubが保存済みのイベントからルームを作り直す
+func (s *Server) release(seq int64) bool {
+	s.mu.Lock()
+	if !s.idle() || s.state.Seq != seq {
+		s.mu.Unlock()
+		return false
+	}
+	s.stop()
+	s.mu.Unlock()
+	return true
+}
+
+// ルームを閉じてgoroutineを止める。以降の接続は切断する。呼び出し側でs.muをロックしておくこと
+func (s *Server) stop() {
+	s.released = true
+	if s.scheduleTimer != nil {
+		s.scheduleTimer.Stop()
+	}
+	s.stopOnce.Do(func() {
+		close(s.stopped)
+	})
+	s.closeStreams()
+}
+
+// 作成に失敗した会議のルームを削除する。参加コードを発行する前なので接続はない
+func (h *Hub) DiscardMeeting(meetingId string) {
+	h.mu.Lock()
+	room, ok := h.rooms[meetingId]
+	if !ok || room == h.defaultRoom {
+		h.mu.Unlock()
+		return
+	}
+	delete(h.rooms, meetingId)
+	h.mu.Unlock()
+	room.mu.Lock()
+	room.stop()
+	room.mu.Unlock()
+	slog.InfoContext(room.meetingContext(), "Discarded meeting room")
+}
+
+// 終了して接続のなくなった会議のルームを削除し、goroutineを止める。
+// 保存済みのイベントから作り直せるよう、この会議のイベントの書き込みが終わってから削除する
+func (h *Hub) releaseRoom(room *Server) {
+	ctx, cancel := context.WithTimeout(room.meetingContext(), releaseTimeout)
+	defer cancel()
+	for {
+		room.mu.Lock()
+		idle := room.idle()
+		seq := room.state.Seq
+		room.mu.Unlock()
+		if !idle {
+			return
+		}
+		if err := h.queue.Wait(ctx, room.meetingId, seq); err != nil {
+			slog.WarnContext(ctx, "Kept meeting room with unsaved events", "seq", seq, "error", err)
+			return
+		}
+		h.mu.Lock()
+		if h.rooms[room.meetingId] != room {
+			h.mu.Unlock()
+			return
+		}
+		released := room.release(seq)
+		if released {
+			delete(h.rooms, room.meetingId)
+		}
+		h.mu.Unlock()
+		if released {
+			slog.InfoContext(ctx, "Released meeting room")
+			return
+		}
+		// 待っている間に接続やイベントがあった場合は確認し直す
+	}
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"context"
+	"smile-sync/src/auth"
+	"testing"
+)
+
+// 終了した会議は接続がなくなるとルームを削除し、再接続時に保存済みのイベントから作り直す
+func TestReleaseEndedMeeting(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	meetingId := th.CreateMeeting(context.Background(), admin)
+	room, _ := th.Meeting(meetingId)
+	token := th.session(t, "alice", auth.RoleMember, meetingId)
+	conn := th.dial(t, token, "", "alice")
+	expect(t, conn, "imageAnimalType")
+	if err := room.Start(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	conn.WriteJSON(Message{Type: "smilePoint", Point: 2})
+	expect(t, conn, "smilePoint")
+	if err := room.Stop(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	// 接続中は削除しない
+	expect(t, conn, "meetingStatus")
+	if _, ok := th.Meeting(meetingId); !ok {
+		t.Fatal("room was released while a client was connected")
+	}
+
+	conn.Close()
+	eventually(t, "the room to be released", func() bool {
+		_, ok := th.Meeting(meetingId)
+		return !ok
+	})
+	select {
+	case <-room.stopped:
+	default:
+		t.Error("handleMessages was not stopped")
+	}
+	// 既定の会議は削除しない
+	if _, ok := th.Meeting(th.defaultRoom.meetingId); !ok {
+		t.Error("default room was released")
+	}
+
+	again := th.dial(t, token, "", "alice")
+	if msg := expect(t, again, "smilePoint"); msg.TotalSmilePoint != 2 {
+		t.Errorf("TotalSmilePoint after rebuilding = %d, want 2", msg.TotalSmilePoint)
+	}
+	rebuilt, ok := th.Meeting(meetingId)
+	if !ok || rebuilt == room {
+		t.Fatal("room was not rebuilt")
+	}
+	if status := rebuilt.Status(); status.IsMeetingActive || status.TotalSmilePoint != 2 {
+		t.Errorf("rebuilt status = %+v", status)
+	}
+}
+
+// 作成に失敗した会議はルームを削除し、goroutineを止める
+func TestDiscardMeeting(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	meetingId := th.CreateMeeting(context.Background(), admin)
+	room, _ := th.Meeting(meetingId)
+
+	th.DiscardMeeting(meetingId)
+	if _, ok := th.Meeting(meetingId); ok {
+		t.Error("discarded room is still registered")
+	}
+	select {
+	case <-room.stopped:
+	default:
+		t.Error("handleMessages was not stopped")
+	}
+
+	// 既定の会議は削除しない
+	th.DiscardMeeting(th.defaultRoom.meetingId)
+	if _, ok := th.Meeting(th.defaultRoom.meetingId); !ok {
+		t.Error("default room was discarded")
+	}
+}
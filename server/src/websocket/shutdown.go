@@ -0,0 +1,58 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// 全てのClientにシャットダウンを通知して切断し、進行中・中断中・開始予定の会議の状態を保存する
+func (s *Server) shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.shuttingDown = true
+	if s.scheduleTimer != nil {
+		s.scheduleTimer.Stop()
+	}
+	shutdownMsg := Message{
+		Type:      "serverShutdown",
+		Timestamp: time.Now(),
+		Text:      "Server is shutting down",
+	}
+	// 各ClientのwritePumpが通知とcloseを書き込んで接続を閉じ、HandleClientsを終了させる。
+	// 応答しないClientもwriteWaitで書き込みを諦めるので、シャットダウンを止めない
+	for conn := range s.clients {
+		s.sendMessage(conn, shutdownMsg)
+		s.closeConn(conn, websocket.CloseGoingAway, "server shutdown")
+	}
+	s.mu.Unlock()
+
+	// 各HandleClientsで処理中のイベントが書き込みキューに追加されるまで待つ
+	drained := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+		slog.InfoContext(s.meetingContext(), "All client handlers finished")
+	case <-ctx.Done():
+		slog.WarnContext(s.meetingContext(), "Timed out waiting for client handlers", "error", ctx.Err())
+	}
+
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.state.IsMeetingActive && !s.state.IsMeetingPaused && !s.state.IsScheduled {
+		return nil
+	}
+	snapshot := s.snapshot()
+	if err := saveSnapshot(ctx, snapshot); err != nil {
+		return err
+	}
+	s.saveSummary(snapshot)
+	slog.InfoContext(s.meetingContext(), "Saved meeting snapshot")
+	return nil
+}
@@ -0,0 +1,131 @@
+package websocket
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"smile-sync/src/metrics"
+)
+
+// 会議中は一定間隔でスナップショットを保存する
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) startSnapshotter() {
+	startedAt := s.state.MeetingStartTime
+	go func() {
+		ticker := time.NewTicker(s.snapshotInterval)
+		defer ticker.Stop()
+		for range ticker.C {
+			// 保存中に会議が終了してスナップショットが削除された後に、古い状態で上書きしないようにする
+			s.snapshotMu.Lock()
+			s.mu.Lock()
+			if !s.state.IsMeetingActive || s.shuttingDown || !s.state.MeetingStartTime.Equal(startedAt) {
+				s.mu.Unlock()
+				s.snapshotMu.Unlock()
+				return
+			}
+			snapshot := s.snapshot()
+			s.mu.Unlock()
+			if err := saveSnapshot(context.Background(), snapshot); err != nil {
+				slog.Error("Error saving meeting snapshot into Firestore", "meeting_id", snapshot.MeetingId, "error", err)
+			}
+			s.saveSummary(snapshot)
+			s.snapshotMu.Unlock()
+		}
+	}()
+}
+
+// 会議一覧用の概要を保存する。呼び出し側でs.snapshotMuをロックしておくこと
+func (s *Server) saveSummary(snapshot firebase.MeetingSnapshot) {
+	summary := firebase.NewMeetingSummary(snapshot.MeetingId, snapshot.State, snapshot.SnapshotAt)
+	if err := saveMeetingSummary(context.Background(), summary); err != nil {
+		slog.Error("Error saving meeting summary into Firestore", "meeting_id", snapshot.MeetingId, "error", err)
+	}
+}
+
+// 会議の開始時に、会議一覧に載るように概要を保存する
+func (s *Server) startMeetingSummary(snapshot firebase.MeetingSnapshot) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	s.saveSummary(snapshot)
+}
+
+// 正常に終了した会議のスナップショットを削除して復元対象から外し、最終的な概要を保存する
+func (s *Server) finishMeetingSnapshot(snapshot firebase.MeetingSnapshot) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if err := deleteSnapshot(context.Background(), snapshot.MeetingId); err != nil {
+		slog.Error("Error deleting meeting snapshot from Firestore", "meeting_id", snapshot.MeetingId, "error", err)
+	}
+	s.saveSummary(snapshot)
+}
+
+// 中断した会議や開始予定の会議は再起動後も復元できるよう、スナップショットを残して概要を保存する
+func (s *Server) keepMeetingSnapshot(snapshot firebase.MeetingSnapshot) {
+	s.snapshotMu.Lock()
+	defer s.snapshotMu.Unlock()
+	if err := saveSnapshot(context.Background(), snapshot); err != nil {
+		slog.Error("Error saving meeting snapshot into Firestore", "meeting_id", snapshot.MeetingId, "error", err)
+	}
+	s.saveSummary(snapshot)
+}
+
+// スナップショットに、その後に書き込まれたイベントを適用して進行中の会議の状態を復元する。
+// チャットはスナップショットに含まれないので、それ以前のイベントから作り直す
+func (s *Server) restore(ctx context.Context, snapshot firebase.MeetingSnapshot) error {
+	events, err := loadEvents(ctx, snapshot.MeetingId, 0)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.state = snapshot.State
+	// 設定を保存する前のスナップショットは既定値で補う
+	s.state.Settings = s.state.Settings.Or(event.DefaultSettings())
+	if s.state.ImageAnimalType != "" {
+		s.state.Settings.ImageAnimalType = s.state.ImageAnimalType
+	}
+	s.state.Messages = make([]event.Event, 0)
+	replayed := 0
+	for _, ev := range events {
+		if ev.Seq > snapshot.Seq {
+			s.state.Apply(ev)
+			replayed++
+		} else if ev.Type == event.Message {
+			s.state.Messages = append(s.state.Messages, ev)
+		}
+	}
+
+	if s.state.IsMeetingActive {
+		metrics.ActiveMeetings.Inc()
+		s.startTimer()
+		s.startSnapshotter()
+	}
+	// 停止中に開始予定日時を過ぎていれば、すぐに開始する
+	s.armSchedule()
+	slog.Info("Restored meeting from snapshot", "meeting_id", snapshot.MeetingId, "snapshot_at", snapshot.SnapshotAt, "replayed_events", replayed)
+	return nil
+}
+
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) snapshot() firebase.MeetingSnapshot {
+	state := s.state
+	state.LevelThresholds = append([]int(nil), s.state.LevelThresholds...)
+	state.ImageUrls = append([]string(nil), s.state.ImageUrls...)
+	state.Messages = nil // スナップショットには保存しない
+	state.Participants = append([]string(nil), s.state.Participants...)
+	state.Muted = append([]string(nil), s.state.Muted...)
+	state.Excluded = append([]string(nil), s.state.Excluded...)
+	state.Banned = append([]string(nil), s.state.Banned...)
+	state.WarningSeconds = append([]int64(nil), s.state.WarningSeconds...)
+	state.Agenda = append([]event.Section(nil), s.state.Agenda...)
+	return firebase.MeetingSnapshot{
+		MeetingId:  s.meetingId,
+		SnapshotAt: time.Now(),
+		IsDefault:  s.isDefault,
+		State:      state,
+	}
+}
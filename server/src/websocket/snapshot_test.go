@@ -0,0 +1,72 @@
+package websocket
+
+import (
+	"context"
+	"smile-sync/src/auth"
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"testing"
+	"time"
+)
+
+// スナップショットに、その後に書き込まれたイベントを適用して会議を復元する
+func TestRestoreReplaysEventsAfterSnapshot(t *testing.T) {
+	// レベルの閾値を決める前(開始から10秒以内)の会議
+	start := time.Now().Add(-5 * time.Second)
+	events := []event.Event{
+		{Seq: 1, Type: event.MeetingStarted, Timestamp: start},
+		{Seq: 2, Type: event.SmilePoint, Timestamp: start.Add(time.Second), Nickname: "alice", Point: 2},
+		{Seq: 3, Type: event.Message, Timestamp: start.Add(2 * time.Second), Nickname: "alice", Text: "hello"},
+		// スナップショットの後に書き込まれたイベント
+		{Seq: 4, Type: event.SmilePoint, Timestamp: start.Add(3 * time.Second), Nickname: "bob", Point: 5},
+		{Seq: 5, Type: event.Idea, Timestamp: start.Add(4 * time.Second), Nickname: "bob"},
+		{Seq: 6, Type: event.Message, Timestamp: start.Add(4 * time.Second), Nickname: "bob", Text: "hi"},
+	}
+	th := newTestHub(t, testWebsocketConfig(), func(store *memoryStorage) {
+		st := event.NewState()
+		for _, ev := range events[:3] {
+			st.Apply(ev)
+		}
+		store.saveSnapshot(firebase.MeetingSnapshot{MeetingId: "m1", SnapshotAt: start.Add(2 * time.Second), State: st})
+		store.write(context.Background(), "m1", events)
+	})
+
+	room, ok := th.Meeting("m1")
+	if !ok {
+		t.Fatal("meeting m1 was not restored")
+	}
+	status := room.Status()
+	if !status.IsMeetingActive || status.TotalSmilePoint != 7 || status.TotalIdeas != 1 {
+		t.Errorf("restored status = %+v", status)
+	}
+	// 既定の会議は復元されなかったので新しく作る
+	if th.defaultRoom == nil || th.defaultRoom == room {
+		t.Error("default meeting was not created")
+	}
+
+	// チャットはスナップショットに含まれないので、イベントから作り直す
+	conn := th.dial(t, th.session(t, "carol", auth.RoleMember, "m1"), "", "carol")
+	if msg := expect(t, conn, "message"); msg.Text != "hello" {
+		t.Errorf("history = %+v", msg)
+	}
+	if msg := expect(t, conn, "message"); msg.Text != "hi" {
+		t.Errorf("history after snapshot = %+v", msg)
+	}
+	if msg := expect(t, conn, "smilePoint"); msg.TotalSmilePoint != 7 {
+		t.Errorf("initial smile point = %d, want 7", msg.TotalSmilePoint)
+	}
+	expect(t, conn, "imageAnimalType")
+
+	// 新しいイベントには続きのSeqを振る
+	conn.WriteJSON(Message{Type: "idea"})
+	if msg := expect(t, conn, "idea"); msg.TotalIdeas != 2 {
+		t.Errorf("TotalIdeas = %d, want 2", msg.TotalIdeas)
+	}
+	if err := th.queue.Wait(context.Background(), "m1", 7); err != nil {
+		t.Fatal(err)
+	}
+	saved := th.storage.loadEvents("m1", 6)
+	if len(saved) != 1 || saved[0].Seq != 7 || saved[0].Type != event.Idea || saved[0].Nickname != "carol" {
+		t.Errorf("events after restore = %+v", saved)
+	}
+}
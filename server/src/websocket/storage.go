@@ -0,0 +1,14 @@
+package websocket
+
+import (
+	"smile-sync/src/firebase"
+)
+
+// Firestoreへの読み書き。テストではメモリ上の実装に差し替える
+var (
+	loadEvents          = firebase.LoadEvents
+	saveSnapshot        = firebase.SaveSnapshot
+	deleteSnapshot      = firebase.DeleteSnapshot
+	loadActiveSnapshots = firebase.LoadActiveSnapshots
+	saveMeetingSummary  = firebase.SaveMeetingSummary
+)
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"smile-sync/src/event"
+	"time"
+)
+
+const (
+	imageStatusIdle       = "idle"
+	imageStatusGenerating = "generating"
+	imageStatusReady      = "ready"
+	imageStatusFailed     = "failed"
+)
+
+// 笑いのペースを計算する期間
+const smileRateWindow = 60 * time.Second
+
+// 管理者向けに毎秒配信する、会議の集計値
+type LiveMetrics struct {
+	MeetingId          string                `json:"meetingId"`
+	Timestamp          time.Time             `json:"timestamp"`
+	IsMeetingActive    bool                  `json:"isMeetingActive"`
+	IsMeetingPaused    bool                  `json:"isMeetingPaused"`
+	ElapsedSeconds     int64                 `json:"elapsedSeconds"`
+	Participants       int                   `json:"participants"`
+	ClientsList        []string              `json:"clientsList"`
+	TotalSmilePoint    int                   `json:"totalSmilePoint"`
+	SmileRatePerMinute int                   `json:"smileRatePerMinute"` // 直近1分間のSmilePoint
+	Level              int                   `json:"level"`
+	TotalIdeas         int                   `json:"totalIdeas"`
+	ImageAnimalType    string                `json:"imageAnimalType"`
+	Settings           event.MeetingSettings `json:"settings"`
+	ImageStatus        string                `json:"imageStatus"`
+	ImageCount         int                   `json:"imageCount"`
+	LatestImageUrl     string                `json:"latestImageUrl,omitempty"`
+	// 予定がある場合
+	ScheduledStartAt *time.Time `json:"scheduledStartAt,omitempty"`
+	PlannedSeconds   int64      `json:"plannedSeconds,omitempty"`
+	RemainingSeconds *int64     `json:"remainingSeconds,omitempty"`
+	// アジェンダがある場合
+	Section *SectionStatus `json:"section,omitempty"`
+}
+
+// 直近のSmilePointを記録し、古いものを捨てる。呼び出し側でs.muをロックしておくこと
+func (s *Server) recordRecentSmile(ev event.Event) {
+	s.recentSmiles = append(s.recentSmiles, ev)
+	s.trimRecentSmiles(ev.Timestamp)
+}
+
+func (s *Server) trimRecentSmiles(now time.Time) {
+	i := 0
+	for i < len(s.recentSmiles) && now.Sub(s.recentSmiles[i].Timestamp) > smileRateWindow {
+		i++
+	}
+	s.recentSmiles = s.recentSmiles[i:]
+}
+
+func (s *Server) setImageStatus(status string) {
+	s.mu.Lock()
+	s.imageStatus = status
+	s.mu.Unlock()
+}
+
+func (s *Server) liveMetrics(now time.Time) LiveMetrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trimRecentSmiles(now)
+	smileRate := 0
+	for _, ev := range s.recentSmiles {
+		smileRate += ev.Point
+	}
+	clientsList := make([]string, 0, len(s.clients))
+	for _, c := range s.clients {
+		clientsList = append(clientsList, c.nickname)
+	}
+	m := LiveMetrics{
+		MeetingId:          s.meetingId,
+		Timestamp:          now,
+		IsMeetingActive:    s.state.IsMeetingActive,
+		IsMeetingPaused:    s.state.IsMeetingPaused,
+		Participants:       len(clientsList),
+		ClientsList:        clientsList,
+		TotalSmilePoint:    s.state.TotalSmilePoint,
+		SmileRatePerMinute: smileRate,
+		Level:              s.state.Level,
+		TotalIdeas:         s.state.TotalIdeas,
+		ImageAnimalType:    s.state.ImageAnimalType,
+		Settings:           s.state.Settings,
+		ImageStatus:        s.imageStatus,
+		ImageCount:         len(s.state.ImageUrls),
+	}
+	if s.state.IsMeetingActive || s.state.IsMeetingPaused {
+		m.ElapsedSeconds = int64(s.state.Duration(now).Seconds())
+	}
+	if s.state.IsScheduled {
+		startAt := s.state.ScheduledStartAt
+		m.ScheduledStartAt = &startAt
+	}
+	m.Section = s.sectionStatus(now)
+	if remaining, ok := s.state.Remaining(now); ok {
+		m.PlannedSeconds = s.state.PlannedSeconds
+		seconds := int64((remaining + time.Second - 1) / time.Second)
+		m.RemainingSeconds = &seconds
+	}
+	if len(s.state.ImageUrls) > 0 {
+		m.LatestImageUrl = s.state.ImageUrls[len(s.state.ImageUrls)-1]
+	}
+	return m
+}
+
+// 会議の集計値をServer-Sent Eventsで毎秒配信する。websocketを使わないので参加者には数えない
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.streamsDone:
+			fmt.Fprint(w, "event: serverShutdown\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		case now := <-ticker.C:
+			data, err := json.Marshal(s.liveMetrics(now))
+			if err != nil {
+				slog.ErrorContext(r.Context(), "Error marshaling live metrics", "error", err)
+				return
+			}
+			if _, err := fmt.Fprintf(w, "event: metrics\ndata: %s\n\n", data); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+// SSEの配信を全て終了する
+func (s *Server) closeStreams() {
+	s.closeStreamsOnce.Do(func() {
+		close(s.streamsDone)
+	})
+}
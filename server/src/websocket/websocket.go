@@ -2,37 +2,45 @@ package websocket
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
-	"os"
-
-	"smile-sync/src/firebase"
+	"strconv"
+
+	"smile-sync/src/audit"
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"smile-sync/src/event"
+	"smile-sync/src/logging"
+	"smile-sync/src/metrics"
+	"smile-sync/src/persistence"
+	"smile-sync/src/report"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
-}
+const writeWait = 5 * time.Second // 1回の書き込みの期限。過ぎたら接続を閉じる
+
+// 毎秒送信する経過時間のログは、会議ごとに1分に1回だけ出力する
+const timerLogEvery = 60
 
 // GoでJSONエンコードを行う場合、フィールド名はエクスポート（大文字で始まる必要があります）されている必要がある
 type Message struct {
 	Type            string    `json:"type"`
 	Timestamp       time.Time `json:"timestamp"`
 	IsMeetingActive bool      `json:"isMeetingActive,omitempty"`
+	IsMeetingPaused bool      `json:"isMeetingPaused,omitempty"`
 	Timer           string    `json:"timer,omitempty"`
 	ClientId        string    `json:"client_id"`
 	Nickname        string    `json:"nickname"`
 	Text            string    `json:"text,omitempty"`
+	Action          string    `json:"action,omitempty"`
 	Point           int       `json:"point,omitempty"`
 	TotalSmilePoint int       `json:"totalSmilePoint,omitempty"`
 	TotalIdeas      int       `json:"totalIdeas,omitempty"`
@@ -40,160 +48,399 @@ type Message struct {
 	ClientsList     []string  `json:"clientsList,omitempty"`
 	ImageUrls       []string  `json:"imageUrls,omitempty"`
 	ImageAnimalType string    `json:"imageAnimalType,omitempty"`
+	Latencies       []Latency `json:"latencies,omitempty"`
+	// 予定の会議時間がある場合
+	Countdown        string                 `json:"countdown,omitempty"`        // timerで送る残り時間
+	RemainingSeconds int64                  `json:"remainingSeconds,omitempty"` // timer、endWarningで送る残り時間[s]
+	PlannedSeconds   int64                  `json:"plannedSeconds,omitempty"`
+	ScheduledStartAt *time.Time             `json:"scheduledStartAt,omitempty"`
+	Report           *report.Report         `json:"report,omitempty"` // 自動で終了した会議のレポート
+	Settings         *event.MeetingSettings `json:"settings,omitempty"`
+	// アジェンダがある場合
+	Agenda  []event.Section `json:"agenda,omitempty"`
+	Section *SectionStatus  `json:"section,omitempty"` // timer、sectionで送る進行中のセクション
+}
+
+// 各Clientとのping/pongの往復時間
+type Latency struct {
+	Nickname  string `json:"nickname"`
+	LatencyMs int64  `json:"latencyMs"`
+}
+
+// 接続中のClientの情報
+type client struct {
+	id       string // ログで接続を識別するためのID
+	clientId string // Clientが送ってきたID
+	nickname string
+	latency  time.Duration // 直近のping/pongの往復時間
+	send     chan outgoing // writePumpが書き込むメッセージ
+	closing  bool          // 切断を決めた後は送信しない
 }
 
+// 1つの会議(ルーム)の接続と状態を管理する
 type Server struct {
-	isMeetingActive     bool                       // 会議の開始/終了を管理
-	meetingStartTime    time.Time                  // 会議の開始時刻を管理
-	clients             map[*websocket.Conn]string // Nicknameで接続中のclientsを管理
-	broadcast           chan Message
-	timerBroadcast      chan int64 // 経過時間[s]をClientに送信
-	smileBroadcast      chan int
-	ideaBroadcast       chan int
-	imagesBroadcast     chan []string
+	meetingId                string                      // FirestoreのDocId
+	isDefault                bool                        // 参加コードなしで接続する既定の会議かどうか
+	state                    event.State                 // イベントを適用して導出した会議の状態
+	queue                    *persistence.Queue          // イベントを非同期にFirestoreへ書き込む
+	auditLog                 *audit.Log                  // 管理者の操作の記録。nilなら記録しない
+	clients                  map[*websocket.Conn]*client // 接続中のclientsを管理
+	broadcast                chan Message
+	timerBroadcast           chan timerTick
+	smileBroadcast           chan int
+	ideaBroadcast            chan int
+	imagesBroadcast          chan []string
 	imageAnimalTypeBroadcast chan string
-	levelBroadcast      chan int
-	messages            []Message
-	totalSmilePoint     int
-	totalIdeas          int
-	imageUrls           []string
-	level               int
-	levelThresholds     []int // レベルの閾値
-	isLevelThresholdSet bool
-	imageAnimalType	    string
-	mu                  sync.Mutex
-}
-
-func NewServer() *Server {
+	levelBroadcast           chan int
+	pingInterval             time.Duration
+	pongWait                 time.Duration
+	snapshotInterval         time.Duration
+	endWarnings              []time.Duration // 会議ごとに指定がない場合の終了の予告
+	scheduleTimer            *time.Timer     // 開始予定日時に会議を開始する
+	imageProvider            config.ImageProvider
+	shuttingDown             bool           // シャットダウン処理中かどうか
+	wg                       sync.WaitGroup // 接続中のHandleClientsの終了を待つ
+	snapshotMu               sync.Mutex     // スナップショットの保存と削除を直列化する
+	recentSmiles             []event.Event  // 直近のSmilePoint(笑いのペースの計算用)
+	imageStatus              string         // 画像生成の状態
+	streamsDone              chan struct{}  // 閉じるとSSEの配信を終了する
+	closeStreamsOnce         sync.Once
+	lastLoopAt               atomic.Int64  // handleMessagesのループが最後に回った時刻(UnixNano)
+	stopped                  chan struct{} // 閉じるとhandleMessagesを終了する
+	stopOnce                 sync.Once
+	released                 bool          // 終了した会議としてHubから削除したかどうか
+	onIdle                   func(*Server) // 終了した会議の接続がなくなったときに呼ぶ
+	timerLogSampler          *logging.Sampler
+	mu                       sync.Mutex
+}
+
+func NewServer(meetingId string, queue *persistence.Queue, ws config.Websocket, imageProvider config.ImageProvider) *Server {
 	return &Server{
-		isMeetingActive:     false,
-		clients:             make(map[*websocket.Conn]string),
-		broadcast:           make(chan Message),
-		timerBroadcast:      make(chan int64),
-		smileBroadcast:      make(chan int),
-		ideaBroadcast:       make(chan int),
-		imagesBroadcast:     make(chan []string),
+		meetingId:                meetingId,
+		state:                    event.NewState(),
+		queue:                    queue,
+		clients:                  make(map[*websocket.Conn]*client),
+		broadcast:                make(chan Message),
+		timerBroadcast:           make(chan timerTick),
+		smileBroadcast:           make(chan int),
+		ideaBroadcast:            make(chan int),
+		imagesBroadcast:          make(chan []string),
 		imageAnimalTypeBroadcast: make(chan string),
-		levelBroadcast:      make(chan int),
-		messages:            make([]Message, 0),
-		totalSmilePoint:     0,
-		totalIdeas:          0,
-		imageUrls:           make([]string, 0),
-		level:               1,
-		levelThresholds:     make([]int, 9), // レベルが10段階なので、9つの閾値を設定
-		isLevelThresholdSet: false,
-		imageAnimalType:     "golden retriever",
+		levelBroadcast:           make(chan int),
+		imageStatus:              imageStatusIdle,
+		streamsDone:              make(chan struct{}),
+		stopped:                  make(chan struct{}),
+		pingInterval:             ws.PingInterval,
+		pongWait:                 ws.PongWait,
+		snapshotInterval:         ws.SnapshotInterval,
+		endWarnings:              ws.EndWarnings,
+		imageProvider:            imageProvider,
+		timerLogSampler:          logging.NewSampler(timerLogEvery),
 	}
 }
 
-func (s *Server) handleMeetingStatus(message Message) {
+// イベントにSeqを振って会議の状態に適用する。呼び出し側でs.muをロックしておくこと
+func (s *Server) applyEvent(ev event.Event) event.Event {
+	ev.Seq = s.state.Seq + 1
+	if ev.Timestamp.IsZero() {
+		ev.Timestamp = time.Now()
+	}
+	if s.state.IsMeetingActive {
+		// 中断していた時間は含めない
+		ev.SinceMeetingStart = int64(s.state.Duration(ev.Timestamp).Seconds())
+		// セクションごとに集計できるよう、進行中のセクションを付ける
+		if ev.Section == 0 {
+			ev.Section = s.state.Section
+		}
+	}
+	wasActive := s.state.IsMeetingActive
+	s.state.Apply(ev)
+	if !wasActive && s.state.IsMeetingActive {
+		metrics.ActiveMeetings.Inc()
+	} else if wasActive && !s.state.IsMeetingActive {
+		metrics.ActiveMeetings.Dec()
+	}
+	return ev
+}
+
+// 適用済みのイベントを書き込みキューに追加する。Firestoreへの書き込みはキュー側でまとめて行う。
+// キューを閉じた後のイベントは、キュー側でログに残して破棄される
+func (s *Server) saveEvent(ev event.Event) {
+	s.queue.Enqueue(s.meetingId, ev)
+}
+
+// 会議IDを付けてログを出力するためのcontext
+func (s *Server) meetingContext() context.Context {
+	return logging.With(context.Background(), "meeting_id", s.meetingId)
+}
+
+func (s *Server) isMeetingActive() bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
+	return s.state.IsMeetingActive
+}
 
-	if message.IsMeetingActive == true {
-		s.isMeetingActive = true
-		s.meetingStartTime = time.Now()
-		log.Println("Meeting started")
-
-		// 経過時間を毎秒送信
-		go func() {
-			for s.isMeetingActive {
-				// 会議開始後2分後に閾値を設定 -> demo用に10秒後に設定
-				if !s.isLevelThresholdSet && int64(time.Since(s.meetingStartTime).Seconds()) >= 10 {
-					s.mu.Lock()
-					for i := 0; i < 9; i++ {
-						s.levelThresholds[i] = s.totalSmilePoint * (1 << i) // 1, 2, 4, 8...倍
+// Clientからの会議の開始、中断、終了。状態が変わらなかった場合も、表示を揃えるため現在の状態を送る
+func (s *Server) handleMeetingStatus(ctx context.Context, message Message) {
+	actor := Actor{ClientId: message.ClientId, Nickname: message.Nickname, Via: audit.ViaWebsocket}
+	var err error
+	switch {
+	case message.IsMeetingActive:
+		err = s.Start(ctx, actor)
+	case message.IsMeetingPaused:
+		err = s.Pause(ctx, actor)
+	default:
+		err = s.Stop(ctx, actor)
+	}
+	if err != nil {
+		s.broadcastMeetingStatus()
+	}
+}
+
+// 毎秒Clientに送信する経過時間と、予定の会議時間までの残り時間[s]
+type timerTick struct {
+	elapsed   int64
+	remaining int64
+	limited   bool           // 予定の会議時間があるかどうか
+	section   *SectionStatus // アジェンダがあれば進行中のセクション
+}
+
+func formatClock(seconds int64) string {
+	return fmt.Sprintf("%02d:%02d:%02d", seconds/3600, seconds%3600/60, seconds%60)
+}
+
+// 経過時間を毎秒送信し、予定の会議時間があれば終了を予告して自動で終了する。呼び出し側でs.muをロックしておくこと
+func (s *Server) startTimer() {
+	startedAt := s.state.MeetingStartTime
+	go func() {
+		// 前回の残り時間。再開・復元した時点で既に過ぎている予告は送らない
+		prevRemaining := int64(-1)
+		for {
+			s.mu.Lock()
+			// 会議が終了したか、別の会議が開始された場合は終了
+			if !s.state.IsMeetingActive || !s.state.MeetingStartTime.Equal(startedAt) {
+				s.mu.Unlock()
+				return
+			}
+			now := time.Now()
+			// 中断していた時間を除いた、会議開始からの経過時間
+			elapsedTime := int64(s.state.Duration(now).Seconds())
+			remaining, limited := s.state.Remaining(now)
+			warningSeconds := append([]int64(nil), s.state.WarningSeconds...)
+			// 会議の設定の時間が経ったら、それまでのSmilePointから閾値を設定
+			var thresholdsEv *event.Event
+			if !s.state.IsLevelThresholdSet && elapsedTime >= s.state.Settings.CalibrationSeconds {
+				ev := s.applyEvent(event.Event{
+					Type:            event.LevelThresholdsSet,
+					LevelThresholds: event.NewLevelThresholds(s.state.TotalSmilePoint, s.state.Settings.LevelCount),
+				})
+				thresholdsEv = &ev
+				slog.InfoContext(s.meetingContext(), "Level thresholds set", "thresholds", s.state.LevelThresholds)
+			}
+			// セクションの予定の時間が過ぎたら次のセクションに進む。最後のセクションは会議が終わるまで続ける
+			var sectionEv *event.Event
+			if sectionRemaining, ok := s.state.SectionRemaining(now); ok && sectionRemaining <= 0 && s.state.Section < len(s.state.Agenda) {
+				ev := s.applyEvent(event.Event{
+					Type:      event.SectionStarted,
+					Timestamp: now,
+					Section:   s.state.Section + 1,
+				})
+				sectionEv = &ev
+			}
+			section := s.sectionStatus(now)
+			s.mu.Unlock()
+			if thresholdsEv != nil {
+				s.saveEvent(*thresholdsEv)
+			}
+			if sectionEv != nil {
+				s.saveEvent(*sectionEv)
+				send(s, s.broadcast, Message{Type: "section", Timestamp: now, Section: section})
+				slog.InfoContext(s.meetingContext(), "Section started", "section", section.Index, "title", section.Title)
+			}
+			tick := timerTick{elapsed: elapsedTime, limited: limited, section: section}
+			if limited {
+				// 経過時間と足して予定の会議時間になるよう切り上げる
+				tick.remaining = int64((remaining + time.Second - 1) / time.Second)
+				if prevRemaining < 0 {
+					prevRemaining = tick.remaining + 1
+				}
+				for _, w := range warningSeconds {
+					if prevRemaining > w && tick.remaining <= w {
+						send(s, s.broadcast, Message{Type: "endWarning", Timestamp: now, RemainingSeconds: w})
+						slog.InfoContext(s.meetingContext(), "Sent end warning", "remaining_seconds", w)
 					}
-					s.isLevelThresholdSet = true
-					log.Printf("Level thresholds: %v\n", s.levelThresholds)
-					s.mu.Unlock()
 				}
-				// カウントアップ
-				elapsedTime := int64(time.Since(s.meetingStartTime).Seconds())
-				s.timerBroadcast <- elapsedTime
-				time.Sleep(1 * time.Second)
+				prevRemaining = tick.remaining
 			}
-		}()
-	} else {
-		s.isMeetingActive = false
-		s.meetingStartTime = time.Time{}
-		log.Println("Meeting ended")
-	}
+			// カウントアップ(予定の会議時間があればカウントダウンも)
+			send(s, s.timerBroadcast, tick)
+			if limited && remaining <= 0 {
+				s.autoStop()
+				return
+			}
+			time.Sleep(1 * time.Second)
+		}
+	}()
+}
 
-	meetingStatusMsg := Message{
-		Type:            "meetingStatus",
-		IsMeetingActive: s.isMeetingActive,
+// 予定の会議時間に達した会議を終了し、レポートを送る
+func (s *Server) autoStop() {
+	ctx := s.meetingContext()
+	if err := s.Stop(ctx, schedulerActor); err != nil {
+		// 同時に手動で終了された場合など
+		slog.InfoContext(ctx, "Meeting was not stopped automatically", "error", err)
+		return
 	}
-	s.broadcast <- meetingStatusMsg
+	go s.sendReport(ctx)
 }
 
-func (s *Server) HandleClients(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
+// この会議の終了までのイベントが保存されるのを待ってから、レポートを作成して全てのClientに送る
+func (s *Server) sendReport(ctx context.Context) {
+	s.mu.Lock()
+	seq := s.state.Seq
+	s.mu.Unlock()
+	waitCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	if err := s.queue.Wait(waitCtx, s.meetingId, seq); err != nil {
+		slog.WarnContext(ctx, "Timed out waiting for events to be saved, the report may be incomplete", "seq", seq, "error", err)
+	}
+	events, err := loadEvents(ctx, s.meetingId, 0)
 	if err != nil {
-		log.Println(err)
+		slog.ErrorContext(ctx, "Failed to load events for report", "error", err)
 		return
 	}
+	r := report.Build(s.meetingId, events, time.Now())
+	slog.InfoContext(ctx, "Meeting report generated", "duration_seconds", r.DurationSeconds, "total_smile_point", r.TotalSmilePoint, "total_ideas", r.TotalIdeas)
+	send(s, s.broadcast, Message{Type: "report", Timestamp: time.Now(), Report: &r})
+}
+
+// Hubが接続先の会議を決めてupgradeした接続を処理する。
+// 参加コードやログインのセッションで接続した場合、Nicknameはセッションのものを使う
+func (s *Server) handleClient(ctx context.Context, conn *websocket.Conn) {
+	connId := logging.NewID()
+	ctx = logging.With(ctx, "conn_id", connId, "meeting_id", s.meetingId)
+	claims, authenticated := auth.ClaimsFrom(ctx)
+	isAdmin := authenticated && claims.IsAdmin()
+	s.wg.Add(1)
+	defer s.wg.Done()
 	defer func() {
 		// HandleClients()終了時に実行、つまりwebsocketから切断されたときに実行
 		s.mu.Lock()
+		if _, ok := s.clients[conn]; ok {
+			metrics.ConnectedClients.Dec()
+		}
 		delete(s.clients, conn) // clientを削除
+		shuttingDown := s.shuttingDown
 		s.mu.Unlock()
-		s.broadcastClientsList()
+		// シャットダウン中は他のClientも切断されるので送信しない
+		if !shuttingDown {
+			s.broadcastClientsList()
+		}
 		conn.Close()
+		s.releaseIfIdle()
 	}()
 
+	// シャットダウン中は新しい接続を受け付けない
+	s.mu.Lock()
+	shuttingDown := s.shuttingDown
+	s.mu.Unlock()
+	if shuttingDown {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "server is shutting down"), time.Now().Add(writeWait))
+		return
+	}
+
+	// pongを受け取るたびに読み込み期限を延長し、応答がなければReadMessageをエラーにする
+	conn.SetReadDeadline(time.Now().Add(s.pongWait))
+	conn.SetPongHandler(func(appData string) error {
+		conn.SetReadDeadline(time.Now().Add(s.pongWait))
+		s.updateLatency(conn, appData)
+		return nil
+	})
+	done := make(chan struct{})
+	defer close(done)
+	go s.keepAlive(ctx, conn, done)
+
 	// Nicknameを受け取るまで待つ
 	_, msg, err := conn.ReadMessage()
 	if err != nil {
-		log.Println("Error reading initial message: ", err)
+		slog.InfoContext(ctx, "Error reading initial message", "error", err)
 		return
 	}
 
 	var initMsg Message
 	if err := json.Unmarshal(msg, &initMsg); err != nil {
-		log.Println("Error unmarshaling initial message: ", err)
+		slog.WarnContext(ctx, "Error unmarshaling initial message", "error", err)
 		return
 	}
 
-	// 新しいClientを登録
+	if authenticated {
+		initMsg.Nickname = claims.Nickname
+	}
+
+	// 新しいClientを登録。会議から締め出されている場合は切断する
 	s.mu.Lock()
-	s.clients[conn] = initMsg.Nickname
+	// 待っている間にルームが削除された場合は、再接続して作り直したルームに参加してもらう
+	if s.released {
+		s.mu.Unlock()
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "meeting was closed"), time.Now().Add(writeWait))
+		return
+	}
+	if s.state.IsBanned(initMsg.Nickname, initMsg.ClientId) {
+		s.notifyModeration(conn, ActionBan, "")
+		s.disconnect(conn, ActionBan)
+		s.mu.Unlock()
+		slog.InfoContext(ctx, "Rejected banned participant", "nickname", initMsg.Nickname, "client_id", initMsg.ClientId)
+		return
+	}
+	c := &client{id: connId, clientId: initMsg.ClientId, nickname: initMsg.Nickname, send: make(chan outgoing, sendBuffer)}
+	s.clients[conn] = c
 	s.mu.Unlock()
+	// 以降の送信はwritePumpが書き込むので、遅いClientがいても他のClientへの送信を止めない
+	go s.writePump(ctx, conn, c.send, done)
+	metrics.ConnectedClients.Inc()
+	ctx = logging.With(ctx, "nickname", initMsg.Nickname)
+	slog.InfoContext(ctx, "Client connected")
 
 	// 現在のClientリストを全てのClientsに送信
 	s.broadcastClientsList()
 
+	// 他の送信と書き込みが重ならないよう、初期状態の送信が終わるまでロックしておく
+	s.mu.Lock()
+	state := s.state
+
 	// 現在のメッセージ履歴を新しいClientに送信
-	for _, msg := range s.messages {
-		s.sendMessage(conn, msg)
+	for _, ev := range state.Messages {
+		s.sendMessage(conn, Message{
+			Type:      "message",
+			Timestamp: ev.Timestamp,
+			ClientId:  ev.ClientId,
+			Nickname:  ev.Nickname,
+			Text:      ev.Text,
+		})
 	}
 
 	// 会議の状態を新しいClientに送信
-	meetingStatusMsg := Message{
-		Type:            "meetingStatus",
-		IsMeetingActive: s.isMeetingActive,
-	}
-	s.sendMessage(conn, meetingStatusMsg)
+	s.sendMessage(conn, s.meetingStatusMessage())
 
 	// 現在のSmilePointを新しいClientに送信
 	initialSmilePoint := Message{
 		Type:            "smilePoint",
-		TotalSmilePoint: s.totalSmilePoint,
+		TotalSmilePoint: state.TotalSmilePoint,
 	}
 	s.sendMessage(conn, initialSmilePoint)
 
 	// 現在のIdea数を新しいClientに送信
 	initialIdea := Message{
 		Type:       "idea",
-		TotalIdeas: s.totalIdeas,
+		TotalIdeas: state.TotalIdeas,
 	}
 	s.sendMessage(conn, initialIdea)
 
 	// 現在のImageUrlを新しいClientに送信
-	if len(s.imageUrls) != 0 {
+	if len(state.ImageUrls) != 0 {
 		imageUrls := Message{
-			Type:     "imageUrls",
-			ImageUrls: s.imageUrls,
+			Type:      "imageUrls",
+			ImageUrls: state.ImageUrls,
 		}
 		s.sendMessage(conn, imageUrls)
 	}
@@ -201,47 +448,84 @@ func (s *Server) HandleClients(w http.ResponseWriter, r *http.Request) {
 	// 現在のLevelを新しいClientに送信
 	initialLevel := Message{
 		Type:  "level",
-		Level: s.level,
+		Level: state.Level,
 	}
 	s.sendMessage(conn, initialLevel)
 
+	// アジェンダと進行中のセクションを新しいClientに送信
+	if len(state.Agenda) > 0 {
+		s.sendMessage(conn, s.agendaMessage(time.Now()))
+	}
+
+	// 会議の設定を新しいClientに送信
+	settings := state.Settings
+	s.sendMessage(conn, Message{Type: "settings", Settings: &settings})
+
 	// 現在のImageAnimalTypeを新しいClientに送信
 	imageAnimalType := Message{
 		Type:            "imageAnimalType",
-		ImageAnimalType: s.imageAnimalType,
+		ImageAnimalType: state.ImageAnimalType,
 	}
 	s.sendMessage(conn, imageAnimalType)
+	s.mu.Unlock()
 
 	// Clientからのメッセージを待ち受ける
 	for {
 		_, msg, err := conn.ReadMessage()
 		if err != nil {
-			log.Println(err)
-			s.mu.Lock()
-			delete(s.clients, conn)
-			s.mu.Unlock()
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.WarnContext(ctx, "Client disconnected unexpectedly", "error", err)
+			} else {
+				slog.InfoContext(ctx, "Client disconnected")
+			}
 			break
 		}
 		var receivedMsg Message
 		if err := json.Unmarshal(msg, &receivedMsg); err != nil {
-			log.Println("Error unmarshaling message: ", err)
+			slog.WarnContext(ctx, "Error unmarshaling message", "error", err)
 			continue
 		}
-		log.Printf("Received: %v", receivedMsg)
+		slog.DebugContext(ctx, "Received", "type", receivedMsg.Type)
+		metrics.MessagesReceived.WithLabelValues(receivedType(receivedMsg.Type)).Inc()
 
 		receivedMsg.Timestamp = time.Now()
+		// 他の参加者になりすまして送信できないようにする
+		if authenticated {
+			receivedMsg.Nickname = claims.Nickname
+		}
+
+		// 会議の開始、中断、終了と設定の変更は管理者のみ
+		if adminOnlyTypes[receivedMsg.Type] && !isAdmin {
+			s.rejectForbidden(ctx, conn, receivedMsg)
+			continue
+		}
 
 		// 会議が開始されていない場合のみ更新を受け付ける
-		if !s.isMeetingActive {
+		if !s.isMeetingActive() {
 			if receivedMsg.Type == "imageAnimalType" {
-				s.handleAnimalType(receivedMsg)
+				s.handleAnimalType(ctx, receivedMsg)
 			}
 		} else {
-		// 会議が開始されている場合のみ更新を受け付ける
+			// 会議が開始されている場合のみ更新を受け付ける
+			// Firestoreへの書き込みが追いついていない間は、Clientからのイベントを受け付けない
+			if s.queue.Overloaded() && receivedMsg.Type != "meetingStatus" {
+				s.sendBusy(ctx, conn, receivedMsg)
+				continue
+			}
+			muted, excluded := s.restrictions(conn)
 			if receivedMsg.Type == "message" {
+				if muted {
+					s.rejectRestricted(ctx, conn, receivedMsg, ActionMute)
+					continue
+				}
 				s.handleMessage(receivedMsg)
 			} else if receivedMsg.Type == "smilePoint" {
-				s.handleSmilePoint(receivedMsg)
+				// 集計から除外された参加者のSmilePointは記録しない
+				if excluded {
+					s.rejectRestricted(ctx, conn, receivedMsg, ActionExclude)
+					continue
+				}
+				s.handleSmilePoint(ctx, receivedMsg)
 			} else if receivedMsg.Type == "idea" {
 				s.handleIdea(receivedMsg)
 			}
@@ -249,266 +533,325 @@ func (s *Server) HandleClients(w http.ResponseWriter, r *http.Request) {
 
 		// 会議の状態を更新
 		if receivedMsg.Type == "meetingStatus" {
-			s.handleMeetingStatus(receivedMsg)
+			s.handleMeetingStatus(ctx, receivedMsg)
 		}
+	}
+}
 
-		log.Printf("Received: %v", receivedMsg)
+// 管理者からのみ受け付けるメッセージの種類。会議全体の状態や設定を変更する
+var adminOnlyTypes = map[string]bool{
+	"meetingStatus":   true,
+	"imageAnimalType": true,
+}
+
+// Clientから受け付けるメッセージの種類。それ以外はラベルの種類が増えないようにまとめて数える
+var receivedTypes = map[string]bool{
+	"meetingStatus":   true,
+	"message":         true,
+	"smilePoint":      true,
+	"idea":            true,
+	"imageAnimalType": true,
+}
+
+func receivedType(t string) string {
+	if receivedTypes[t] {
+		return t
 	}
+	return "unknown"
 }
 
 func (s *Server) handleMessage(message Message) {
 	// 全てのメッセージを履歴に保存
 	s.mu.Lock()
-	s.messages = append(s.messages, message)
+	ev := s.applyEvent(event.Event{
+		Type:      event.Message,
+		Timestamp: message.Timestamp,
+		ClientId:  message.ClientId,
+		Nickname:  message.Nickname,
+		Text:      message.Text,
+	})
 	s.mu.Unlock()
+	s.saveEvent(ev)
 	// 他の全てのClientにメッセージを送信
-	s.broadcast <- message
+	send(s, s.broadcast, message)
 }
 
-func (s *Server) handleSmilePoint(message Message) {
-	firestoreSmilePointField := firebase.SmilePoint{
-		Timestamp:         message.Timestamp,
-		SinceMeetingStart: int64(time.Since(s.meetingStartTime).Seconds()),
-		ClientId:          message.ClientId,
-		Nickname:          message.Nickname,
-		Point:             message.Point,
-		TotalSmilePoint:   s.totalSmilePoint,
-	}
-	if err := firebase.SaveSmilePoint(firestoreSmilePointField); err != nil {
-		log.Println("Error inserting smile_point into Firestore: ", err)
-	}
+func (s *Server) handleSmilePoint(ctx context.Context, message Message) {
 	s.mu.Lock()
-	s.totalSmilePoint += message.Point
+	previousLevel := s.state.Level
+	ev := s.applyEvent(event.Event{
+		Type:      event.SmilePoint,
+		Timestamp: message.Timestamp,
+		ClientId:  message.ClientId,
+		Nickname:  message.Nickname,
+		Point:     message.Point,
+	})
+	s.recordRecentSmile(ev)
+	// 合計とレベルはイベントの適用結果から取得する
+	totalSmilePoint := s.state.TotalSmilePoint
+	level := s.state.Level
+	settings := s.state.Settings
 	s.mu.Unlock()
+	s.saveEvent(ev)
 
 	// 他の全てのClientにSmilePointを送信
-	s.smileBroadcast <- s.totalSmilePoint
+	send(s, s.smileBroadcast, totalSmilePoint)
 
-	// レベルの処理
-	s.mu.Lock()
-	previousLevel := s.level
-	if s.isLevelThresholdSet { // 閾値が設定されている場合に動的計算
-		switch {
-		case s.totalSmilePoint >= s.levelThresholds[8]:
-			s.level = 10
-		case s.totalSmilePoint >= s.levelThresholds[7]:
-			s.level = 9
-		case s.totalSmilePoint >= s.levelThresholds[6]:
-			s.level = 8
-		case s.totalSmilePoint >= s.levelThresholds[5]:
-			s.level = 7
-		case s.totalSmilePoint >= s.levelThresholds[4]:
-			s.level = 6
-		case s.totalSmilePoint >= s.levelThresholds[3]:
-			s.level = 5
-		case s.totalSmilePoint >= s.levelThresholds[2]:
-			s.level = 4
-		case s.totalSmilePoint >= s.levelThresholds[1]:
-			s.level = 3
-		case s.totalSmilePoint >= s.levelThresholds[0]:
-			s.level = 2
-		}
-	} else {
-		s.level = 1
+	if previousLevel == level {
+		return
 	}
-	s.mu.Unlock()
-
-	// レベルが変化していたらFirestoreにLevelを保存
-	if previousLevel != s.level {
-		firestoreSmileLevelField := firebase.SmileLevel{
-			Timestamp:         message.Timestamp,
-			SinceMeetingStart: int64(time.Since(s.meetingStartTime).Seconds()),
-			Level:             s.level,
-		}
-		if err := firebase.SaveSmileLevel(firestoreSmileLevelField); err != nil {
-			log.Println("Error inserting smile_level into Firestore: ", err)
-		}
-		// 全てのClientに新しいLevelを送信
-		s.levelBroadcast <- s.level
-
-		// 新しいImageUrlを生成し、Firestoreに保存
-		prompt, imageUrl, err := generateImageUrl(s.level, s.imageAnimalType)
-		if err == nil && imageUrl != "" {
-			firestoreSmileImageField := firebase.SmileImage{
-				Timestamp:         message.Timestamp,
-				SinceMeetingStart: int64(time.Since(s.meetingStartTime).Seconds()),
-				TotalSmilePoint:   s.totalSmilePoint,
-				Prompt:            prompt,
-				ImageUrl:          imageUrl,
-			}
-			if err := firebase.SaveSmileImage(firestoreSmileImageField); err != nil {
-				log.Println("Error inserting smile_image into Firestore: ", err)
-			}
-			s.mu.Lock()
-			s.imageUrls = append(s.imageUrls, imageUrl)
-			s.mu.Unlock()
-			// 他の全てのClientに新しいImageUrlを送信
-			s.imagesBroadcast <- s.imageUrls
-		}
+	// 全てのClientに新しいLevelを送信
+	send(s, s.levelBroadcast, level)
+
+	// 新しいImageUrlを生成し、Firestoreに保存
+	s.setImageStatus(imageStatusGenerating)
+	generationStart := time.Now()
+	prompt, imageUrl, err := generateImageUrl(s.imageProvider, level, settings)
+	metrics.ImageGenerationDuration.Observe(time.Since(generationStart).Seconds())
+	if err != nil || imageUrl == "" {
+		slog.WarnContext(ctx, "Failed to generate image", "level", level, "error", err)
+		metrics.ImageGenerationFailures.Inc()
+		s.setImageStatus(imageStatusFailed)
+	} else {
+		s.mu.Lock()
+		s.imageStatus = imageStatusReady
+		ev := s.applyEvent(event.Event{
+			Type:     event.Image,
+			Prompt:   prompt,
+			ImageUrl: imageUrl,
+		})
+		imageUrls := append([]string(nil), s.state.ImageUrls...)
+		s.mu.Unlock()
+		s.saveEvent(ev)
+		// 他の全てのClientに新しいImageUrlを送信
+		send(s, s.imagesBroadcast, imageUrls)
 	}
 }
 
 func (s *Server) handleIdea(message Message) {
-	firestoreIdeaField := firebase.SmileIdea{
-		Timestamp:         message.Timestamp,
-		SinceMeetingStart: int64(time.Since(s.meetingStartTime).Seconds()),
-		ClientId:          message.ClientId,
-		Nickname:          message.Nickname,
-	}
-	if err := firebase.SaveSmileIdea(firestoreIdeaField); err != nil {
-		log.Println("Error inserting idea into Firestore: ", err)
-	}
 	s.mu.Lock()
-	s.totalIdeas++
+	ev := s.applyEvent(event.Event{
+		Type:      event.Idea,
+		Timestamp: message.Timestamp,
+		ClientId:  message.ClientId,
+		Nickname:  message.Nickname,
+	})
+	totalIdeas := s.state.TotalIdeas
 	s.mu.Unlock()
+	s.saveEvent(ev)
 
 	// 他の全てのClientにIdea数を送信
-	s.ideaBroadcast <- s.totalIdeas
+	send(s, s.ideaBroadcast, totalIdeas)
 }
 
-func (s *Server) handleAnimalType(message Message) {
-	s.mu.Lock()
-	if !s.isMeetingActive {
-		s.imageAnimalType = message.ImageAnimalType
-		log.Printf("Image animal type is set to %s\n", s.imageAnimalType)
-	} else {
-		log.Println("Meeting is active, cannot change image animal type")
+func (s *Server) handleAnimalType(ctx context.Context, message Message) {
+	actor := Actor{ClientId: message.ClientId, Nickname: message.Nickname, Via: audit.ViaWebsocket}
+	if err := s.UpdateSettings(ctx, actor, event.MeetingSettings{ImageAnimalType: message.ImageAnimalType}); err != nil {
+		slog.InfoContext(ctx, "Cannot change image animal type", "error", err)
+		// 変更できなかったことが分かるよう、現在の値を送り直す
+		s.mu.Lock()
+		imageAnimalType := s.state.ImageAnimalType
+		s.mu.Unlock()
+		send(s, s.imageAnimalTypeBroadcast, imageAnimalType)
 	}
-	s.mu.Unlock()
-	// 他の全てのClientに新しいImageAnimalTypeを送信
-	s.imageAnimalTypeBroadcast <- s.imageAnimalType
 }
 
-func (s *Server) HandleMessages() {
+func (s *Server) handleMessages() {
+	// 各Clientのlatencyはpingと同じ間隔で送信
+	latencyTicker := time.NewTicker(s.pingInterval)
+	defer latencyTicker.Stop()
+
 	// メッセージを待ち受け、全てのClientに送信
 	for {
+		s.lastLoopAt.Store(time.Now().UnixNano())
 		select {
 		// メッセージが送信された場合
 		case newMsg := <-s.broadcast:
-			s.mu.Lock()
-			for client := range s.clients {
-				s.sendMessage(client, newMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent message from" + newMsg.Nickname + "to all clients: " + newMsg.Text + "\n")
+			s.broadcastAll(newMsg)
+			slog.DebugContext(s.meetingContext(), "Sent message to all clients", "type", newMsg.Type, "nickname", newMsg.Nickname)
 		// SmilePointが送信された場合
 		case totalSmilePoint := <-s.smileBroadcast:
-			s.mu.Lock()
-			totalSmilePointMsg := Message{
+			s.broadcastAll(Message{
 				Type:            "smilePoint",
 				TotalSmilePoint: totalSmilePoint,
-			}
-			for client := range s.clients {
-				s.sendMessage(client, totalSmilePointMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent total smile point to all clients: %dpt\n", totalSmilePoint)
+			})
+			slog.DebugContext(s.meetingContext(), "Sent total smile point to all clients", "total_smile_point", totalSmilePoint)
 		// Ideaが送信された場合
 		case totalIdeas := <-s.ideaBroadcast:
-			s.mu.Lock()
-			totalIdeasMsg := Message{
+			s.broadcastAll(Message{
 				Type:       "idea",
 				TotalIdeas: totalIdeas,
-			}
-			for client := range s.clients {
-				s.sendMessage(client, totalIdeasMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent total ideas to all clients: %d ideas\n", totalIdeas)
+			})
+			slog.DebugContext(s.meetingContext(), "Sent total ideas to all clients", "total_ideas", totalIdeas)
 		// ImageUrlsが送信された場合
 		case imageUrls := <-s.imagesBroadcast:
-			s.mu.Lock()
-			imageUrlsMsg := Message{
-				Type:     "imageUrls",
+			s.broadcastAll(Message{
+				Type:      "imageUrls",
 				ImageUrls: imageUrls,
-			}
-			for client := range s.clients {
-				s.sendMessage(client, imageUrlsMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent a new image urls to all clients: %s\n", imageUrls)
+			})
+			slog.InfoContext(s.meetingContext(), "Sent new image urls to all clients", "images", len(imageUrls))
 		// ImageAnimalTypeが送信された場合
 		case imageAnimalType := <-s.imageAnimalTypeBroadcast:
-			s.mu.Lock()
-			imageAnimalTypeMsg := Message{
+			s.broadcastAll(Message{
 				Type:            "imageAnimalType",
 				ImageAnimalType: imageAnimalType,
-			}
-			for client := range s.clients {
-				s.sendMessage(client, imageAnimalTypeMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent a new image animal type to all clients: %s\n", imageAnimalType)
+			})
+			slog.DebugContext(s.meetingContext(), "Sent image animal type to all clients", "image_animal_type", imageAnimalType)
 		// Levelが送信された場合
 		case level := <-s.levelBroadcast:
-			s.mu.Lock()
-			levelMsg := Message{
+			s.broadcastAll(Message{
 				Type:  "level",
 				Level: level,
-			}
-			for client := range s.clients {
-				s.sendMessage(client, levelMsg)
-			}
-			s.mu.Unlock()
-			log.Printf("Sent current level to all clients: %d\n", level)
+			})
+			slog.InfoContext(s.meetingContext(), "Sent current level to all clients", "level", level)
 		// Timerの経過時間が送信された場合
-		case elapsedTime := <-s.timerBroadcast:
-			s.mu.Lock()
-			timerMsg := Message{
+		case tick := <-s.timerBroadcast:
+			msg := Message{
 				Type:  "timer",
-				Timer: fmt.Sprintf("%02d:%02d:%02d", int(elapsedTime)/3600, int(elapsedTime)%3600/60, int(elapsedTime)%60),
+				Timer: formatClock(tick.elapsed),
+			}
+			if tick.limited {
+				msg.Countdown = formatClock(tick.remaining)
+				msg.RemainingSeconds = tick.remaining
 			}
-			for client := range s.clients {
-				s.sendMessage(client, timerMsg)
+			msg.Section = tick.section
+			s.broadcastAll(msg)
+			if s.timerLogSampler.Allow() {
+				slog.DebugContext(s.meetingContext(), "Sent elapsed time to all clients", "elapsed_seconds", tick.elapsed, "remaining_seconds", tick.remaining)
 			}
+		// 終了した会議のルームが削除された場合
+		case <-s.stopped:
+			return
+		// 定期的に各Clientのlatencyを送信
+		case <-latencyTicker.C:
+			s.mu.Lock()
+			hasClients := len(s.clients) > 0
+			latencies := s.latencies()
 			s.mu.Unlock()
-			log.Printf("Sent elapsed time to all clients: %02d:%02d:%02d\n", int(elapsedTime)/3600, int(elapsedTime)%3600/60, int(elapsedTime)%60)
+			if hasClients {
+				s.broadcastAll(Message{
+					Type:      "latency",
+					Latencies: latencies,
+				})
+			}
+		}
+	}
+}
+
+// 全てのClientにメッセージを送信し、所要時間を記録する
+func (s *Server) broadcastAll(msg Message) {
+	start := time.Now()
+	s.mu.Lock()
+	for client := range s.clients {
+		s.sendMessage(client, msg)
+	}
+	s.mu.Unlock()
+	metrics.BroadcastDuration.WithLabelValues(msg.Type).Observe(time.Since(start).Seconds())
+}
+
+// 一定間隔でpingを送信する。doneが閉じられるか送信に失敗したら終了
+func (s *Server) keepAlive(ctx context.Context, conn *websocket.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(s.pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			// 送信時刻をpayloadに入れておき、pongで返ってきた値から往復時間を計算する
+			payload := []byte(strconv.FormatInt(time.Now().UnixNano(), 10))
+			if err := conn.WriteControl(websocket.PingMessage, payload, time.Now().Add(writeWait)); err != nil {
+				slog.InfoContext(ctx, "Error sending ping", "error", err)
+				// ReadMessageをエラーにして、HandleClientsの後処理で切断させる
+				conn.Close()
+				return
+			}
 		}
 	}
 }
 
+// pongのpayload(ping送信時刻)からlatencyを更新
+func (s *Server) updateLatency(conn *websocket.Conn, appData string) {
+	sentAt, err := strconv.ParseInt(appData, 10, 64)
+	if err != nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c, ok := s.clients[conn]; ok {
+		c.latency = time.Since(time.Unix(0, sentAt))
+	}
+}
+
+// 呼び出し側でs.muをロックしておくこと
+func (s *Server) latencies() []Latency {
+	latencies := make([]Latency, 0, len(s.clients))
+	for _, c := range s.clients {
+		latencies = append(latencies, Latency{
+			Nickname:  c.nickname,
+			LatencyMs: c.latency.Milliseconds(),
+		})
+	}
+	return latencies
+}
+
 func (s *Server) broadcastClientsList() {
 	s.mu.Lock()
 	clientNicknames := make([]string, 0, len(s.clients))
-	for _, nickname := range s.clients {
-		clientNicknames = append(clientNicknames, nickname)
+	for _, c := range s.clients {
+		clientNicknames = append(clientNicknames, c.nickname)
 	}
 	s.mu.Unlock()
 	clientListMsg := Message{
 		Type:        "clientsList",
 		ClientsList: clientNicknames,
 	}
-	s.mu.Lock()
-	for client := range s.clients {
-		s.sendMessage(client, clientListMsg)
+	s.broadcastAll(clientListMsg)
+	slog.InfoContext(s.meetingContext(), "Broadcasting clients list", "clients", clientNicknames)
+}
+
+// イベントを受け付けられなかったことを送信元のClientに通知する
+func (s *Server) sendBusy(ctx context.Context, conn *websocket.Conn, rejected Message) {
+	slog.WarnContext(ctx, "Rejected event: persistence queue is overloaded", "type", rejected.Type, "queue_depth", s.queue.Depth())
+	metrics.MessagesDropped.WithLabelValues("overloaded").Inc()
+	busyMsg := Message{
+		Type:      "busy",
+		Timestamp: time.Now(),
+		Text:      rejected.Type,
 	}
+	s.mu.Lock()
+	s.sendMessage(conn, busyMsg)
 	s.mu.Unlock()
-	log.Println("Broadcasting clients list:", clientNicknames)
 }
 
-func (s *Server) sendMessage(conn *websocket.Conn, msg Message) {
-	data, err := json.Marshal(msg)
-	if err != nil {
-		log.Println("Error marshaling message: ", err)
-		return
-	}
-	if err := conn.WriteMessage(websocket.TextMessage, data); err != nil {
-		log.Println("Error sending message: ", err)
-		return
+// 管理者以外からの会議の状態や設定の変更は受け付けず、表示を戻すため現在の値を送る
+func (s *Server) rejectForbidden(ctx context.Context, conn *websocket.Conn, rejected Message) {
+	slog.InfoContext(ctx, "Rejected change from non-admin", "type", rejected.Type)
+	metrics.MessagesDropped.WithLabelValues("forbidden").Inc()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch rejected.Type {
+	case "meetingStatus":
+		s.sendMessage(conn, s.meetingStatusMessage())
+	case "imageAnimalType":
+		s.sendMessage(conn, Message{Type: "imageAnimalType", ImageAnimalType: s.state.ImageAnimalType})
 	}
 }
 
-func generatePromptForLevel(level int, animalType string) string {
+func generatePromptForLevel(level, levelCount int, animalType string) string {
+	if levelCount < 2 {
+		levelCount = event.MaxLevel
+	}
 	if level < 1 {
 		level = 1
-	} else if level > 10 {
-		level = 10
+	} else if level > levelCount {
+		level = levelCount
 	}
 
 	basePrompt := fmt.Sprintf(
-        "high resolution, a single %s, no other animals, no duplicates, no extra figures, no humans, neutral plain background, focus on the animal, natural lighting",
-        animalType,
-    )
+		"high resolution, a single %s, no other animals, no duplicates, no extra figures, no humans, neutral plain background, focus on the animal, natural lighting",
+		animalType,
+	)
 
 	descriptions := []string{
 		"A small, tired animal, looking peaceful but weak, low energy,",
@@ -524,22 +867,24 @@ func generatePromptForLevel(level int, animalType string) string {
 	}
 
 	growthEnergyPrompt := fmt.Sprintf(
-		"Growth level is %d out of 10, Energy level is %d out of 10.",
-		level, level,
+		"Growth level is %d out of %d, Energy level is %d out of %d.",
+		level, levelCount, level, levelCount,
 	)
 
-	prompt := fmt.Sprintf("%s %s %s", basePrompt, descriptions[level-1], growthEnergyPrompt)
+	// 段階数が少ない場合は、説明を均等に間引いて使う
+	description := descriptions[(level-1)*(len(descriptions)-1)/(levelCount-1)]
+	prompt := fmt.Sprintf("%s %s %s", basePrompt, description, growthEnergyPrompt)
 	return prompt
 }
 
-func generateImageUrl(level int, animalType string) (prompt string, imageUrl string, err error) {
-	generatedPrompt := generatePromptForLevel(level, animalType)
+func generateImageUrl(provider config.ImageProvider, level int, settings event.MeetingSettings) (prompt string, imageUrl string, err error) {
+	generatedPrompt := generatePromptForLevel(level, settings.LevelCount, settings.ImageAnimalType)
 
 	reqBody := map[string]interface{}{
 		"prompt":          generatedPrompt,
 		"model":           "dall-e-3",
 		"n":               1,
-		"size":            "1024x1024",
+		"size":            settings.ImageSize,
 		"quality":         "standard",
 		"response_format": "url",
 		"style":           "natural",
@@ -550,11 +895,11 @@ func generateImageUrl(level int, animalType string) (prompt string, imageUrl str
 		return "", "", err
 	}
 
-	req, err := http.NewRequest("POST", os.Getenv("DALLE_API_ENDPOINT"), bytes.NewBuffer(jsonBody))
+	req, err := http.NewRequest("POST", provider.Endpoint, bytes.NewBuffer(jsonBody))
 	if err != nil {
 		return "", "", err
 	}
-	req.Header.Set("Authorization", "Bearer "+os.Getenv("DALLE_API_KEY"))
+	req.Header.Set("Authorization", "Bearer "+provider.ApiKey)
 	req.Header.Set("Content-Type", "application/json")
 
 	client := &http.Client{}
@@ -567,7 +912,7 @@ func generateImageUrl(level int, animalType string) (prompt string, imageUrl str
 	if resp.StatusCode != http.StatusOK {
 		bodyBytes, _ := io.ReadAll(resp.Body)
 		bodyString := string(bodyBytes)
-		log.Printf("Failed to generate image: %s", bodyString)
+		slog.Error("Image provider returned an error", "status", resp.StatusCode, "body", bodyString)
 		return "", "", err
 	}
 
@@ -0,0 +1,505 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"smile-sync/src/auth"
+	"smile-sync/src/config"
+	"smile-sync/src/event"
+	"smile-sync/src/firebase"
+	"smile-sync/src/origin"
+	"smile-sync/src/persistence"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// メモリ上のFirestore。イベント、スナップショットを会議ごとに保存する
+type memoryStorage struct {
+	mu        sync.Mutex
+	events    map[string][]event.Event
+	snapshots map[string]firebase.MeetingSnapshot
+}
+
+var storage struct {
+	mu      sync.Mutex
+	current *memoryStorage
+}
+
+func currentStorage() *memoryStorage {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	return storage.current
+}
+
+// 前のテストのgoroutineが残っていても競合しないよう、差し替えは最初に1回だけ行い、テストごとに保存先を切り替える
+func TestMain(m *testing.M) {
+	loadEvents = func(ctx context.Context, meetingId string, afterSeq int64) ([]event.Event, error) {
+		return currentStorage().loadEvents(meetingId, afterSeq), nil
+	}
+	saveSnapshot = func(ctx context.Context, snapshot firebase.MeetingSnapshot) error {
+		currentStorage().saveSnapshot(snapshot)
+		return nil
+	}
+	deleteSnapshot = func(ctx context.Context, meetingId string) error {
+		currentStorage().deleteSnapshot(meetingId)
+		return nil
+	}
+	loadActiveSnapshots = func(ctx context.Context) ([]firebase.MeetingSnapshot, error) {
+		return currentStorage().activeSnapshots(), nil
+	}
+	saveMeetingSummary = func(ctx context.Context, summary firebase.MeetingSummary) error {
+		return nil
+	}
+	os.Exit(m.Run())
+}
+
+func newMemoryStorage() *memoryStorage {
+	m := &memoryStorage{
+		events:    make(map[string][]event.Event),
+		snapshots: make(map[string]firebase.MeetingSnapshot),
+	}
+	storage.mu.Lock()
+	storage.current = m
+	storage.mu.Unlock()
+	return m
+}
+
+// persistence.Writer
+func (m *memoryStorage) write(ctx context.Context, meetingId string, events []event.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events[meetingId] = append(m.events[meetingId], events...)
+	return nil
+}
+
+func (m *memoryStorage) loadEvents(meetingId string, afterSeq int64) []event.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var events []event.Event
+	for _, ev := range m.events[meetingId] {
+		if ev.Seq > afterSeq {
+			events = append(events, ev)
+		}
+	}
+	return events
+}
+
+// Firestoreと同じく、firestore:"-"のMessagesは保存しない
+func (m *memoryStorage) saveSnapshot(snapshot firebase.MeetingSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot.Messages = nil
+	m.snapshots[snapshot.MeetingId] = snapshot
+}
+
+func (m *memoryStorage) deleteSnapshot(meetingId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.snapshots, meetingId)
+}
+
+func (m *memoryStorage) snapshot(meetingId string) (firebase.MeetingSnapshot, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	snapshot, ok := m.snapshots[meetingId]
+	return snapshot, ok
+}
+
+func (m *memoryStorage) activeSnapshots() []firebase.MeetingSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var snapshots []firebase.MeetingSnapshot
+	for _, snapshot := range m.snapshots {
+		if snapshot.IsMeetingActive || snapshot.IsMeetingPaused || snapshot.IsScheduled {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots
+}
+
+type testHub struct {
+	*Hub
+	server  *httptest.Server
+	signer  *auth.TokenSigner
+	queue   *persistence.Queue
+	storage *memoryStorage
+}
+
+func testWebsocketConfig() config.Websocket {
+	return config.Websocket{
+		PingInterval:     50 * time.Millisecond,
+		PongWait:         time.Second,
+		SnapshotInterval: time.Hour,
+	}
+}
+
+// メモリ上のFirestoreを使うHubを作り、/wsで接続を受け付ける。
+// prepareがあれば、復元する前に保存済みのスナップショットやイベントを用意できる
+func newTestHub(t *testing.T, ws config.Websocket, prepare func(*memoryStorage)) *testHub {
+	t.Helper()
+	store := newMemoryStorage()
+	if prepare != nil {
+		prepare(store)
+	}
+	queue := persistence.NewQueue(store.write, persistence.Options{
+		BatchSize:     10,
+		FlushInterval: 10 * time.Millisecond,
+		MaxDepth:      1000,
+		MaxRetries:    0,
+		RetryBackoff:  time.Millisecond,
+	})
+	queue.Start()
+	t.Cleanup(func() { queue.Close(context.Background()) })
+
+	origins, err := origin.Parse(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer := auth.NewTokenSigner([]byte("secret"))
+	authn := auth.NewAuthenticator(nil, nil, auth.Options{Signer: signer, SessionTTL: time.Hour})
+	hub := NewHub(queue, ws, config.ImageProvider{}, origins, authn, nil)
+	if err := hub.Restore(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", hub.HandleClients)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return &testHub{Hub: hub, server: server, signer: signer, queue: queue, storage: store}
+}
+
+// meetingIdが空でなければ、その会議にのみ参加できるセッション
+func (th *testHub) session(t *testing.T, nickname, role, meetingId string) string {
+	t.Helper()
+	token, err := th.signer.Sign(auth.Claims{Kind: auth.KindSession, Nickname: nickname, Role: role, MeetingId: meetingId}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func (th *testHub) url(token, meetingId string) string {
+	query := url.Values{}
+	if token != "" {
+		query.Set("token", token)
+	}
+	if meetingId != "" {
+		query.Set("meeting", meetingId)
+	}
+	return "ws" + strings.TrimPrefix(th.server.URL, "http") + "/ws?" + query.Encode()
+}
+
+// 接続してNicknameを送る
+func (th *testHub) dial(t *testing.T, token, meetingId, nickname string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(th.url(token, meetingId), nil)
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	if err := conn.WriteJSON(Message{Nickname: nickname, ClientId: "client-" + nickname}); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+// 指定した種類のメッセージが届くまで読み進める
+func expect(t *testing.T, conn *websocket.Conn, msgType string) Message {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("waiting for %q: %v", msgType, err)
+		}
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type == msgType {
+			return msg
+		}
+	}
+}
+
+// 接続が閉じられるまで読み進め、closeのコードを返す
+func expectClose(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for {
+		_, _, err := conn.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var closeErr *websocket.CloseError
+		if !errors.As(err, &closeErr) {
+			t.Fatalf("connection was not closed: %v", err)
+		}
+		return closeErr.Code
+	}
+}
+
+// 読み込みを続けてpingに応答する。読んだメッセージは捨てる
+func keepReading(conn *websocket.Conn) {
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func eventually(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+var admin = Actor{Nickname: "admin", Via: "test"}
+
+// pongが返ってこない接続は切断し、参加者から外す
+func TestHeartbeatTimeoutRemovesClient(t *testing.T) {
+	ws := testWebsocketConfig()
+	ws.PingInterval = 20 * time.Millisecond
+	ws.PongWait = 200 * time.Millisecond
+	th := newTestHub(t, ws, nil)
+	room := th.defaultRoom
+
+	alive := th.dial(t, th.session(t, "alice", auth.RoleMember, ""), "", "alice")
+	keepReading(alive)
+	// 読み込まない接続はpingに応答しない
+	th.dial(t, th.session(t, "bob", auth.RoleMember, ""), "", "bob")
+
+	eventually(t, "both clients to join", func() bool { return len(room.Participants()) == 2 })
+	eventually(t, "the silent client to be removed", func() bool {
+		participants := room.Participants()
+		return len(participants) == 1 && participants[0].Nickname == "alice"
+	})
+	// pongを返している接続はpongWaitを過ぎても残る
+	time.Sleep(2 * ws.PongWait)
+	if participants := room.Participants(); len(participants) != 1 {
+		t.Errorf("participants = %+v, want only alice", participants)
+	}
+}
+
+// 読み込まないClientがいても他のClientへの送信は止まらず、送信が追いつかないClientは切断する
+func TestSlowClientDoesNotBlockBroadcasts(t *testing.T) {
+	ws := testWebsocketConfig()
+	ws.PongWait = time.Minute // pongの期限ではなく、送信の詰まりで切断されることを確かめる
+	th := newTestHub(t, ws, nil)
+	room := th.defaultRoom
+
+	alice := th.dial(t, th.session(t, "alice", auth.RoleMember, ""), "", "alice")
+	expect(t, alice, "imageAnimalType")
+	th.dial(t, th.session(t, "bob", auth.RoleMember, ""), "", "bob")
+	eventually(t, "both clients to join", func() bool { return len(room.Participants()) == 2 })
+	keepReading(alice)
+
+	// 送信バッファとソケットのバッファを溢れさせる
+	text := strings.Repeat("x", 64*1024)
+	sent := make(chan struct{})
+	go func() {
+		defer close(sent)
+		for range 2 * sendBuffer {
+			room.broadcastAll(Message{Type: "message", Text: text})
+		}
+	}()
+	select {
+	case <-sent:
+	case <-time.After(writeWait):
+		t.Fatal("broadcasts were blocked by the slow client")
+	}
+	eventually(t, "the slow client to be removed", func() bool {
+		participants := room.Participants()
+		return len(participants) == 1 && participants[0].Nickname == "alice"
+	})
+}
+
+// シャットダウンではClientに通知して切断し、受け付けたイベントを書き込んでから会議の状態を保存する
+func TestShutdownDrainsClients(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	room := th.defaultRoom
+	if err := room.Start(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	conn := th.dial(t, th.session(t, "alice", auth.RoleMember, ""), "", "alice")
+	expect(t, conn, "imageAnimalType") // 初期状態の送信の最後
+	if err := conn.WriteJSON(Message{Type: "smilePoint", Point: 3}); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, conn, "smilePoint")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := th.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+	expect(t, conn, "serverShutdown")
+	if code := expectClose(t, conn); code != websocket.CloseGoingAway {
+		t.Errorf("close code = %d, want %d", code, websocket.CloseGoingAway)
+	}
+
+	// シャットダウン後の接続は受け付けない
+	late := th.dial(t, th.session(t, "bob", auth.RoleMember, ""), "", "bob")
+	if code := expectClose(t, late); code != websocket.CloseTryAgainLater {
+		t.Errorf("close code after shutdown = %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+
+	if err := th.queue.Close(ctx); err != nil {
+		t.Fatalf("queue.Close() = %v", err)
+	}
+	snapshot, ok := th.storage.snapshot(room.meetingId)
+	if !ok || !snapshot.IsMeetingActive || snapshot.TotalSmilePoint != 3 {
+		t.Errorf("snapshot = %+v, %v", snapshot.State, ok)
+	}
+	if events := th.storage.loadEvents(room.meetingId, 0); len(events) != int(snapshot.Seq) {
+		t.Errorf("saved %d events, want %d", len(events), snapshot.Seq)
+	}
+}
+
+// セッションのない接続は受け付けず、会議の開始・中断・終了は管理者からのみ受け付ける
+func TestMeetingStatusRequiresAdmin(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	room := th.defaultRoom
+
+	_, resp, err := websocket.DefaultDialer.Dial(th.url("", ""), nil)
+	if err == nil || resp == nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("Dial without session: err = %v, resp = %v", err, resp)
+	}
+
+	member := th.dial(t, th.session(t, "alice", auth.RoleMember, ""), "", "alice")
+	expect(t, member, "imageAnimalType")
+	if err := member.WriteJSON(Message{Type: "meetingStatus", IsMeetingActive: true}); err != nil {
+		t.Fatal(err)
+	}
+	// 表示を戻すため、現在の状態が送り返される
+	if msg := expect(t, member, "meetingStatus"); msg.IsMeetingActive {
+		t.Error("meeting was started by a member")
+	}
+	if room.Status().IsMeetingActive {
+		t.Error("meeting is active after a member's request")
+	}
+
+	host := th.dial(t, th.session(t, "admin", auth.RoleAdmin, ""), "", "admin")
+	expect(t, host, "imageAnimalType")
+	if err := host.WriteJSON(Message{Type: "meetingStatus", IsMeetingActive: true}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := expect(t, member, "meetingStatus"); !msg.IsMeetingActive {
+		t.Error("meeting was not started by an admin")
+	}
+}
+
+// 会議の設定(画像の動物の種類)の変更は管理者からのみ受け付ける
+func TestImageAnimalTypeRequiresAdmin(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	room := th.defaultRoom
+	before := room.Settings().ImageAnimalType
+
+	member := th.dial(t, th.session(t, "alice", auth.RoleMember, ""), "", "alice")
+	expect(t, member, "imageAnimalType")
+	if err := member.WriteJSON(Message{Type: "imageAnimalType", ImageAnimalType: "penguin"}); err != nil {
+		t.Fatal(err)
+	}
+	// 表示を戻すため、現在の値が送り返される
+	if msg := expect(t, member, "imageAnimalType"); msg.ImageAnimalType != before {
+		t.Errorf("imageAnimalType sent back = %q, want %q", msg.ImageAnimalType, before)
+	}
+	if got := room.Settings().ImageAnimalType; got != before {
+		t.Errorf("ImageAnimalType = %q after a member's request, want %q", got, before)
+	}
+
+	host := th.dial(t, th.session(t, "admin", auth.RoleAdmin, ""), "", "admin")
+	expect(t, host, "imageAnimalType")
+	if err := host.WriteJSON(Message{Type: "imageAnimalType", ImageAnimalType: "penguin"}); err != nil {
+		t.Fatal(err)
+	}
+	if msg := expect(t, member, "imageAnimalType"); msg.ImageAnimalType != "penguin" {
+		t.Errorf("imageAnimalType = %q, want the admin's change", msg.ImageAnimalType)
+	}
+}
+
+// ミュート、集計からの除外、締め出しをされた参加者のイベントは受け付けない
+func TestModerationBlocksRestrictedParticipants(t *testing.T) {
+	th := newTestHub(t, testWebsocketConfig(), nil)
+	meetingId := th.CreateMeeting(context.Background(), admin)
+	room, _ := th.Meeting(meetingId)
+	if err := room.Start(context.Background(), admin); err != nil {
+		t.Fatal(err)
+	}
+	host := th.dial(t, th.session(t, "admin", auth.RoleAdmin, ""), meetingId, "admin")
+	expect(t, host, "imageAnimalType")
+	bobToken := th.session(t, "bob", auth.RoleMember, meetingId)
+	bob := th.dial(t, bobToken, "", "bob")
+	expect(t, bob, "imageAnimalType")
+
+	// ミュートされるとメッセージを送れない
+	if err := room.Moderate(context.Background(), admin, ActionMute, "bob", "spam"); err != nil {
+		t.Fatal(err)
+	}
+	if msg := expect(t, bob, "moderation"); msg.Action != ActionMute || msg.Text != "spam" {
+		t.Errorf("moderation = %+v", msg)
+	}
+	bob.WriteJSON(Message{Type: "message", Text: "hello"})
+	if msg := expect(t, bob, "moderation"); msg.Action != ActionMute {
+		t.Errorf("rejected message: moderation = %+v", msg)
+	}
+
+	// 除外されるとSmilePointを集計しない
+	if err := room.Moderate(context.Background(), admin, ActionExclude, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	expect(t, bob, "moderation")
+	bob.WriteJSON(Message{Type: "smilePoint", Point: 5})
+	if msg := expect(t, bob, "moderation"); msg.Action != ActionExclude {
+		t.Errorf("rejected smile point: moderation = %+v", msg)
+	}
+	host.WriteJSON(Message{Type: "smilePoint", Point: 1})
+	if msg := expect(t, host, "smilePoint"); msg.TotalSmilePoint != 1 {
+		t.Errorf("TotalSmilePoint = %d, want only the admin's point", msg.TotalSmilePoint)
+	}
+
+	// 締め出されると切断され、再接続もできない
+	if err := room.Moderate(context.Background(), admin, ActionBan, "bob", ""); err != nil {
+		t.Fatal(err)
+	}
+	if code := expectClose(t, bob); code != websocket.ClosePolicyViolation {
+		t.Errorf("close code = %d, want %d", code, websocket.ClosePolicyViolation)
+	}
+	again := th.dial(t, bobToken, "", "bob")
+	if msg := expect(t, again, "moderation"); msg.Action != ActionBan {
+		t.Errorf("moderation on join = %+v", msg)
+	}
+	if code := expectClose(t, again); code != websocket.ClosePolicyViolation {
+		t.Errorf("close code on join = %d, want %d", code, websocket.ClosePolicyViolation)
+	}
+	eventually(t, "bob to be removed", func() bool { return len(room.Participants()) == 1 })
+}
+
+// 経過時間のログは会議ごとに間引き、他の会議のログを抑えない
+func TestTimerLogSamplerPerRoom(t *testing.T) {
+	busy := NewServer("busy", nil, testWebsocketConfig(), config.ImageProvider{})
+	quiet := NewServer("quiet", nil, testWebsocketConfig(), config.ImageProvider{})
+	for range 10 {
+		busy.timerLogSampler.Allow()
+	}
+	if !quiet.timerLogSampler.Allow() {
+		t.Error("the first timer log of another meeting was suppressed")
+	}
+}
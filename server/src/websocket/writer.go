@@ -0,0 +1,110 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"smile-sync/src/metrics"
+
+	"github.com/gorilla/websocket"
+)
+
+// Clientごとに溜めておける未送信のメッセージ数。溢れたら送信が追いついていないとみなして切断する
+const sendBuffer = 256
+
+// writePumpに渡すメッセージ。closeCodeがあればcloseを送って接続を閉じる
+type outgoing struct {
+	msgType   string
+	data      []byte
+	closeCode int
+	closeText string
+}
+
+// 呼び出し側でs.muをロックしておくこと。
+// 登録済みのClientへはwritePumpを通して送るので、遅いClientがいてもブロックしない
+func (s *Server) sendMessage(conn *websocket.Conn, msg Message) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		slog.ErrorContext(s.meetingContext(), "Error marshaling message", "type", msg.Type, "error", err)
+		return
+	}
+	out := outgoing{msgType: msg.Type, data: data}
+	c, ok := s.clients[conn]
+	if !ok {
+		// 登録前の接続は他に書き込むgoroutineがないので、ここで書き込む
+		if err := writeOutgoing(conn, out); err != nil {
+			slog.WarnContext(s.meetingContext(), "Error sending message", "type", msg.Type, "error", err)
+			metrics.MessagesDropped.WithLabelValues("send_error").Inc()
+		}
+		return
+	}
+	if c.closing {
+		return
+	}
+	select {
+	case c.send <- out:
+	default:
+		// 送信が追いついていない(相手が応答しない)接続は閉じる。handleClientの後処理で参加者から外す
+		slog.WarnContext(s.meetingContext(), "Disconnecting slow client", "conn_id", c.id, "type", msg.Type, "pending", len(c.send))
+		metrics.MessagesDropped.WithLabelValues("slow_client").Inc()
+		c.closing = true
+		conn.Close()
+	}
+}
+
+// 送信済みのメッセージの後にcloseを送って接続を閉じる。呼び出し側でs.muをロックしておくこと
+func (s *Server) closeConn(conn *websocket.Conn, code int, text string) {
+	c, ok := s.clients[conn]
+	if !ok {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), time.Now().Add(writeWait))
+		conn.Close()
+		return
+	}
+	if c.closing {
+		return
+	}
+	c.closing = true
+	select {
+	case c.send <- outgoing{closeCode: code, closeText: text}:
+	default:
+		conn.Close()
+	}
+}
+
+// Clientへの書き込みを1つのgoroutineで行う。書き込みに失敗するか期限を過ぎたら接続を閉じ、
+// ReadMessageをエラーにしてhandleClientの後処理で切断させる。doneが閉じられたら終了
+func (s *Server) writePump(ctx context.Context, conn *websocket.Conn, send <-chan outgoing, done <-chan struct{}) {
+	for {
+		select {
+		case out := <-send:
+			if err := writeOutgoing(conn, out); err != nil {
+				slog.WarnContext(ctx, "Error sending message", "type", out.msgType, "error", err)
+				metrics.MessagesDropped.WithLabelValues("send_error").Inc()
+				conn.Close()
+				return
+			}
+			if out.closeCode != 0 {
+				conn.Close()
+				return
+			}
+		case <-done:
+			return
+		}
+	}
+}
+
+// 期限を付けて1つのメッセージを書き込む
+func writeOutgoing(conn *websocket.Conn, out outgoing) error {
+	deadline := time.Now().Add(writeWait)
+	if out.closeCode != 0 {
+		return conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(out.closeCode, out.closeText), deadline)
+	}
+	conn.SetWriteDeadline(deadline)
+	if err := conn.WriteMessage(websocket.TextMessage, out.data); err != nil {
+		return err
+	}
+	metrics.MessagesSent.WithLabelValues(out.msgType).Inc()
+	return nil
+}